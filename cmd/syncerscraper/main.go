@@ -9,10 +9,11 @@ import (
 )
 
 var (
-	outDir         string
-	syncerAddr     string
-	scrapeInterval int
-	timeOutMinutes int
+	outDir           string
+	syncerAddr       string
+	scrapeInterval   int
+	timeOutMinutes   int
+	scrapeConfigPath string
 )
 
 func init() {
@@ -20,13 +21,33 @@ func init() {
 	flag.StringVar(&syncerAddr, "synceraddr", "", "the address (host:port) of the syncer ")
 	flag.IntVar(&scrapeInterval, "interval", 1, "the scraping interval")
 	flag.IntVar(&timeOutMinutes, "timeout", 60, "the scraping interval")
+	flag.StringVar(&scrapeConfigPath, "config", "", "the path to the yaml file that lists additional scrape targets")
 	flag.Parse()
 }
 
 func main() {
-	log.Printf("will write syncer metrics to %s", outDir)
-	go vcbench.ScrapeSyncer(make(chan struct{}), outDir, syncerAddr, scrapeInterval)
+	var targets []vcbench.ScrapeTarget
+	if syncerAddr != "" {
+		targets = append(targets, vcbench.ScrapeTarget{
+			Name:     "syncer",
+			Addr:     syncerAddr,
+			Interval: scrapeInterval,
+		})
+	}
+	if scrapeConfigPath != "" {
+		cfg, err := vcbench.LoadScrapeConfig(scrapeConfigPath)
+		if err != nil {
+			log.Fatalf("fail to load scrape config(%s): %s", scrapeConfigPath, err)
+		}
+		targets = append(targets, cfg.Targets...)
+	}
+	scraper, err := vcbench.NewScraper(outDir, targets)
+	if err != nil {
+		log.Fatalf("fail to initialize scraper: %s", err)
+	}
+	log.Printf("will write metrics of %d targets to %s", len(targets), outDir)
+	scraper.Start()
 	<-time.After(time.Minute * time.Duration(timeOutMinutes))
+	scraper.Stop()
 	log.Print("done")
-	return
 }
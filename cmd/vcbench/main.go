@@ -3,9 +3,15 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/charleszheng44/vc-bench/pkg/tenant"
@@ -24,20 +30,125 @@ var (
 	tenantInterval         int
 	podInterval            int
 	syncerStandaloneMinute int
+	scrapeConfigPath       string
+	superKbCfgPath         string
+	scrapeSuperApiserver   bool
+	scrapeTenantApiservers bool
+	syncerPod              string
+	syncerNamespace        string
+	syncerMetricsPort      string
+	discoverVirtualKubelet bool
+	profileConfigPath      string
+	collectEvents          bool
+	runID                  string
 
 	targetNs         string
+	cleanRunID       string
+	cleanAllRuns     bool
+	cleanDryRun      bool
+	cleanSuperKbCfg  string
+	cleanWorkers     int
+	cleanTimeout     time.Duration
+	cleanOutDataDir  string
+	cleanDeleteNodes bool
 	tenantRangeStart int
 	tenantRangeEnd   int
 
 	kubeconfigPathBase string
+	existingNs         string
+	tenantIdentity     string
+	baseAPF            string
+	baseAPFShares      int
+	baseAPFQueues      int
+	baseReadyTimeout   time.Duration
+	baseScrapeAPIs     bool
+	baseScrapeInterval int
+	baseTenantJson     string
+	baseOutDataDir     string
+
+	baseCleanKbCfgPath string
+	baseCleanWorkers   int
+	baseCleanTimeout   time.Duration
+	baseCleanRunID     string
 	numeTenants        int
 	numePodBase        int
 	podIntervalBase    int
 	shareNs            bool
 
+	traceOutPath string
+	reportScope  string
+	importOutDir string
+
+	syncerLogFile      string
+	syncerLogKbCfgPath string
+	syncerLogPod       string
+	syncerLogNs        string
+	syncerLogContainer string
+	syncerLogMarkers   string
+
+	overheadBaseDir     string
+	overheadVCDir       string
+	overheadTenantJson  string
+	overheadKbCfgPath   string
+	overheadTenantKbCfg string
+	overheadPodInterval int
+	overheadOutDataDir  string
+
+	provisionKbCfgPath string
+	provisionCount     int
+	provisionStart     int
+	provisionPrefix    string
+	provisionNsPrefix  string
+	provisionCV        string
+	provisionWorkers   int
+	provisionTimeout   time.Duration
+
+	provBenchKbCfgPath  string
+	provBenchSuperKbCfg string
+	provBenchCount      int
+	provBenchPrefix     string
+	provBenchNsPrefix   string
+	provBenchCV         string
+	provBenchRate       float64
+	provBenchTimeout    time.Duration
+	provBenchOutDataDir string
+	provBenchCleanUp    bool
+
+	nodesKbCfgPath  string
+	nodesCount      int
+	nodesPrefix     string
+	nodesCPU        string
+	nodesMemory     string
+	nodesPods       string
+	nodesHeartbeat  time.Duration
+	nodesLatency    string
+	nodesKeepOnExit bool
+
+	simTenantJson     string
+	simNumVC          int
+	simTenantInterval int
+	simPodInterval    int
+	simQueue          string
+	simWorkers        int
+	simServiceTime    string
+	simReadyLatency   string
+	simTimeScale      float64
+	simOutDataDir     string
+
 	runBenchFlagSet     *flag.FlagSet
 	runBaseBenchFlagSet *flag.FlagSet
 	cleanupFlagSet      *flag.FlagSet
+	baseCleanFlagSet    *flag.FlagSet
+	traceFlagSet        *flag.FlagSet
+	reportFlagSet       *flag.FlagSet
+	importFlagSet       *flag.FlagSet
+	syncerLogFlagSet    *flag.FlagSet
+	overheadFlagSet     *flag.FlagSet
+	provisionFlagSet    *flag.FlagSet
+	deprovisionFlagSet  *flag.FlagSet
+	provBenchFlagSet    *flag.FlagSet
+	nodesFlagSet        *flag.FlagSet
+	simulateFlagSet     *flag.FlagSet
 )
 
 const TimeOutputFmt = "20101010150405"
@@ -55,6 +166,16 @@ func init() {
 	runBaseBenchFlagSet.IntVar(&numeTenants, "numTenants", 1, "number of pods to be submitted")
 	runBaseBenchFlagSet.IntVar(&podIntervalBase, "podInterval", 0, "pod submission interval")
 	runBaseBenchFlagSet.BoolVar(&shareNs, "shareNs", false, "if use a shared benchmark namespace")
+	runBaseBenchFlagSet.StringVar(&tenantIdentity, "tenantIdentity", vcbench.TenantIdentityShared, "the identity of each tenant, one of 'shared' (the kubeconfig), 'token' (the token of a per-tenant serviceaccount) or 'impersonate' (impersonate a per-tenant serviceaccount)")
+	runBaseBenchFlagSet.StringVar(&existingNs, "existingNs", vcbench.ExistingNsFail, "how to handle an existing benchmark namespace, one of 'fail', 'reuse' or 'recreate'")
+	runBaseBenchFlagSet.StringVar(&baseAPF, "apf", "", "if set, map each tenant to its own flow of API Priority and Fairness during the run, one of 'shared' (one priority level for all tenants) or 'isolated' (one priority level per tenant), requires a per-tenant -tenantIdentity")
+	runBaseBenchFlagSet.IntVar(&baseAPFShares, "apfShares", 10, "the assured concurrency shares of each priority level created by -apf")
+	runBaseBenchFlagSet.IntVar(&baseAPFQueues, "apfQueues", 64, "the number of queues of each priority level created by -apf")
+	runBaseBenchFlagSet.BoolVar(&baseScrapeAPIs, "scrapeApiserver", false, "if scrape metrics from the apiserver, always true if -apf is set")
+	runBaseBenchFlagSet.IntVar(&baseScrapeInterval, "scrapeInterval", 20, "the interval for scraping metrics from the apiserver")
+	runBaseBenchFlagSet.StringVar(&baseTenantJson, "tenantJson", "", "the path to the tenant json file, if set, each tenant submits the number of pods in the file, and -numPod and -numTenants are ignored")
+	runBaseBenchFlagSet.DurationVar(&baseReadyTimeout, "readyTimeout", vcbench.DefaultReadyTimeout, "the timeout of waiting for the submitted pods to be ready once all pods are submitted")
+	runBaseBenchFlagSet.StringVar(&baseOutDataDir, "outDataDir", "", "the path to the directory that will store benchmark data, default to base-pod<numPod>-tenants<numTenants>-podsleep<podInterval>-shareNs-<shareNs>")
 
 	// command options for subcommand "run"
 	runBenchFlagSet = flag.NewFlagSet("run", flag.ExitOnError)
@@ -67,17 +188,127 @@ func init() {
 	runBenchFlagSet.IntVar(&scrapeKubeletInterval, "scrapeKubeletInterval", 30, "The interval for scraping metrics from kubelet")
 	runBenchFlagSet.IntVar(&tenantInterval, "tntintvl", 0, "The submission interval(milliseconds) among tenants")
 	runBenchFlagSet.IntVar(&podInterval, "podintvl", 0, "The submission interval(milliseconds) of pods in one tenant")
+	runBenchFlagSet.StringVar(&scrapeConfigPath, "scrapeConfig", "", "The path to the yaml file that lists additional scrape targets")
+	runBenchFlagSet.StringVar(&superKbCfgPath, "superkbcfg", "", "The kubeconfig file of the super cluster, default to the tenantkbcfg")
+	runBenchFlagSet.BoolVar(&scrapeSuperApiserver, "scrapeSuperApiserver", false, "If scrape metrics from the apiserver of the super cluster")
+	runBenchFlagSet.BoolVar(&scrapeTenantApiservers, "scrapeTenantApiservers", false, "If scrape metrics from the apiserver of each vc")
+	runBenchFlagSet.StringVar(&syncerPod, "syncerPod", "", "The name of the syncer pod scraped through the apiserver of the super cluster if syncerAddr is not set, e.g. vc-syncer-0")
+	runBenchFlagSet.StringVar(&syncerNamespace, "syncerNamespace", "vc-manager", "The namespace of the syncer pod")
+	runBenchFlagSet.StringVar(&syncerMetricsPort, "syncerMetricsPort", "", "The metrics port of the syncer pod")
+	runBenchFlagSet.BoolVar(&discoverVirtualKubelet, "discoverVirtualKubelets", false, "If scrape every virtual-kubelet node through the apiserver of the super cluster if kubeletAddr is not set")
+	runBenchFlagSet.StringVar(&profileConfigPath, "profileConfig", "", "The path to the yaml file that lists the targets to be profiled through /debug/pprof")
+	runBenchFlagSet.BoolVar(&collectEvents, "collectEvents", true, "If collect the events of the benchmark namespaces on every vc and the super cluster")
+	runBenchFlagSet.StringVar(&runID, "runID", "", "The id of the run that labels every created object, generated if not set")
 	runBenchFlagSet.IntVar(&syncerStandaloneMinute, "syncer-alone-minutes", 5, "Number of minutes for syncer to standalone after podbench successfully completing")
 
 	// command options for subcommand "clean"
 	cleanupFlagSet = flag.NewFlagSet("clean", flag.ExitOnError)
 	cleanupFlagSet.StringVar(&targetNs, "targetNs", vcbench.DefaultBenchNamespace, "")
+	cleanupFlagSet.StringVar(&tenantsKbCfgPath, "tenantkbcfg", defaultTenantKbCfgPath, "The kubeconfig file of the k8s that holds tenant masters ")
+	cleanupFlagSet.StringVar(&cleanSuperKbCfg, "superkbcfg", "", "The kubeconfig file of the super cluster, used to verify the mapped namespaces are removed, default to the tenantkbcfg")
+	cleanupFlagSet.IntVar(&cleanWorkers, "workers", vcbench.DefaultCleanUpWorkers, "The number of vc cleaned up concurrently")
+	cleanupFlagSet.DurationVar(&cleanTimeout, "timeout", vcbench.DefaultCleanUpTimeout, "The timeout of waiting for the namespaces to be removed")
+	cleanupFlagSet.StringVar(&cleanOutDataDir, "outDataDir", "", "The output data directory of the run, if set, the teardown time and leftovers are recorded in its manifest")
+	cleanupFlagSet.BoolVar(&cleanDeleteNodes, "deleteNodes", false, "If delete the virtual-kubelet nodes that only host benchmark pods")
+	cleanupFlagSet.StringVar(&cleanRunID, "run", "", "Only delete the objects created by the run with the id on every vc, use base-clean -run for a baseline run")
+	cleanupFlagSet.BoolVar(&cleanAllRuns, "all-runs", false, "Only delete the objects created by any run")
+	cleanupFlagSet.BoolVar(&cleanDryRun, "dry-run", false, "List the objects to be deleted by -run or -all-runs without deleting them")
+
+	// command options for subcommand "base-clean"
+	baseCleanFlagSet = flag.NewFlagSet("base-clean", flag.ExitOnError)
+	baseCleanFlagSet.StringVar(&baseCleanKbCfgPath, "kubeconfig", defaultTenantKbCfgPath, "The path to the kubeconfig file")
+	baseCleanFlagSet.IntVar(&baseCleanWorkers, "workers", vcbench.DefaultCleanUpWorkers, "The number of namespaces deleted concurrently")
+	baseCleanFlagSet.DurationVar(&baseCleanTimeout, "timeout", vcbench.DefaultCleanUpTimeout, "The timeout of waiting for the namespaces to be removed")
+	baseCleanFlagSet.StringVar(&baseCleanRunID, "run", "", "Only delete the objects created by the baseline run with the id")
+
+	// command options for subcommand "trace"
+	traceFlagSet = flag.NewFlagSet("trace", flag.ExitOnError)
+	traceFlagSet.StringVar(&traceOutPath, "o", "", "The path to the output trace file, default to <outDataDir>/<outDataDir>.trace.json")
+
+	// command options for subcommand "report"
+	reportFlagSet = flag.NewFlagSet("report", flag.ExitOnError)
+	reportFlagSet.StringVar(&reportScope, "scope", vcbench.ScopeAll, "The scope of the report, one of 'all', 'vc' or 'tenant'")
+
+	// command options for subcommand "import"
+	importFlagSet = flag.NewFlagSet("import", flag.ExitOnError)
+	importFlagSet.StringVar(&importOutDir, "outDataDir", "", "The path to the directory that will store the imported data, default to the base name of the legacy directory")
+
+	// command options for subcommand "syncer-log"
+	syncerLogFlagSet = flag.NewFlagSet("syncer-log", flag.ExitOnError)
+	syncerLogFlagSet.StringVar(&syncerLogFile, "f", "", "The path to a saved syncer log, the log is streamed from the syncer pod if not set")
+	syncerLogFlagSet.StringVar(&syncerLogKbCfgPath, "kubeconfig", defaultTenantKbCfgPath, "The kubeconfig file of the super cluster")
+	syncerLogFlagSet.StringVar(&syncerLogPod, "syncerPod", vcbench.DefaultSyncerPod, "The name of the syncer pod")
+	syncerLogFlagSet.StringVar(&syncerLogNs, "syncerNamespace", vcbench.DefaultSyncerNamespace, "The namespace of the syncer pod")
+	syncerLogFlagSet.StringVar(&syncerLogContainer, "container", "", "The container of the syncer pod")
+	syncerLogFlagSet.StringVar(&syncerLogMarkers, "markers", "", "The path to the yaml file that lists the log markers, default to SUPER_BIND, CREATE_VNODE, JUST_BIND_VPOD and GET_NEW_VPOD")
+
+	// command options for subcommand "overhead"
+	overheadFlagSet = flag.NewFlagSet("overhead", flag.ExitOnError)
+	overheadFlagSet.StringVar(&overheadBaseDir, "base", "", "The output data directory of an existing baseline run")
+	overheadFlagSet.StringVar(&overheadVCDir, "vc", "", "The output data directory of an existing vc run")
+	overheadFlagSet.StringVar(&overheadTenantJson, "tenantJson", "", "The path to the tenant json file, if -base and -vc are not set, the workload is run against the super cluster and through the vc, it also names the tenants of runs without a manifest")
+	overheadFlagSet.StringVar(&overheadKbCfgPath, "superkbcfg", defaultTenantKbCfgPath, "The kubeconfig file of the super cluster that runs the baseline")
+	overheadFlagSet.StringVar(&overheadTenantKbCfg, "tenantkbcfg", defaultTenantKbCfgPath, "The kubeconfig file of the k8s that holds tenant masters")
+	overheadFlagSet.IntVar(&overheadPodInterval, "podInterval", 0, "The submission interval(seconds) of pods in one tenant")
+	overheadFlagSet.StringVar(&overheadOutDataDir, "outDataDir", "", "The prefix of the output data directories of the runs, i.e., <outDataDir>-base and <outDataDir>-vc")
+
+	// command options for subcommands "provision" and "deprovision"
+	provisionFlagSet = flag.NewFlagSet("provision", flag.ExitOnError)
+	deprovisionFlagSet = flag.NewFlagSet("deprovision", flag.ExitOnError)
+	for _, fs := range []*flag.FlagSet{provisionFlagSet, deprovisionFlagSet} {
+		fs.StringVar(&provisionKbCfgPath, "tenantkbcfg", defaultTenantKbCfgPath, "The kubeconfig file of the k8s that holds tenant masters")
+		fs.IntVar(&provisionCount, "count", 1, "The number of vc")
+		fs.IntVar(&provisionStart, "start", 1, "The index of the first vc")
+		fs.StringVar(&provisionPrefix, "prefix", vcbench.DefaultVCPrefix, "The name of the i-th vc is <prefix><i>")
+		fs.StringVar(&provisionNsPrefix, "namespacePrefix", vcbench.DefaultVCNamespacePrefix, "The i-th vc is in the namespace <namespacePrefix><i>")
+		fs.IntVar(&provisionWorkers, "workers", vcbench.DefaultProvisionWorkers, "The number of vc handled concurrently")
+		fs.DurationVar(&provisionTimeout, "timeout", vcbench.DefaultProvisionTimeout, "The timeout of waiting for each vc to be running or removed")
+	}
+	provisionFlagSet.StringVar(&provisionCV, "clusterversion", "cv-sample-np", "The ClusterVersion of the vc")
+
+	// command options for subcommand "provision-bench"
+	provBenchFlagSet = flag.NewFlagSet("provision-bench", flag.ExitOnError)
+	provBenchFlagSet.StringVar(&provBenchKbCfgPath, "tenantkbcfg", defaultTenantKbCfgPath, "The kubeconfig file of the k8s that holds tenant masters")
+	provBenchFlagSet.StringVar(&provBenchSuperKbCfg, "superkbcfg", "", "The kubeconfig file of the super cluster, default to the tenantkbcfg")
+	provBenchFlagSet.IntVar(&provBenchCount, "count", 1, "The number of vc to be created")
+	provBenchFlagSet.StringVar(&provBenchPrefix, "prefix", "vcbench", "The name of the i-th vc is <prefix><i>")
+	provBenchFlagSet.StringVar(&provBenchNsPrefix, "namespacePrefix", "vcbench-t", "The i-th vc is in the namespace <namespacePrefix><i>")
+	provBenchFlagSet.StringVar(&provBenchCV, "clusterversion", "cv-sample-np", "The ClusterVersion of the vc")
+	provBenchFlagSet.Float64Var(&provBenchRate, "rate", 0, "The number of vc created per minute, all vc are created at once if not positive")
+	provBenchFlagSet.DurationVar(&provBenchTimeout, "timeout", vcbench.DefaultProvisionTimeout, "The timeout of waiting for each vc to be onboarded")
+	provBenchFlagSet.StringVar(&provBenchOutDataDir, "outDataDir", "", "The path to the directory that will store benchmark data")
+	provBenchFlagSet.BoolVar(&provBenchCleanUp, "deprovision", false, "If deprovision the vc after the benchmark")
+
+	// command options for subcommand "nodes"
+	nodesFlagSet = flag.NewFlagSet("nodes", flag.ExitOnError)
+	nodesFlagSet.StringVar(&nodesKbCfgPath, "kubeconfig", defaultTenantKbCfgPath, "The kubeconfig file of the super cluster")
+	nodesFlagSet.IntVar(&nodesCount, "count", 1, "The number of mock nodes")
+	nodesFlagSet.StringVar(&nodesPrefix, "prefix", vcbench.DefaultNodePrefix, "The name of the i-th node is <prefix>-<i>, starting from 0")
+	nodesFlagSet.StringVar(&nodesCPU, "cpu", "64", "The cpu capacity of each node")
+	nodesFlagSet.StringVar(&nodesMemory, "memory", "256Gi", "The memory capacity of each node")
+	nodesFlagSet.StringVar(&nodesPods, "pods", "500", "The pod capacity of each node")
+	nodesFlagSet.DurationVar(&nodesHeartbeat, "heartbeatInterval", vcbench.DefaultNodeHeartbeatInterval, "The interval of renewing the leases and status of the nodes")
+	nodesFlagSet.StringVar(&nodesLatency, "startLatency", "0s", "The time a node takes to get a bound pod Running and Ready, a duration (e.g. 500ms), a uniform range (e.g. 100ms-2s) or an exponential distribution with the mean (e.g. exp:1s)")
+	nodesFlagSet.BoolVar(&nodesKeepOnExit, "keepNodes", false, "If keep the nodes on exit, otherwise they are deleted")
+
+	// command options for subcommand "simulate"
+	simulateFlagSet = flag.NewFlagSet("simulate", flag.ExitOnError)
+	simulateFlagSet.StringVar(&simTenantJson, "tenantJson", "", "The path to the tenant json file")
+	simulateFlagSet.IntVar(&simNumVC, "numVC", 0, "The number of in-memory vc, default to the number of tenants")
+	simulateFlagSet.IntVar(&simTenantInterval, "tntintvl", 0, "The submission interval(milliseconds) among tenants")
+	simulateFlagSet.IntVar(&simPodInterval, "podintvl", 0, "The submission interval(milliseconds) of pods in one tenant")
+	simulateFlagSet.StringVar(&simQueue, "queue", vcbench.QueueFair, "The queue of the simulated syncer, one of 'fair' (vc with queued pods are served in turn) or 'fifo'")
+	simulateFlagSet.IntVar(&simWorkers, "workers", vcbench.DefaultSimSyncerWorkers, "The number of downward and upward workers of the simulated syncer")
+	simulateFlagSet.StringVar(&simServiceTime, "serviceTime", "10ms", "The time a worker takes to reconcile a pod, a duration (e.g. 10ms), a uniform range (e.g. 5ms-20ms) or an exponential distribution with the mean (e.g. exp:10ms)")
+	simulateFlagSet.StringVar(&simReadyLatency, "readyLatency", "100ms", "The time a pod takes to be Ready on the super cluster, in the format of -serviceTime")
+	simulateFlagSet.Float64Var(&simTimeScale, "timeScale", 1, "The factor the simulated time is stamped with, e.g. with 1000, 1ms is stamped as 1s")
+	simulateFlagSet.StringVar(&simOutDataDir, "outDataDir", "", "The path to the directory that will store benchmark data")
 }
 
 func main() {
 
 	if len(os.Args) <= 1 {
-		log.Fatal("please specify a subcommand: 'run', 'clean', or 'base'")
+		log.Fatal("please specify a subcommand: 'run', 'clean', 'base', 'base-clean', 'trace', 'report', 'import', 'syncer-log', 'overhead', 'provision', 'deprovision', 'provision-bench', 'nodes' or 'simulate'")
 		os.Exit(1)
 	}
 
@@ -85,7 +316,21 @@ func main() {
 
 	case "base":
 		runBaseBenchFlagSet.Parse(os.Args[2:])
-		baseOutDataDir := fmt.Sprintf("base-pod%d-tenants%d-podsleep%d-shareNs-%v", numePodBase, numeTenants, podIntervalBase, shareNs)
+		var baseTenantLst []tenant.Tenant
+		if baseTenantJson != "" {
+			var err error
+			baseTenantLst, err = tenant.ParseTenantsJson(baseTenantJson)
+			if err != nil {
+				log.Fatalf("fail to parse tenants json file(%s): %s", baseTenantJson, err)
+			}
+			numePodBase, numeTenants = 0, len(baseTenantLst)
+			for _, t := range baseTenantLst {
+				numePodBase += t.NumPods
+			}
+		}
+		if baseOutDataDir == "" {
+			baseOutDataDir = fmt.Sprintf("base-pod%d-tenants%d-podsleep%d-shareNs-%v", numePodBase, numeTenants, podIntervalBase, shareNs)
+		}
 		if err := os.MkdirAll(baseOutDataDir, os.ModePerm); err != nil {
 			log.Fatalf("fail to run base benchmark: %s", err)
 		}
@@ -93,12 +338,12 @@ func main() {
 			fmt.Sprintf("base-pod%d-tenants%d-podsleep%d.data", numePodBase, numeTenants, podIntervalBase))
 		baseDiffLogFile := path.Join(baseOutDataDir,
 			fmt.Sprintf("base-pod%d-tenants%d-podsleep%d.diff", numePodBase, numeTenants, podIntervalBase))
-		baselogFd, err := os.OpenFile(baseLogFile, os.O_CREATE|os.O_RDWR, 0644)
+		baselogFd, err := os.OpenFile(baseLogFile, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
 		defer baselogFd.Close()
 		if err != nil {
 			log.Fatalf("fail to run base benchmark: %s", err)
 		}
-		baseDiffLogFd, err := os.OpenFile(baseDiffLogFile, os.O_CREATE|os.O_RDWR, 0644)
+		baseDiffLogFd, err := os.OpenFile(baseDiffLogFile, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
 		defer baseDiffLogFd.Close()
 		if err != nil {
 			log.Fatalf("fail to run base benchmark: %s", err)
@@ -108,15 +353,74 @@ func main() {
 		if err != nil {
 			log.Fatalf("fail to generate bench executor: %s", err)
 		}
+		bbe.Tenants = baseTenantLst
+		bbe.ReadyTimeout = baseReadyTimeout
+		switch existingNs {
+		case vcbench.ExistingNsFail, vcbench.ExistingNsReuse, vcbench.ExistingNsRecreate:
+			bbe.ExistingNs = existingNs
+		default:
+			log.Fatalf("unknown -existingNs %s", existingNs)
+		}
+		switch tenantIdentity {
+		case vcbench.TenantIdentityShared, vcbench.TenantIdentityToken, vcbench.TenantIdentityImpersonate:
+			bbe.TenantIdentity = tenantIdentity
+		default:
+			log.Fatalf("unknown -tenantIdentity %s", tenantIdentity)
+		}
+		switch baseAPF {
+		case "":
+		case vcbench.APFShared, vcbench.APFIsolated:
+			bbe.APF = vcbench.NewAPFConfig(baseAPF)
+			bbe.APF.Shares = int32(baseAPFShares)
+			bbe.APF.Queues = int32(baseAPFQueues)
+			baseScrapeAPIs = true
+		default:
+			log.Fatalf("unknown -apf %s", baseAPF)
+		}
+		var scraper *vcbench.Scraper
+		if baseScrapeAPIs {
+			scraper, err = vcbench.NewScraper(baseOutDataDir, []vcbench.ScrapeTarget{{
+				Name:       "apiserver",
+				Kubeconfig: kubeconfigPathBase,
+				Interval:   baseScrapeInterval,
+			}})
+			if err != nil {
+				log.Fatalf("fail to initialize scraper: %s", err)
+			}
+			scraper.Start()
+		}
+		log.Printf("objects created by the run are labeled with %s=%s", vcbench.LabelRunID, bbe.RunID)
 		err = bbe.RunBaseBench()
+		if scraper != nil {
+			scraper.Stop()
+		}
 		if err != nil {
 			log.Fatalf("fail to run base benchmark: %s", err)
 		}
-		baselogFd.WriteString("#podName,creationTimestamp,readyTimestamp\n")
-		baseDiffLogFd.WriteString("#podName,latency\n")
-		for pn, pi := range bbe.RuntimeStatics {
-			baselogFd.WriteString(fmt.Sprintf("%s,%d,%d\n", pn, pi.CreationTimestamp, pi.ReadyTimestamp))
-			baseDiffLogFd.WriteString(fmt.Sprintf("%s,%d\n", pn, pi.ReadyTimestamp-pi.CreationTimestamp))
+		if err := vcbench.WriteBaseStatics(baselogFd, baseDiffLogFd, bbe.RuntimeStatics); err != nil {
+			log.Fatalf("fail to write base benchmark results: %s", err)
+		}
+		baseHistPath := path.Join(baseOutDataDir, fmt.Sprintf("%s.hist.json", baseOutDataDir))
+		if err := bbe.Histograms.WriteFile(baseHistPath); err != nil {
+			log.Printf("fail to write stage histograms: %s", err)
+		}
+		if bbe.APF != nil {
+			if err := writeBaseAPFReport(baseOutDataDir, bbe.Histograms); err != nil {
+				log.Printf("fail to write APF report: %s", err)
+			}
+		}
+		baseManifest := &vcbench.RunManifest{
+			Name:        baseOutDataDir,
+			RunID:       bbe.RunID,
+			Mode:        vcbench.RunModeBase,
+			NumPods:     numePodBase,
+			NumTenants:  numeTenants,
+			PodInterval: podIntervalBase,
+			TenantJson:  baseTenantJson,
+			Tenants:     baseTenantLst,
+		}
+		if err := baseManifest.WriteFile(baseOutDataDir); err != nil {
+			log.Printf("fail to write run manifest: %s", err)
 		}
 
 	case "run":
@@ -154,14 +458,96 @@ func main() {
 		}
 
 		// 2. run benchmark
-		be, err := vcbench.NewBenchExecutor(tenantsKbCfgPath, tenantLst, tenantInterval, podInterval, numOfVC)
+		if superKbCfgPath == "" {
+			superKbCfgPath = tenantsKbCfgPath
+		}
+		backend, err := vcbench.NewKubeBackend(tenantsKbCfgPath)
 		if err != nil {
 			log.Fatalf("fail to initialize bench executor: %s", err)
 		}
-		stop := make(chan struct{})
-
-		go vcbench.ScrapeSyncer(stop, outDataDir, syncerAddr, scrapeInterval)
-		go vcbench.ScrapeKubelet(stop, outDataDir, kubeletAddr, scrapeKubeletInterval)
+		backend.SuperKubeconfig = superKbCfgPath
+		be, err := vcbench.NewBenchExecutorWithBackend(backend, tenantLst, tenantInterval, podInterval, numOfVC)
+		if err != nil {
+			log.Fatalf("fail to initialize bench executor: %s", err)
+		}
+		if runID != "" {
+			be.RunID = runID
+		}
+		log.Printf("objects created by the run are labeled with %s=%s", vcbench.LabelRunID, be.RunID)
+		var scrapeTargets []vcbench.ScrapeTarget
+		switch {
+		case syncerAddr != "":
+			scrapeTargets = append(scrapeTargets, vcbench.ScrapeTarget{
+				Name:     "syncer",
+				Addr:     syncerAddr,
+				Interval: scrapeInterval,
+			})
+		case syncerPod != "":
+			scrapeTargets = append(scrapeTargets, vcbench.ScrapeTarget{
+				Name:       "syncer",
+				Interval:   scrapeInterval,
+				Kubeconfig: superKbCfgPath,
+				Discovery: &vcbench.TargetDiscovery{
+					Kind:      vcbench.DiscoverPod,
+					Namespace: syncerNamespace,
+					PodName:   syncerPod,
+					Port:      syncerMetricsPort,
+				},
+			})
+		}
+		switch {
+		case kubeletAddr != "":
+			scrapeTargets = append(scrapeTargets, vcbench.ScrapeTarget{
+				Name:     "kubelet",
+				Addr:     kubeletAddr,
+				Interval: scrapeKubeletInterval,
+			})
+		case discoverVirtualKubelet:
+			scrapeTargets = append(scrapeTargets, vcbench.ScrapeTarget{
+				Name:       "kubelet",
+				Interval:   scrapeKubeletInterval,
+				Kubeconfig: superKbCfgPath,
+				Discovery: &vcbench.TargetDiscovery{
+					Kind: vcbench.DiscoverVirtualKubelet,
+				},
+			})
+		}
+		if scrapeSuperApiserver {
+			scrapeTargets = append(scrapeTargets, vcbench.ScrapeTarget{
+				Name:       "apiserver-super",
+				Kubeconfig: superKbCfgPath,
+				Interval:   scrapeInterval,
+			})
+		}
+		if scrapeTenantApiservers {
+			scrapeTargets = append(scrapeTargets, be.TenantApiserverTargets(scrapeInterval)...)
+		}
+		if scrapeConfigPath != "" {
+			scrapeCfg, err := vcbench.LoadScrapeConfig(scrapeConfigPath)
+			if err != nil {
+				log.Fatalf("fail to load scrape config(%s): %s", scrapeConfigPath, err)
+			}
+			scrapeTargets = append(scrapeTargets, scrapeCfg.Targets...)
+		}
+		be.Scraper, err = vcbench.NewScraper(outDataDir, scrapeTargets)
+		if err != nil {
+			log.Fatalf("fail to initialize scraper: %s", err)
+		}
+		if profileConfigPath != "" {
+			profileCfg, err := vcbench.LoadProfileConfig(profileConfigPath)
+			if err != nil {
+				log.Fatalf("fail to load profile config(%s): %s", profileConfigPath, err)
+			}
+			be.Profiler, err = vcbench.NewProfiler(outDataDir, profileCfg.Targets)
+			if err != nil {
+				log.Fatalf("fail to initialize profiler: %s", err)
+			}
+		}
+		if collectEvents {
+			if err := be.CollectEvents(path.Join(outDataDir, fmt.Sprintf("%s.events.jsonl", outDataDir))); err != nil {
+				log.Fatalf("fail to collect events: %s", err)
+			}
+		}
 
 		err = be.RunBench()
 		if err != nil {
@@ -169,40 +555,627 @@ func main() {
 		}
 		// log.Printf("benchmark successfully complete, will wait for %d minutes", syncerStandaloneMinute)
 		// <-time.After(time.Duration(syncerStandaloneMinute) * time.Minute)
-		close(stop)
+
+		outDataPathHist := path.Join(outDataDir, fmt.Sprintf("%s.hist.json", outDataDir))
+		log.Printf("writing stage histograms to %s", outDataPathHist)
+		if err := be.Histograms.WriteFile(outDataPathHist); err != nil {
+			log.Printf("fail to write stage histograms: %s", err)
+		}
 
 		log.Printf("writing runtime data to log(%s)", outDataPath)
-		logFd.WriteString("#podName,tenantCreation,dwsDequeue,superCreation,superReady,uwsDequeue,tenantUpdate\n")
-		logDiffFd.WriteString("#podName,dwsQDelay,dwsProcessDelay,superCreationTime,uwsQDelay,tenantUpdateTime,total\n")
-		for pn, rs := range be.RuntimeStatics {
-			logFd.WriteString(fmt.Sprintf("%s,%d,%d,%d,%d,%d,%d\n", pn,
-				rs.TenantCreation,
-				rs.DwsDequeue,
-				rs.SuperCreation,
-				rs.SuperReady,
-				rs.UwsDequeue,
-				rs.SuperUpdate))
-
-			logDiffFd.WriteString(fmt.Sprintf("%s,%d,%d,%d,%d,%d,%d\n", pn,
-				rs.DwsDequeue-rs.TenantCreation,
-				rs.SuperCreation-rs.DwsDequeue,
-				rs.SuperReady-rs.SuperCreation,
-				rs.UwsDequeue-rs.SuperReady,
-				rs.SuperUpdate-rs.UwsDequeue,
-				rs.SuperUpdate-rs.TenantCreation))
+		if err := vcbench.WriteRuntimeStatics(logFd, logDiffFd, be.RuntimeStatics); err != nil {
+			log.Fatalf("fail to write runtime data: %s", err)
+		}
+
+		incompletePath := path.Join(outDataDir, fmt.Sprintf("%s.incomplete", outDataDir))
+		incompleteFd, err := os.Create(incompletePath)
+		if err != nil {
+			log.Fatalf("fail to open file %s: %s", incompletePath, err)
+		}
+		defer incompleteFd.Close()
+		incompletePods, err := vcbench.WriteIncompletePods(incompleteFd, be.RuntimeStatics)
+		if err != nil {
+			log.Printf("fail to write incomplete pods: %s", err)
+		}
+
+		manifest := &vcbench.RunManifest{
+			Name:           outDataDir,
+			RunID:          be.RunID,
+			Mode:           vcbench.RunModeVC,
+			NumPods:        numPod,
+			NumTenants:     len(tenantLst),
+			NumVC:          be.NumVC(),
+			TenantInterval: tenantInterval,
+			PodInterval:    podInterval,
+			TenantJson:     tenantJson,
+			Tenants:        tenantLst,
+			IncompletePods: incompletePods,
+		}
+		if err := manifest.WriteFile(outDataDir); err != nil {
+			log.Printf("fail to write run manifest: %s", err)
 		}
 
 	case "clean":
 		cleanupFlagSet.Parse(os.Args[2:])
-		be, err := vcbench.NewBenchExecutor(tenantsKbCfgPath, []tenant.Tenant{}, 0, 0, int(^uint(0)>>1))
+		if cleanSuperKbCfg == "" {
+			cleanSuperKbCfg = tenantsKbCfgPath
+		}
+		backend, err := vcbench.NewKubeBackend(tenantsKbCfgPath)
 		if err != nil {
 			log.Fatalf("fail to initialize bench executor: %s", err)
 		}
-		log.Println("will try to remove all benchmark namespace")
-		be.CleanUp(targetNs)
+		backend.SuperKubeconfig = cleanSuperKbCfg
+		be, err := vcbench.NewBenchExecutorWithBackend(backend, []tenant.Tenant{}, 0, 0, int(^uint(0)>>1))
+		if err != nil {
+			log.Fatalf("fail to initialize bench executor: %s", err)
+		}
+		if cleanRunID != "" && cleanAllRuns {
+			log.Fatal("-run and -all-runs are mutually exclusive")
+		}
+		opts := vcbench.CleanUpOptions{
+			Namespace:   targetNs,
+			Workers:     cleanWorkers,
+			Timeout:     cleanTimeout,
+			DeleteNodes: cleanDeleteNodes,
+		}
+		var result *vcbench.CleanUpResult
+		if cleanRunID != "" || cleanAllRuns {
+			result = be.CleanUpRun(cleanRunID, cleanDryRun, opts)
+			if cleanDryRun {
+				break
+			}
+		} else {
+			log.Println("will try to remove all benchmark namespace")
+			result = be.CleanUp(opts)
+		}
+		log.Printf("teardown took %.1f seconds", result.Duration.Seconds())
+		for _, e := range result.Errors {
+			log.Printf("error: %s", e)
+		}
+		for _, l := range result.Leftovers {
+			log.Printf("leftover: %s", l)
+		}
+		if cleanOutDataDir != "" {
+			manifest, err := vcbench.LoadRunManifest(cleanOutDataDir)
+			if err != nil {
+				log.Fatalf("fail to load run manifest: %s", err)
+			}
+			manifest.TeardownSeconds = result.Duration.Seconds()
+			manifest.TeardownLeftovers = result.Leftovers
+			if err := manifest.WriteFile(cleanOutDataDir); err != nil {
+				log.Fatalf("fail to write run manifest: %s", err)
+			}
+		}
+		if len(result.Errors) != 0 || len(result.Leftovers) != 0 {
+			log.Fatalf("clean up is incomplete: %d errors, %d leftovers", len(result.Errors), len(result.Leftovers))
+		}
+
+	case "base-clean":
+		baseCleanFlagSet.Parse(os.Args[2:])
+		cli, err := vcbench.NewClient(baseCleanKbCfgPath)
+		if err != nil {
+			log.Fatalf("fail to build client: %s", err)
+		}
+		result, err := vcbench.CleanUpBase(cli, baseCleanRunID, vcbench.CleanUpOptions{
+			Workers: baseCleanWorkers,
+			Timeout: baseCleanTimeout,
+		})
+		if err != nil {
+			log.Fatalf("fail to clean up: %s", err)
+		}
+		log.Printf("teardown took %.1f seconds", result.Duration.Seconds())
+		for _, e := range result.Errors {
+			log.Printf("error: %s", e)
+		}
+		for _, l := range result.Leftovers {
+			log.Printf("leftover: %s", l)
+		}
+		if len(result.Errors) != 0 || len(result.Leftovers) != 0 {
+			log.Fatalf("clean up is incomplete: %d errors, %d leftovers", len(result.Errors), len(result.Leftovers))
+		}
+
+	case "trace":
+		traceFlagSet.Parse(os.Args[2:])
+		if traceFlagSet.NArg() != 1 {
+			log.Fatal("usage: vcbench trace [-o <traceFile>] <outDataDir>")
+		}
+		traceDataDir := traceFlagSet.Arg(0)
+		logPath, err := vcbench.FindOutDataFile(traceDataDir, ".log")
+		if err != nil {
+			log.Fatalf("fail to locate runtime data log: %s", err)
+		}
+		rsLst, err := vcbench.LoadRuntimeStatics(logPath)
+		if err != nil {
+			log.Fatalf("fail to load runtime data log(%s): %s", logPath, err)
+		}
+		// the tenants of the run tell the vc of a pod even if its name
+		// contains '-'
+		if m, err := vcbench.LoadRunManifest(traceDataDir); err == nil {
+			vcbench.AssignTenants(rsLst, m.Tenants)
+		} else {
+			log.Printf("fail to load run manifest, vc are taken from pod names: %s", err)
+		}
+		if traceOutPath == "" {
+			traceOutPath = path.Join(traceDataDir, fmt.Sprintf("%s.trace.json", path.Base(traceDataDir)))
+		}
+		traceFd, err := os.OpenFile(traceOutPath, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+		if err != nil {
+			log.Fatalf("fail to open file %s: %s", traceOutPath, err)
+		}
+		defer traceFd.Close()
+		if err := vcbench.ExportTrace(rsLst, traceFd); err != nil {
+			log.Fatalf("fail to export trace: %s", err)
+		}
+		log.Printf("trace of %d pods is written to %s", len(rsLst), traceOutPath)
+
+	case "report":
+		reportFlagSet.Parse(os.Args[2:])
+		if reportFlagSet.NArg() == 0 {
+			log.Fatal("usage: vcbench report [-scope all|vc|tenant] <outDataDir>...")
+		}
+		scopePrefix := reportScope
+		if reportScope != vcbench.ScopeAll {
+			scopePrefix = reportScope + "/"
+		}
+		// merge histograms of all given runs
+		merged := vcbench.NewStageHistograms()
+		for _, reportDataDir := range reportFlagSet.Args() {
+			histPath, err := vcbench.FindOutDataFile(reportDataDir, ".hist.json")
+			if err != nil {
+				log.Fatalf("fail to locate stage histograms: %s", err)
+			}
+			sh, err := vcbench.LoadStageHistograms(histPath)
+			if err != nil {
+				log.Fatalf("fail to load stage histograms(%s): %s", histPath, err)
+			}
+			merged.Merge(sh)
+		}
+		if err := vcbench.WriteReport(os.Stdout, merged, scopePrefix); err != nil {
+			log.Fatalf("fail to write report: %s", err)
+		}
+		// events of the pods of each run, if collected
+		for _, reportDataDir := range reportFlagSet.Args() {
+			eventsPath, err := vcbench.FindOutDataFile(reportDataDir, ".events.jsonl")
+			if err != nil {
+				continue
+			}
+			events, err := vcbench.LoadEventRecords(eventsPath)
+			if err != nil {
+				log.Fatalf("fail to load events(%s): %s", eventsPath, err)
+			}
+			rsMap := make(map[string]*vcbench.RuntimeStatics)
+			if logPath, err := vcbench.FindOutDataFile(reportDataDir, ".log"); err == nil {
+				rsLst, err := vcbench.LoadRuntimeStatics(logPath)
+				if err != nil {
+					log.Fatalf("fail to load runtime data log(%s): %s", logPath, err)
+				}
+				for _, rs := range rsLst {
+					rsMap[rs.PodName] = rs
+				}
+			}
+			fmt.Printf("\nEvents of %s:\n", reportDataDir)
+			if err := vcbench.WriteEventReport(os.Stdout, events, rsMap); err != nil {
+				log.Fatalf("fail to write event report: %s", err)
+			}
+		}
+
+	case "overhead":
+		overheadFlagSet.Parse(os.Args[2:])
+		var tenantLst []tenant.Tenant
+		if overheadTenantJson != "" {
+			var err error
+			tenantLst, err = tenant.ParseTenantsJson(overheadTenantJson)
+			if err != nil {
+				log.Fatalf("fail to parse tenants json file(%s): %s", overheadTenantJson, err)
+			}
+		}
+		if (overheadBaseDir == "") != (overheadVCDir == "") {
+			log.Fatal("-base and -vc must be set together")
+		}
+		if overheadBaseDir == "" {
+			if overheadTenantJson == "" {
+				log.Fatal("usage: vcbench overhead -base <dir> -vc <dir> | -tenantJson <file>")
+			}
+			if overheadOutDataDir == "" {
+				overheadOutDataDir = fmt.Sprintf("overhead-%s", time.Now().Format(TimeOutputFmt))
+			}
+			overheadBaseDir, overheadVCDir = overheadOutDataDir+"-base", overheadOutDataDir+"-vc"
+			// each run is a subcommand of its own, so that the flags and the
+			// output are the same as running them manually
+			runSubcommand("base",
+				"-kubeconfig", overheadKbCfgPath,
+				"-tenantJson", overheadTenantJson,
+				"-podInterval", strconv.Itoa(overheadPodInterval),
+				"-outDataDir", overheadBaseDir)
+			runSubcommand("run",
+				"-tenantkbcfg", overheadTenantKbCfg,
+				"-superkbcfg", overheadKbCfgPath,
+				"-tenantJson", overheadTenantJson,
+				"-podintvl", strconv.Itoa(overheadPodInterval*1000),
+				"-outDataDir", overheadVCDir)
+		}
+
+		baseDataPath, err := vcbench.FindOutDataFile(overheadBaseDir, ".data")
+		if err != nil {
+			log.Fatalf("fail to locate baseline data: %s", err)
+		}
+		baseStatics, err := vcbench.LoadBaseStatics(baseDataPath)
+		if err != nil {
+			log.Fatalf("fail to load baseline data(%s): %s", baseDataPath, err)
+		}
+		vcLogPath, err := vcbench.FindOutDataFile(overheadVCDir, ".log")
+		if err != nil {
+			log.Fatalf("fail to locate vc runtime data: %s", err)
+		}
+		vcStatics, err := vcbench.LoadRuntimeStatics(vcLogPath)
+		if err != nil {
+			log.Fatalf("fail to load vc runtime data(%s): %s", vcLogPath, err)
+		}
+		baseTenants, vcTenants := tenantLst, tenantLst
+		if m, err := vcbench.LoadRunManifest(overheadBaseDir); err == nil && len(m.Tenants) != 0 {
+			baseTenants = m.Tenants
+		}
+		if m, err := vcbench.LoadRunManifest(overheadVCDir); err == nil && len(m.Tenants) != 0 {
+			vcTenants = m.Tenants
+		}
+		if len(vcTenants) == 0 {
+			log.Fatalf("tenants of %s are unknown, please specify -tenantJson", overheadVCDir)
+		}
+		pairs, unmatched := vcbench.AlignOverhead(baseStatics, baseTenants, vcStatics, vcTenants)
+		if len(unmatched) != 0 {
+			log.Printf("%d pods have no counterpart, e.g. %s", len(unmatched), unmatched[0])
+		}
+		if len(pairs) == 0 {
+			log.Fatal("no pods of the two runs can be aligned")
+		}
+		log.Printf("%d pods of %s and %s are aligned", len(pairs), overheadBaseDir, overheadVCDir)
+		if err := vcbench.WriteOverheadReport(os.Stdout, pairs); err != nil {
+			log.Fatalf("fail to write overhead report: %s", err)
+		}
+
+	case "provision", "deprovision":
+		fs := provisionFlagSet
+		if os.Args[1] == "deprovision" {
+			fs = deprovisionFlagSet
+		}
+		fs.Parse(os.Args[2:])
+		cli, err := vcbench.NewClient(provisionKbCfgPath)
+		if err != nil {
+			log.Fatalf("fail to build client: %s", err)
+		}
+		opts := vcbench.ProvisionOptions{
+			Count:           provisionCount,
+			Start:           provisionStart,
+			Prefix:          provisionPrefix,
+			NamespacePrefix: provisionNsPrefix,
+			ClusterVersion:  provisionCV,
+			Workers:         provisionWorkers,
+			Timeout:         provisionTimeout,
+		}
+		var result *vcbench.ProvisionResult
+		if os.Args[1] == "provision" {
+			result, err = vcbench.ProvisionVCs(cli, opts)
+			if err != nil {
+				log.Fatalf("fail to provision vc: %s", err)
+			}
+		} else {
+			result, err = vcbench.DeprovisionVCs(cli, opts)
+			if err != nil {
+				log.Fatalf("fail to deprovision vc: %s", err)
+			}
+		}
+		log.Printf("%s of %d vc took %.1f seconds", os.Args[1], len(result.VCs), result.Duration.Seconds())
+		if failed := result.Failed(); len(failed) != 0 {
+			for _, vp := range failed {
+				log.Printf("%s/%s: %s", vp.Namespace, vp.Name, vp.Error)
+			}
+			log.Fatalf("fail to %s %d of %d vc", os.Args[1], len(failed), len(result.VCs))
+		}
+
+	case "provision-bench":
+		provBenchFlagSet.Parse(os.Args[2:])
+		if provBenchSuperKbCfg == "" {
+			provBenchSuperKbCfg = provBenchKbCfgPath
+		}
+		if provBenchOutDataDir == "" {
+			provBenchOutDataDir = fmt.Sprintf("provision-vc%d-rate%v-%s",
+				provBenchCount, provBenchRate, time.Now().Format(TimeOutputFmt))
+		}
+		if err := os.MkdirAll(provBenchOutDataDir, os.ModePerm); err != nil {
+			log.Fatalf("fail to create output data directory(%s): %s", provBenchOutDataDir, err)
+		}
+		opts := vcbench.ProvisionOptions{
+			Count:           provBenchCount,
+			Prefix:          provBenchPrefix,
+			NamespacePrefix: provBenchNsPrefix,
+			ClusterVersion:  provBenchCV,
+			Timeout:         provBenchTimeout,
+		}
+		pbe, err := vcbench.NewProvisionBenchExecutor(provBenchKbCfgPath, provBenchSuperKbCfg, opts, provBenchRate)
+		if err != nil {
+			log.Fatalf("fail to initialize provision bench executor: %s", err)
+		}
+		log.Printf("objects created by the run are labeled with %s=%s", vcbench.LabelRunID, pbe.RunID)
+		if err := pbe.RunProvisionBench(); err != nil {
+			log.Fatalf("fail to run provision benchmark: %s", err)
+		}
+
+		dataPath := path.Join(provBenchOutDataDir, fmt.Sprintf("%s.provision", provBenchOutDataDir))
+		dataFd, err := os.OpenFile(dataPath, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+		if err != nil {
+			log.Fatalf("fail to open file %s: %s", dataPath, err)
+		}
+		defer dataFd.Close()
+		if err := vcbench.WriteProvisionStatics(dataFd, pbe.RuntimeStatics); err != nil {
+			log.Fatalf("fail to write provision data: %s", err)
+		}
+		histPath := path.Join(provBenchOutDataDir, fmt.Sprintf("%s.hist.json", provBenchOutDataDir))
+		if err := pbe.Histograms.WriteFile(histPath); err != nil {
+			log.Printf("fail to write stage histograms: %s", err)
+		}
+		var incomplete int
+		for _, vs := range pbe.RuntimeStatics {
+			if vs.Error != "" {
+				incomplete++
+			}
+		}
+		manifest := &vcbench.RunManifest{
+			Name:           provBenchOutDataDir,
+			RunID:          pbe.RunID,
+			Mode:           vcbench.RunModeProvision,
+			NumVC:          provBenchCount,
+			ClusterVersion: provBenchCV,
+			VCRate:         provBenchRate,
+		}
+		if incomplete != 0 {
+			manifest.Warnings = append(manifest.Warnings, fmt.Sprintf("%d vc are not onboarded", incomplete))
+		}
+		if err := manifest.WriteFile(provBenchOutDataDir); err != nil {
+			log.Printf("fail to write run manifest: %s", err)
+		}
+		if err := vcbench.WriteReport(os.Stdout, pbe.Histograms, vcbench.ScopeAll); err != nil {
+			log.Printf("fail to write report: %s", err)
+		}
+		if provBenchCleanUp {
+			result, err := vcbench.DeprovisionVCs(pbe.Client, opts)
+			if err != nil {
+				log.Fatalf("fail to deprovision vc: %s", err)
+			}
+			if failed := result.Failed(); len(failed) != 0 {
+				log.Fatalf("fail to deprovision %d of %d vc", len(failed), len(result.VCs))
+			}
+		}
+
+	case "nodes":
+		nodesFlagSet.Parse(os.Args[2:])
+		latency, err := vcbench.ParseLatencyDistribution(nodesLatency)
+		if err != nil {
+			log.Fatalf("fail to parse -startLatency: %s", err)
+		}
+		nf, err := vcbench.NewNodeFleet(nodesKbCfgPath, vcbench.NodeFleetOptions{
+			Count:             nodesCount,
+			Prefix:            nodesPrefix,
+			CPU:               nodesCPU,
+			Memory:            nodesMemory,
+			Pods:              nodesPods,
+			HeartbeatInterval: nodesHeartbeat,
+			StartLatency:      latency,
+		})
+		if err != nil {
+			log.Fatalf("fail to initialize node fleet: %s", err)
+		}
+
+		sigStop := make(chan os.Signal, 1)
+		signal.Notify(sigStop, os.Interrupt, syscall.SIGTERM)
+		stopChan := make(chan struct{})
+		go func() {
+			sig := <-sigStop
+			log.Printf("receive signal(%s), will terminate", sig)
+			close(stopChan)
+		}()
+
+		log.Printf("starting %d mock nodes, pods are started in %s", nodesCount, latency)
+		runErr := nf.Run(stopChan)
+		if !nodesKeepOnExit {
+			if failed := nf.Deregister(); len(failed) != 0 {
+				log.Printf("fail to delete %d nodes", len(failed))
+			} else {
+				log.Printf("%d mock nodes are deleted", nodesCount)
+			}
+		}
+		if runErr != nil {
+			log.Fatalf("fail to run node fleet: %s", runErr)
+		}
+
+	case "simulate":
+		simulateFlagSet.Parse(os.Args[2:])
+		tenantLst, err := tenant.ParseTenantsJson(simTenantJson)
+		if err != nil {
+			log.Fatalf("fail to parse tenants json file(%s): %s", simTenantJson, err)
+		}
+		numPod := 0
+		for _, t := range tenantLst {
+			numPod += t.NumPods
+		}
+		if simNumVC <= 0 {
+			simNumVC = len(tenantLst)
+		}
+		opts := vcbench.SimSyncerOptions{
+			Queue:     simQueue,
+			Workers:   simWorkers,
+			TimeScale: simTimeScale,
+		}
+		if opts.ServiceTime, err = vcbench.ParseLatencyDistribution(simServiceTime); err != nil {
+			log.Fatalf("fail to parse -serviceTime: %s", err)
+		}
+		if opts.ReadyLatency, err = vcbench.ParseLatencyDistribution(simReadyLatency); err != nil {
+			log.Fatalf("fail to parse -readyLatency: %s", err)
+		}
+		if simOutDataDir == "" {
+			simOutDataDir = fmt.Sprintf("sim-pod%d-tenant%d-%s-workers%d-%s",
+				numPod, len(tenantLst), simQueue, simWorkers, time.Now().Format(TimeOutputFmt))
+		}
+		if err := os.MkdirAll(simOutDataDir, os.ModePerm); err != nil {
+			log.Fatalf("fail to create output data directory(%s): %s", simOutDataDir, err)
+		}
+
+		fb := vcbench.NewFakeBackend()
+		for i := 1; i <= simNumVC; i++ {
+			if _, err := fb.AddVirtualCluster(fmt.Sprintf("t%d", i), fmt.Sprintf("vc%d", i)); err != nil {
+				log.Fatalf("fail to add vc: %s", err)
+			}
+		}
+		ss, err := vcbench.NewSimSyncer(fb.Super, opts)
+		if err != nil {
+			log.Fatalf("fail to initialize simulated syncer: %s", err)
+		}
+		if err := ss.AddFakeBackend(fb); err != nil {
+			log.Fatalf("fail to initialize simulated syncer: %s", err)
+		}
+		stopChan := make(chan struct{})
+		go ss.Run(stopChan)
+
+		be, err := vcbench.NewBenchExecutorWithBackend(fb, tenantLst, simTenantInterval, simPodInterval, simNumVC)
+		if err != nil {
+			log.Fatalf("fail to initialize bench executor: %s", err)
+		}
+		// nothing needs to be waited for on the in-memory clusters
+		be.NamespaceWait = 0
+		be.PollInterval = 100 * time.Millisecond
+		if err := be.RunBench(); err != nil {
+			log.Fatalf("fail to run bench: %s", err)
+		}
+		close(stopChan)
+
+		histPath := path.Join(simOutDataDir, fmt.Sprintf("%s.hist.json", simOutDataDir))
+		if err := be.Histograms.WriteFile(histPath); err != nil {
+			log.Printf("fail to write stage histograms: %s", err)
+		}
+		logFd, err := os.Create(path.Join(simOutDataDir, fmt.Sprintf("%s.log", simOutDataDir)))
+		if err != nil {
+			log.Fatalf("fail to create runtime data log: %s", err)
+		}
+		defer logFd.Close()
+		logDiffFd, err := os.Create(path.Join(simOutDataDir, fmt.Sprintf("%s.diff", simOutDataDir)))
+		if err != nil {
+			log.Fatalf("fail to create runtime data log: %s", err)
+		}
+		defer logDiffFd.Close()
+		if err := vcbench.WriteRuntimeStatics(logFd, logDiffFd, be.RuntimeStatics); err != nil {
+			log.Fatalf("fail to write runtime data: %s", err)
+		}
+		manifest := &vcbench.RunManifest{
+			Name:           simOutDataDir,
+			RunID:          be.RunID,
+			Mode:           vcbench.RunModeSimulate,
+			NumPods:        numPod,
+			NumTenants:     len(tenantLst),
+			NumVC:          be.NumVC(),
+			TenantInterval: simTenantInterval,
+			PodInterval:    simPodInterval,
+			TenantJson:     simTenantJson,
+			Tenants:        tenantLst,
+			SimSyncer:      &ss.Options,
+		}
+		if err := manifest.WriteFile(simOutDataDir); err != nil {
+			log.Printf("fail to write run manifest: %s", err)
+		}
+		if err := vcbench.WriteReport(os.Stdout, be.Histograms, vcbench.ScopeAll); err != nil {
+			log.Printf("fail to write report: %s", err)
+		}
+
+	case "import":
+		importFlagSet.Parse(os.Args[2:])
+		if importFlagSet.NArg() != 1 {
+			log.Fatal("usage: vcbench import [-outDataDir <dir>] <legacyDir>")
+		}
+		legacyDir := importFlagSet.Arg(0)
+		if importOutDir == "" {
+			importOutDir = path.Base(legacyDir)
+		}
+		manifest, err := vcbench.ImportLegacyDir(legacyDir, importOutDir)
+		if err != nil {
+			log.Fatalf("fail to import %s: %s", legacyDir, err)
+		}
+		log.Printf("imported %s to %s: %d pods, %d tenants, %d vc, %d incomplete pods",
+			legacyDir, importOutDir, manifest.NumPods, manifest.NumTenants,
+			manifest.NumVC, manifest.IncompletePods)
+
+	case "syncer-log":
+		syncerLogFlagSet.Parse(os.Args[2:])
+		if syncerLogFlagSet.NArg() != 1 {
+			log.Fatal("usage: vcbench syncer-log [-f <syncerLog>] [-markers <markers.yaml>] <outDataDir>")
+		}
+		markers := vcbench.DefaultLogMarkers
+		if syncerLogMarkers != "" {
+			cfg, err := vcbench.LoadSyncerLogConfig(syncerLogMarkers)
+			if err != nil {
+				log.Fatalf("fail to load log markers(%s): %s", syncerLogMarkers, err)
+			}
+			markers = cfg.Markers
+		}
+		var (
+			syncerLog io.ReadCloser
+			err       error
+		)
+		if syncerLogFile != "" {
+			syncerLog, err = os.Open(syncerLogFile)
+		} else {
+			syncerLog, err = vcbench.StreamPodLogs(syncerLogKbCfgPath, syncerLogNs, syncerLogPod, syncerLogContainer)
+		}
+		if err != nil {
+			log.Fatalf("fail to read syncer log: %s", err)
+		}
+		defer syncerLog.Close()
+		matched, unknown, err := vcbench.AddSyncerLogStages(syncerLogFlagSet.Arg(0), syncerLog, markers)
+		if err != nil {
+			log.Fatalf("fail to add syncer log stages: %s", err)
+		}
+		if len(unknown) != 0 {
+			log.Printf("%d logged pods are not part of the run, e.g. %s", len(unknown), unknown[0])
+		}
+		log.Printf("syncer log stages of %d pods are added", matched)
 
 	default:
 		log.Fatalf("unsupport action: %s", os.Args[1])
 		os.Exit(1)
 	}
 }
+
+// writeBaseAPFReport writes the per-tenant latency and the APF metrics
+// scraped from the apiserver to stdout and <outDataDir>.apf.report
+func writeBaseAPFReport(outDataDir string, sh *vcbench.StageHistograms) error {
+	metricsPath, err := vcbench.FindOutDataFile(outDataDir, ".apiserver.metrics")
+	if err != nil {
+		return err
+	}
+	records, err := vcbench.ParseMetricsFile(metricsPath, func(name string) bool {
+		return strings.HasPrefix(name, vcbench.APFMetricPrefix)
+	})
+	if err != nil {
+		return err
+	}
+	reportFd, err := os.OpenFile(path.Join(outDataDir, fmt.Sprintf("%s.apf.report", path.Base(outDataDir))),
+		os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer reportFd.Close()
+	w := io.MultiWriter(os.Stdout, reportFd)
+	if err := vcbench.WriteReport(w, sh, vcbench.TenantScope("")); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+	return vcbench.WriteAPFReport(w, vcbench.SummarizeAPFMetrics(records))
+}
+
+// runSubcommand runs a subcommand of vcbench as a child process, and exits
+// if it fails
+func runSubcommand(subcommand string, args ...string) {
+	log.Printf("running %s %s", subcommand, strings.Join(args, " "))
+	cmd := exec.Command(os.Args[0], append([]string{subcommand}, args...)...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		log.Fatalf("fail to run %s: %s", subcommand, err)
+	}
+}
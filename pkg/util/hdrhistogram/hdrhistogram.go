@@ -0,0 +1,260 @@
+// Package hdrhistogram implements a High Dynamic Range histogram, which
+// records non-negative integer values with a fixed number of significant
+// figures and supports accurate high percentiles, merging and compact
+// serialization.
+package hdrhistogram
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// Histogram is a HDR histogram that tracks values in the range of
+// [0, HighestTrackableValue]. Values larger than HighestTrackableValue are
+// recorded as HighestTrackableValue.
+type Histogram struct {
+	highestTrackableValue int64
+	significantFigures    int
+
+	subBucketHalfCountMagnitude uint
+	subBucketHalfCount          int
+	subBucketCount              int
+	subBucketMask               int64
+
+	counts     []int64
+	totalCount int64
+	min        int64
+	max        int64
+	sum        float64
+}
+
+// New creates a histogram that tracks values up to highestTrackableValue
+// with the given number of significant figures (1 to 5).
+func New(highestTrackableValue int64, significantFigures int) (*Histogram, error) {
+	if significantFigures < 1 || significantFigures > 5 {
+		return nil, fmt.Errorf("significant figures must be in [1, 5], get %d", significantFigures)
+	}
+	if highestTrackableValue < 2 {
+		return nil, fmt.Errorf("highest trackable value must be larger than 1, get %d", highestTrackableValue)
+	}
+	h := &Histogram{
+		highestTrackableValue: highestTrackableValue,
+		significantFigures:    significantFigures,
+		min:                   math.MaxInt64,
+	}
+
+	largestValueWithSingleUnitResolution := 2 * math.Pow10(significantFigures)
+	subBucketCountMagnitude := uint(math.Ceil(math.Log2(largestValueWithSingleUnitResolution)))
+	h.subBucketHalfCountMagnitude = subBucketCountMagnitude - 1
+	h.subBucketCount = 1 << subBucketCountMagnitude
+	h.subBucketHalfCount = h.subBucketCount / 2
+	h.subBucketMask = int64(h.subBucketCount - 1)
+
+	// number of buckets needed to cover the highest trackable value, each
+	// bucket doubles the range of the previous one
+	smallestUntrackableValue := int64(h.subBucketCount)
+	bucketsNeeded := 1
+	for smallestUntrackableValue <= highestTrackableValue {
+		if smallestUntrackableValue > math.MaxInt64/2 {
+			bucketsNeeded++
+			break
+		}
+		smallestUntrackableValue <<= 1
+		bucketsNeeded++
+	}
+	h.counts = make([]int64, (bucketsNeeded+1)*h.subBucketHalfCount)
+	return h, nil
+}
+
+// RecordValue records the value v, negative values are ignored
+func (h *Histogram) RecordValue(v int64) {
+	h.RecordValues(v, 1)
+}
+
+// RecordValues records the value v n times
+func (h *Histogram) RecordValues(v, n int64) {
+	if v < 0 || n <= 0 {
+		return
+	}
+	if v > h.highestTrackableValue {
+		v = h.highestTrackableValue
+	}
+	h.counts[h.countsIndexFor(v)] += n
+	h.totalCount += n
+	h.sum += float64(v) * float64(n)
+	if v < h.min {
+		h.min = v
+	}
+	if v > h.max {
+		h.max = v
+	}
+}
+
+// Merge adds all values recorded by other to h. The two histograms may be
+// created with different configurations, in which case values of other are
+// re-recorded with the precision of h.
+func (h *Histogram) Merge(other *Histogram) {
+	if other.totalCount == 0 {
+		return
+	}
+	min, max, sum := h.min, h.max, h.sum
+	for i, c := range other.counts {
+		if c == 0 {
+			continue
+		}
+		h.RecordValues(other.valueFromIndex(i), c)
+	}
+	// keep the exact extremes and sum rather than the bucketed ones
+	if other.min < min {
+		min = other.min
+	}
+	if other.max > max {
+		max = other.max
+	}
+	if max > h.highestTrackableValue {
+		max = h.highestTrackableValue
+	}
+	h.min, h.max, h.sum = min, max, sum+other.sum
+}
+
+// TotalCount returns the number of recorded values
+func (h *Histogram) TotalCount() int64 {
+	return h.totalCount
+}
+
+// Min returns the smallest recorded value
+func (h *Histogram) Min() int64 {
+	if h.totalCount == 0 {
+		return 0
+	}
+	return h.min
+}
+
+// Max returns the largest recorded value
+func (h *Histogram) Max() int64 {
+	return h.max
+}
+
+// Mean returns the mean of recorded values
+func (h *Histogram) Mean() float64 {
+	if h.totalCount == 0 {
+		return 0
+	}
+	return h.sum / float64(h.totalCount)
+}
+
+// ValueAtQuantile returns the value below which the given percentage
+// (0 to 100) of recorded values fall
+func (h *Histogram) ValueAtQuantile(q float64) int64 {
+	if h.totalCount == 0 {
+		return 0
+	}
+	if q > 100 {
+		q = 100
+	}
+	countAtPercentile := int64(q/100*float64(h.totalCount) + 0.5)
+	if countAtPercentile < 1 {
+		countAtPercentile = 1
+	}
+	var total int64
+	for i, c := range h.counts {
+		total += c
+		if total >= countAtPercentile {
+			v := h.highestEquivalentValue(h.valueFromIndex(i))
+			if v > h.max {
+				return h.max
+			}
+			return v
+		}
+	}
+	return h.max
+}
+
+func (h *Histogram) bucketIndex(v int64) int {
+	pow2Ceiling := bits.Len64(uint64(v | h.subBucketMask))
+	return pow2Ceiling - int(h.subBucketHalfCountMagnitude+1)
+}
+
+func (h *Histogram) countsIndexFor(v int64) int {
+	bucketIdx := h.bucketIndex(v)
+	subBucketIdx := int(v >> uint(bucketIdx))
+	bucketBaseIdx := (bucketIdx + 1) << h.subBucketHalfCountMagnitude
+	return bucketBaseIdx + subBucketIdx - h.subBucketHalfCount
+}
+
+func (h *Histogram) valueFromIndex(idx int) int64 {
+	bucketIdx := (idx >> h.subBucketHalfCountMagnitude) - 1
+	subBucketIdx := (idx & (h.subBucketHalfCount - 1)) + h.subBucketHalfCount
+	if bucketIdx < 0 {
+		subBucketIdx -= h.subBucketHalfCount
+		bucketIdx = 0
+	}
+	return int64(subBucketIdx) << uint(bucketIdx)
+}
+
+// highestEquivalentValue returns the largest value that shares the same
+// bucket with v
+func (h *Histogram) highestEquivalentValue(v int64) int64 {
+	bucketIdx := h.bucketIndex(v)
+	lowest := (v >> uint(bucketIdx)) << uint(bucketIdx)
+	return lowest + (int64(1) << uint(bucketIdx)) - 1
+}
+
+// snapshot is the serialized form of a Histogram, only non-empty buckets
+// are stored
+type snapshot struct {
+	HighestTrackableValue int64      `json:"highestTrackableValue"`
+	SignificantFigures    int        `json:"significantFigures"`
+	TotalCount            int64      `json:"totalCount"`
+	Min                   int64      `json:"min"`
+	Max                   int64      `json:"max"`
+	Sum                   float64    `json:"sum"`
+	Counts                [][2]int64 `json:"counts"`
+}
+
+// MarshalJSON implements json.Marshaler
+func (h *Histogram) MarshalJSON() ([]byte, error) {
+	s := snapshot{
+		HighestTrackableValue: h.highestTrackableValue,
+		SignificantFigures:    h.significantFigures,
+		TotalCount:            h.totalCount,
+		Min:                   h.Min(),
+		Max:                   h.max,
+		Sum:                   h.sum,
+		Counts:                [][2]int64{},
+	}
+	for i, c := range h.counts {
+		if c != 0 {
+			s.Counts = append(s.Counts, [2]int64{int64(i), c})
+		}
+	}
+	return json.Marshal(&s)
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (h *Histogram) UnmarshalJSON(data []byte) error {
+	s := snapshot{}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	nh, err := New(s.HighestTrackableValue, s.SignificantFigures)
+	if err != nil {
+		return err
+	}
+	for _, c := range s.Counts {
+		if c[0] < 0 || int(c[0]) >= len(nh.counts) {
+			return fmt.Errorf("bucket index %d out of range", c[0])
+		}
+		nh.counts[c[0]] = c[1]
+	}
+	nh.totalCount = s.TotalCount
+	nh.max = s.Max
+	nh.sum = s.Sum
+	if s.TotalCount > 0 {
+		nh.min = s.Min
+	}
+	*h = *nh
+	return nil
+}
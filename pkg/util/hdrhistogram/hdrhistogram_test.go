@@ -0,0 +1,87 @@
+package hdrhistogram
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func newHistogram(t *testing.T) *Histogram {
+	h, err := New(3600*1000, 3)
+	if err != nil {
+		t.Fatalf("fail to create histogram: %s", err)
+	}
+	return h
+}
+
+// withinPrecision checks if get is within the relative error allowed by 3
+// significant figures
+func withinPrecision(want, get int64) bool {
+	diff := want - get
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff*1000 <= want
+}
+
+func TestValueAtQuantile(t *testing.T) {
+	h := newHistogram(t)
+	for v := int64(1); v <= 100000; v++ {
+		h.RecordValue(v)
+	}
+	if h.TotalCount() != 100000 {
+		t.Fatalf("want total count 100000, get %d", h.TotalCount())
+	}
+	for q, want := range map[float64]int64{
+		50:   50000,
+		90:   90000,
+		99:   99000,
+		99.9: 99900,
+		100:  100000,
+	} {
+		if get := h.ValueAtQuantile(q); !withinPrecision(want, get) {
+			t.Fatalf("p%v: want %d, get %d", q, want, get)
+		}
+	}
+	if h.Min() != 1 || h.Max() != 100000 {
+		t.Fatalf("want min 1 max 100000, get min %d max %d", h.Min(), h.Max())
+	}
+}
+
+func TestMergeAndSerialize(t *testing.T) {
+	h1 := newHistogram(t)
+	h2, err := New(1000, 2)
+	if err != nil {
+		t.Fatalf("fail to create histogram: %s", err)
+	}
+	for v := int64(0); v < 500; v++ {
+		h1.RecordValue(v)
+		h2.RecordValue(v + 500)
+	}
+
+	byts, err := json.Marshal(h2)
+	if err != nil {
+		t.Fatalf("fail to marshal histogram: %s", err)
+	}
+	restored := &Histogram{}
+	if err := json.Unmarshal(byts, restored); err != nil {
+		t.Fatalf("fail to unmarshal histogram: %s", err)
+	}
+	if restored.TotalCount() != h2.TotalCount() || restored.Max() != h2.Max() ||
+		restored.ValueAtQuantile(50) != h2.ValueAtQuantile(50) {
+		t.Fatalf("restored histogram differs from the original one")
+	}
+
+	h1.Merge(restored)
+	if h1.TotalCount() != 1000 {
+		t.Fatalf("want total count 1000, get %d", h1.TotalCount())
+	}
+	if h1.Min() != 0 || h1.Max() != 999 {
+		t.Fatalf("want min 0 max 999, get min %d max %d", h1.Min(), h1.Max())
+	}
+	if mean := h1.Mean(); mean != 499.5 {
+		t.Fatalf("want mean 499.5, get %v", mean)
+	}
+	if p50 := h1.ValueAtQuantile(50); p50 < 495 || p50 > 505 {
+		t.Fatalf("want p50 around 500, get %d", p50)
+	}
+}
@@ -0,0 +1,158 @@
+package perftimestamp
+
+import (
+	"fmt"
+	"strconv"
+
+	"k8s.io/api/core/v1"
+
+	"github.com/charleszheng44/vc-bench/pkg/constants"
+)
+
+// Stage is a point in the creation lifecycle of a pod, its name is also the
+// column name used by the runtime data log
+type Stage string
+
+const (
+	StageTenantCreation Stage = "tenantCreation"
+	StageDWSReconcile   Stage = "dwsDequeue"
+	StageSuperCreation  Stage = "superCreation"
+	StageSuperReady     Stage = "superReady"
+	StageUWSReconcile   Stage = "uwsDequeue"
+	StageFirstUpdate    Stage = "tenantUpdate"
+)
+
+// stageAnnotations maps every stage, in lifecycle order, to the annotation
+// holding its timestamp. The tenant creation has no annotation, it is the
+// creation timestamp of the pod.
+var stageAnnotations = []struct {
+	stage Stage
+	key   string
+}{
+	{StageTenantCreation, ""},
+	{StageDWSReconcile, constants.LabelPerfBenchDWSReconcileTime},
+	{StageSuperCreation, constants.LabelPerfBenchSuperCreationTime},
+	{StageSuperReady, constants.LabelPerfBenchSuperReadyTime},
+	{StageUWSReconcile, constants.LabelPerfBenchUWSReconcileTime},
+	{StageFirstUpdate, constants.LabelPerfBenchFirstUpdateTime},
+}
+
+// Stages returns all stages in lifecycle order
+func Stages() []Stage {
+	ret := make([]Stage, 0, len(stageAnnotations))
+	for _, sa := range stageAnnotations {
+		ret = append(ret, sa.stage)
+	}
+	return ret
+}
+
+// AnnotationKey returns the annotation holding the timestamp of the stage,
+// "" if the stage is not recorded by an annotation
+func AnnotationKey(stage Stage) string {
+	for _, sa := range stageAnnotations {
+		if sa.stage == stage {
+			return sa.key
+		}
+	}
+	return ""
+}
+
+// StageTimeline holds the timestamps, in unix seconds, of the stages a pod
+// has gone through. Stages that haven't happened are absent.
+type StageTimeline map[Stage]int64
+
+// ParseTimeline reads the timeline of the pod from its creation timestamp
+// and annotations. Malformed annotations are reported by the error, the
+// stages parsed successfully are still returned.
+func ParseTimeline(pod *v1.Pod) (StageTimeline, error) {
+	tl := make(StageTimeline)
+	if ct := pod.GetCreationTimestamp(); !ct.IsZero() {
+		tl[StageTenantCreation] = ct.Unix()
+	}
+	var err error
+	annos := pod.GetAnnotations()
+	for _, sa := range stageAnnotations {
+		if sa.key == "" {
+			continue
+		}
+		str, exist := annos[sa.key]
+		if !exist {
+			continue
+		}
+		ts, perr := strconv.ParseInt(str, 10, 64)
+		if perr != nil {
+			if err == nil {
+				err = fmt.Errorf("invalid timestamp of stage %s: %s", sa.stage, perr)
+			}
+			continue
+		}
+		tl[sa.stage] = ts
+	}
+	return tl, err
+}
+
+// Encode annotates the timestamps of the timeline on the pod, existing
+// annotations are kept. The tenant creation is not encoded.
+func (tl StageTimeline) Encode(pod *v1.Pod) {
+	ctx := make(map[string]int64)
+	for stage, ts := range tl {
+		if key := AnnotationKey(stage); key != "" {
+			ctx[key] = ts
+		}
+	}
+	AnnotateTimestampsIfNotExist(pod, ctx)
+}
+
+// Has tells if the timestamp of the stage is recorded
+func (tl StageTimeline) Has(stage Stage) bool {
+	_, exist := tl[stage]
+	return exist
+}
+
+// Missing returns the stages that are not recorded, in lifecycle order
+func (tl StageTimeline) Missing() []Stage {
+	var missing []Stage
+	for _, sa := range stageAnnotations {
+		if !tl.Has(sa.stage) {
+			missing = append(missing, sa.stage)
+		}
+	}
+	return missing
+}
+
+// Complete tells if every stage is recorded
+func (tl StageTimeline) Complete() bool {
+	return len(tl.Missing()) == 0
+}
+
+// Duration returns the seconds from one stage to another, false if either
+// of them is not recorded
+func (tl StageTimeline) Duration(from, to Stage) (int64, bool) {
+	f, fok := tl[from]
+	t, tok := tl[to]
+	if !fok || !tok {
+		return 0, false
+	}
+	return t - f, true
+}
+
+// Validate checks that the recorded stages happen in lifecycle order,
+// missing stages are skipped
+func (tl StageTimeline) Validate() error {
+	var (
+		prev   Stage
+		prevTs int64
+		seen   bool
+	)
+	for _, sa := range stageAnnotations {
+		ts, exist := tl[sa.stage]
+		if !exist {
+			continue
+		}
+		if seen && ts < prevTs {
+			return fmt.Errorf("stage %s(%d) happens before stage %s(%d)", sa.stage, ts, prev, prevTs)
+		}
+		prev, prevTs, seen = sa.stage, ts, true
+	}
+	return nil
+}
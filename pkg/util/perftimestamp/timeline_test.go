@@ -0,0 +1,77 @@
+package perftimestamp
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/charleszheng44/vc-bench/pkg/constants"
+)
+
+func TestParseTimeline(t *testing.T) {
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+		CreationTimestamp: metav1.Unix(100, 0),
+		Annotations: map[string]string{
+			constants.LabelPerfBenchDWSReconcileTime:  "101",
+			constants.LabelPerfBenchSuperCreationTime: "102",
+			constants.LabelPerfBenchSuperReadyTime:    "bad",
+			constants.LabelPerfBenchFirstUpdateTime:   "105",
+		},
+	}}
+	tl, err := ParseTimeline(pod)
+	if err == nil {
+		t.Error("expect an error for the malformed superReady timestamp")
+	}
+	expect := StageTimeline{
+		StageTenantCreation: 100,
+		StageDWSReconcile:   101,
+		StageSuperCreation:  102,
+		StageFirstUpdate:    105,
+	}
+	if !reflect.DeepEqual(tl, expect) {
+		t.Errorf("expect %v, got %v", expect, tl)
+	}
+	if missing := tl.Missing(); !reflect.DeepEqual(missing, []Stage{StageSuperReady, StageUWSReconcile}) {
+		t.Errorf("unexpected missing stages %v", missing)
+	}
+	if tl.Complete() {
+		t.Error("expect the timeline to be incomplete")
+	}
+	if d, ok := tl.Duration(StageTenantCreation, StageFirstUpdate); !ok || d != 5 {
+		t.Errorf("expect duration 5, got %d(%t)", d, ok)
+	}
+	if _, ok := tl.Duration(StageSuperReady, StageFirstUpdate); ok {
+		t.Error("expect no duration from a missing stage")
+	}
+}
+
+func TestEncodeTimeline(t *testing.T) {
+	pod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{
+		Annotations: map[string]string{constants.LabelPerfBenchDWSReconcileTime: "1"},
+	}}
+	StageTimeline{
+		StageTenantCreation: 100,
+		StageDWSReconcile:   101,
+		StageUWSReconcile:   104,
+	}.Encode(pod)
+	expect := map[string]string{
+		constants.LabelPerfBenchDWSReconcileTime: "1",
+		constants.LabelPerfBenchUWSReconcileTime: "104",
+	}
+	if !reflect.DeepEqual(pod.Annotations, expect) {
+		t.Errorf("expect %v, got %v", expect, pod.Annotations)
+	}
+}
+
+func TestValidateTimeline(t *testing.T) {
+	tl := StageTimeline{StageTenantCreation: 100, StageSuperReady: 103, StageFirstUpdate: 105}
+	if err := tl.Validate(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+	tl[StageUWSReconcile] = 102
+	if err := tl.Validate(); err == nil {
+		t.Error("expect an error as uwsDequeue happens before superReady")
+	}
+}
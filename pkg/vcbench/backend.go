@@ -0,0 +1,292 @@
+package vcbench
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"sigs.k8s.io/multi-tenancy/incubator/virtualcluster/pkg/apis"
+	tenancyv1alpha1 "sigs.k8s.io/multi-tenancy/incubator/virtualcluster/pkg/apis/tenancy/v1alpha1"
+)
+
+const defaultFakeWatchInterval = 10 * time.Millisecond
+
+// TenantMasterCluster is the cluster that holds the tenant masters, or the
+// cluster the baseline benchmark runs on
+type TenantMasterCluster interface {
+	// Client accesses the cluster, it also knows the VirtualCluster and
+	// ClusterVersion types
+	Client() client.Client
+	// NewClient builds another client of the cluster, which has its own
+	// connection and rate limit
+	NewClient() (client.Client, error)
+	// RestConfig is the config the clients are built from, nil if the
+	// cluster is not served by an apiserver
+	RestConfig() *rest.Config
+	// WatchPods watches the pods selected by the selector in all namespaces
+	WatchPods(selector labels.Selector) (watch.Interface, error)
+}
+
+// VCClientFactory builds the clients of vc
+type VCClientFactory interface {
+	// VCClient returns a client of the vc and the config it is built from,
+	// the config is nil if the vc is not served by an apiserver
+	VCClient(vc *tenancyv1alpha1.VirtualCluster) (client.Client, *rest.Config, error)
+}
+
+// Backend provides the clusters a benchmark runs against
+type Backend interface {
+	TenantMasterCluster
+	VCClientFactory
+	// SuperCluster returns a client of the super cluster and the config it
+	// is built from, the config is nil if the cluster is not served by an
+	// apiserver
+	SuperCluster() (client.Client, *rest.Config, error)
+}
+
+var (
+	addVCTypesOnce sync.Once
+	addVCTypesErr  error
+)
+
+// addVCTypesToScheme adds the VirtualCluster and ClusterVersion types to
+// the global scheme once, as registering the types again races with the
+// clients decoding with the scheme
+func addVCTypesToScheme() error {
+	addVCTypesOnce.Do(func() {
+		addVCTypesErr = apis.AddToScheme(scheme.Scheme)
+	})
+	return addVCTypesErr
+}
+
+// KubeBackend accesses real clusters through their apiservers, each vc is
+// accessed with its admin-kubeconfig secret
+type KubeBackend struct {
+	// SuperKubeconfig is the kubeconfig file of the super cluster, the
+	// tenant masters cluster is taken as the super cluster if not set
+	SuperKubeconfig string
+
+	cli       client.Client
+	cfg       *rest.Config
+	clientset kubernetes.Interface
+}
+
+func NewKubeBackend(kbCfgPath string) (*KubeBackend, error) {
+	kbCfgByts, err := ioutil.ReadFile(kbCfgPath)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := clientcmd.RESTConfigFromKubeConfig(kbCfgByts)
+	if err != nil {
+		return nil, err
+	}
+	// add Virtualcluster and ClusterVersion schemes to client
+	if err := addVCTypesToScheme(); err != nil {
+		return nil, err
+	}
+	cli, err := client.New(cfg, client.Options{Scheme: scheme.Scheme})
+	if err != nil {
+		return nil, err
+	}
+	cs, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &KubeBackend{cli: cli, cfg: cfg, clientset: cs}, nil
+}
+
+func (kb *KubeBackend) Client() client.Client {
+	return kb.cli
+}
+
+func (kb *KubeBackend) NewClient() (client.Client, error) {
+	return client.New(kb.cfg, client.Options{Scheme: scheme.Scheme})
+}
+
+func (kb *KubeBackend) RestConfig() *rest.Config {
+	return kb.cfg
+}
+
+func (kb *KubeBackend) WatchPods(selector labels.Selector) (watch.Interface, error) {
+	return kb.clientset.CoreV1().Pods(metav1.NamespaceAll).Watch(context.TODO(), metav1.ListOptions{
+		LabelSelector: selector.String(),
+	})
+}
+
+func (kb *KubeBackend) SuperCluster() (client.Client, *rest.Config, error) {
+	if kb.SuperKubeconfig == "" {
+		return kb.cli, kb.cfg, nil
+	}
+	cfg, err := clientcmd.BuildConfigFromFlags("", kb.SuperKubeconfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	cli, err := client.New(cfg, client.Options{Scheme: scheme.Scheme})
+	if err != nil {
+		return nil, nil, err
+	}
+	return cli, cfg, nil
+}
+
+func (kb *KubeBackend) VCClient(vc *tenancyv1alpha1.VirtualCluster) (client.Client, *rest.Config, error) {
+	vcRestCfg, err := buildVcRestConfig(kb.cli, vc)
+	if err != nil {
+		return nil, nil, err
+	}
+	vcCli, err := client.New(vcRestCfg, client.Options{Scheme: scheme.Scheme})
+	if err != nil {
+		return nil, nil, err
+	}
+	return vcCli, vcRestCfg, nil
+}
+
+// FakeBackend keeps the tenant masters cluster and every vc in memory, so
+// that the benchmarks can run without a live setup. Nothing acts on the
+// objects, e.g. pods are never scheduled, unless the caller does.
+type FakeBackend struct {
+	// Tenant holds the VirtualClusters, Super is the super cluster, which
+	// is only changed by a simulated syncer
+	Tenant client.Client
+	Super  client.Client
+	// WatchInterval is the interval WatchPods lists the pods at
+	WatchInterval time.Duration
+
+	sync.Mutex
+	// vcs are the clusters of the vc keyed by the name of the vc, each is
+	// created on the first access
+	vcs map[string]client.Client
+}
+
+func NewFakeBackend(objs ...runtime.Object) *FakeBackend {
+	if err := addVCTypesToScheme(); err != nil {
+		log.Printf("fail to add the VirtualCluster types to scheme: %s", err)
+	}
+	return &FakeBackend{
+		Tenant:        fake.NewFakeClientWithScheme(scheme.Scheme, objs...),
+		Super:         fake.NewFakeClientWithScheme(scheme.Scheme),
+		WatchInterval: defaultFakeWatchInterval,
+		vcs:           make(map[string]client.Client),
+	}
+}
+
+// AddVirtualCluster creates a Running vc in the tenant masters cluster
+func (fb *FakeBackend) AddVirtualCluster(namespace, name string) (*tenancyv1alpha1.VirtualCluster, error) {
+	vc := &tenancyv1alpha1.VirtualCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, UID: types.UID("uid-" + name)},
+		Status: tenancyv1alpha1.VirtualClusterStatus{
+			Phase:            tenancyv1alpha1.ClusterRunning,
+			ClusterNamespace: fmt.Sprintf("%s-%s", namespace, name),
+		},
+	}
+	if err := fb.Tenant.Create(context.TODO(), vc); err != nil {
+		return nil, err
+	}
+	return vc, nil
+}
+
+// VCCluster returns the cluster of the vc with the name
+func (fb *FakeBackend) VCCluster(name string) client.Client {
+	fb.Lock()
+	defer fb.Unlock()
+	if _, exist := fb.vcs[name]; !exist {
+		fb.vcs[name] = fake.NewFakeClientWithScheme(scheme.Scheme)
+	}
+	return fb.vcs[name]
+}
+
+func (fb *FakeBackend) Client() client.Client {
+	return fb.Tenant
+}
+
+func (fb *FakeBackend) NewClient() (client.Client, error) {
+	return fb.Tenant, nil
+}
+
+func (fb *FakeBackend) RestConfig() *rest.Config {
+	return nil
+}
+
+// WatchPods polls the pods, and reports every selected pod as Modified
+// at each poll
+func (fb *FakeBackend) WatchPods(selector labels.Selector) (watch.Interface, error) {
+	pw := &pollingWatcher{
+		result: make(chan watch.Event),
+		stopCh: make(chan struct{}),
+	}
+	go pw.run(func() ([]runtime.Object, error) {
+		pl := &v1.PodList{}
+		if err := fb.Tenant.List(context.TODO(), pl, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+			return nil, err
+		}
+		var objs []runtime.Object
+		for i := range pl.Items {
+			objs = append(objs, &pl.Items[i])
+		}
+		return objs, nil
+	}, fb.WatchInterval)
+	return pw, nil
+}
+
+func (fb *FakeBackend) SuperCluster() (client.Client, *rest.Config, error) {
+	return fb.Super, nil, nil
+}
+
+func (fb *FakeBackend) VCClient(vc *tenancyv1alpha1.VirtualCluster) (client.Client, *rest.Config, error) {
+	return fb.VCCluster(vc.GetName()), nil, nil
+}
+
+// pollingWatcher implements watch.Interface by listing the objects, every
+// listed object is reported as Modified
+type pollingWatcher struct {
+	result chan watch.Event
+	stopCh chan struct{}
+	once   sync.Once
+}
+
+func (pw *pollingWatcher) ResultChan() <-chan watch.Event {
+	return pw.result
+}
+
+func (pw *pollingWatcher) Stop() {
+	pw.once.Do(func() { close(pw.stopCh) })
+}
+
+func (pw *pollingWatcher) run(list func() ([]runtime.Object, error), interval time.Duration) {
+	defer close(pw.result)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		objs, err := list()
+		if err != nil {
+			log.Printf("fail to list objects: %s", err)
+		}
+		for _, obj := range objs {
+			select {
+			case pw.result <- watch.Event{Type: watch.Modified, Object: obj}:
+			case <-pw.stopCh:
+				return
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-pw.stopCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package vcbench
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/charleszheng44/vc-bench/pkg/constants"
+	"github.com/charleszheng44/vc-bench/pkg/tenant"
+)
+
+// actOnPods calls fn on every pod of the clusters until stopCh is closed,
+// as the syncer or the kubelet would
+func actOnPods(t *testing.T, clis []client.Client, stopCh <-chan struct{}, fn func(pod *v1.Pod) bool) {
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(5 * time.Millisecond):
+		}
+		for _, cli := range clis {
+			pl := &v1.PodList{}
+			if err := cli.List(context.TODO(), pl); err != nil {
+				t.Errorf("fail to list pods: %s", err)
+				return
+			}
+			for i := range pl.Items {
+				if fn(&pl.Items[i]) {
+					if err := cli.Update(context.TODO(), &pl.Items[i]); err != nil {
+						t.Errorf("fail to update pod: %s", err)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestRunBenchWithFakeBackend(t *testing.T) {
+	fb := NewFakeBackend()
+	for _, name := range []string{"vc1", "vc2"} {
+		if _, err := fb.AddVirtualCluster("default", name); err != nil {
+			t.Fatalf("fail to add vc: %s", err)
+		}
+	}
+	tenants := []tenant.Tenant{{ID: "t1", NumPods: 2}, {ID: "t2", NumPods: 1}}
+	be, err := NewBenchExecutorWithBackend(fb, tenants, 0, 0, 2)
+	if err != nil {
+		t.Fatalf("fail to build executor: %s", err)
+	}
+	be.NamespaceWait = 0
+	be.PollInterval = 10 * time.Millisecond
+	if be.NumVC() != 2 {
+		t.Fatalf("expect 2 vc, got %d", be.NumVC())
+	}
+
+	dir, err := ioutil.TempDir("", "backend")
+	if err != nil {
+		t.Fatalf("fail to create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	eventsPath := path.Join(dir, "run.events.jsonl")
+	// events of the vc and the super cluster are collected from the backend
+	if err := be.CollectEvents(eventsPath); err != nil {
+		t.Fatalf("fail to collect events: %s", err)
+	}
+	be.Events.Interval = 10 * time.Millisecond
+
+	// the syncer stamps the lifecycle of every pod and records an event
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	vcClis := []client.Client{fb.VCCluster("vc1"), fb.VCCluster("vc2")}
+	go actOnPods(t, vcClis, stopCh, func(pod *v1.Pod) bool {
+		annos := pod.GetAnnotations()
+		if annos[constants.LabelPerfBenchFirstUpdateTime] != "" {
+			return false
+		}
+		if annos == nil {
+			annos = make(map[string]string)
+		}
+		for key, ts := range map[string]int{
+			constants.LabelPerfBenchDWSReconcileTime:  101,
+			constants.LabelPerfBenchSuperCreationTime: 102,
+			constants.LabelPerfBenchSuperReadyTime:    103,
+			constants.LabelPerfBenchUWSReconcileTime:  104,
+			constants.LabelPerfBenchFirstUpdateTime:   105,
+		} {
+			annos[key] = strconv.Itoa(ts)
+		}
+		pod.SetAnnotations(annos)
+		pod.SetCreationTimestamp(metav1.Unix(100, 0))
+		return true
+	})
+	go func() {
+		for _, cli := range vcClis {
+			cli.Create(context.TODO(), &v1.Event{
+				ObjectMeta:     metav1.ObjectMeta{Name: "e1", Namespace: DefaultBenchNamespace, UID: "e1"},
+				InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: "pod"},
+				Reason:         "Scheduled",
+				Count:          1,
+			})
+		}
+	}()
+
+	done := make(chan error)
+	go func() { done <- be.RunBench() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("fail to run benchmark: %s", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("benchmark is not complete")
+	}
+
+	var complete int
+	for _, rs := range be.RuntimeStatics {
+		if rs.PodCreated {
+			complete++
+		}
+	}
+	if len(be.RuntimeStatics) != 3 || complete != 3 {
+		t.Errorf("expect 3 complete pods, got %d of %d", complete, len(be.RuntimeStatics))
+	}
+	if h := be.Histograms.Scopes[ScopeAll][StageTotal]; h == nil || h.TotalCount() != 3 || h.Max() != 5000 {
+		t.Errorf("unexpected histogram of the total latency")
+	}
+	events, err := LoadEventRecords(eventsPath)
+	if err != nil {
+		t.Fatalf("fail to load events: %s", err)
+	}
+	if len(events) != 2 {
+		t.Errorf("expect an event per vc, got %d", len(events))
+	}
+
+	result := be.CleanUp(CleanUpOptions{Namespace: DefaultBenchNamespace, Timeout: time.Second, PollInterval: time.Millisecond})
+	if len(result.Errors) != 0 || len(result.Leftovers) != 0 {
+		t.Errorf("unexpected clean up result %+v", result)
+	}
+}
+
+// failingCreateClient rejects every creation, e.g. as the quota is exceeded
+type failingCreateClient struct {
+	client.Client
+}
+
+func (fc failingCreateClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	return errors.New("exceeded quota")
+}
+
+func TestRunBaseBenchWithFailures(t *testing.T) {
+	fb := NewFakeBackend()
+	bbe, err := NewBaseBenchExecutorWithBackend(fb, 4, 0, 2, false)
+	if err != nil {
+		t.Fatalf("fail to build executor: %s", err)
+	}
+	bbe.ReadyTimeout = 100 * time.Millisecond
+	bbe.CliLst[1] = failingCreateClient{bbe.CliLst[1]}
+
+	// the kubelet gets every pod but podbench-0/pod2 Ready
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go actOnPods(t, []client.Client{fb.Tenant}, stopCh, func(pod *v1.Pod) bool {
+		if len(pod.Status.Conditions) != 0 || pod.GetName() == defaultPodBaseName+"2" {
+			return false
+		}
+		pod.Status.Conditions = []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}}
+		return true
+	})
+
+	done := make(chan error)
+	go func() { done <- bbe.RunBaseBench() }()
+	select {
+	case err = <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("baseline benchmark is not complete")
+	}
+	if err == nil {
+		t.Fatalf("expect the failures to be reported")
+	}
+	for _, want := range []string{"tenant(1): 2 of 2 pods are not submitted", "1 pods are not ready", "podbench-0-pod2"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("%q not found in %q", want, err)
+		}
+	}
+	if len(bbe.RuntimeStatics) != 1 {
+		t.Errorf("expect 1 recorded pod, got %d", len(bbe.RuntimeStatics))
+	}
+}
+
+func TestRunBaseBenchWithFakeBackend(t *testing.T) {
+	fb := NewFakeBackend()
+	bbe, err := NewBaseBenchExecutorWithBackend(fb, 4, 0, 2, false)
+	if err != nil {
+		t.Fatalf("fail to build executor: %s", err)
+	}
+
+	// the kubelet gets every pod Ready
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go actOnPods(t, []client.Client{fb.Tenant}, stopCh, func(pod *v1.Pod) bool {
+		if len(pod.Status.Conditions) != 0 {
+			return false
+		}
+		pod.SetCreationTimestamp(metav1.Unix(100, 0))
+		for i, ct := range []v1.PodConditionType{v1.PodScheduled, v1.PodInitialized, v1.ContainersReady, v1.PodReady} {
+			pod.Status.Conditions = append(pod.Status.Conditions, v1.PodCondition{
+				Type: ct, Status: v1.ConditionTrue, LastTransitionTime: metav1.Unix(int64(101+i), 0)})
+		}
+		return true
+	})
+
+	done := make(chan error)
+	go func() { done <- bbe.RunBaseBench() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("fail to run baseline benchmark: %s", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("baseline benchmark is not complete")
+	}
+	for i := 0; i < 2; i++ {
+		for n := 1; n <= 2; n++ {
+			podKey := fmt.Sprintf("%s-%d-%s%d", DefaultBenchNamespace, i, defaultPodBaseName, n)
+			if bs := bbe.RuntimeStatics[podKey]; bs == nil || bs.ReadyTimestamp != 104 || bs.TenantID != baseTenantID(i) {
+				t.Errorf("unexpected statics of %s: %+v", podKey, bs)
+			}
+		}
+	}
+
+	// per-tenant identities need an apiserver
+	bbe.TenantIdentity = TenantIdentityToken
+	bbe.ShareNamespace = true
+	if err := bbe.prepareTenant(0); err == nil {
+		t.Errorf("expect the token identity to fail on the fake backend")
+	}
+
+	result, err := CleanUpBase(fb.Client(), "", CleanUpOptions{Timeout: time.Second, PollInterval: time.Millisecond})
+	if err != nil {
+		t.Fatalf("fail to clean up: %s", err)
+	}
+	nsl := &v1.NamespaceList{}
+	if err := fb.Client().List(context.TODO(), nsl); err != nil {
+		t.Fatalf("fail to list namespaces: %s", err)
+	}
+	if len(result.Errors) != 0 || len(result.Leftovers) != 0 || len(nsl.Items) != 0 {
+		t.Errorf("unexpected clean up result %+v, %d namespaces left", result, len(nsl.Items))
+	}
+}
@@ -0,0 +1,368 @@
+package vcbench
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	flowcontrolv1alpha1 "k8s.io/api/flowcontrol/v1alpha1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// APFShared maps every tenant to its own flow of one priority level, so
+	// that tenants are fair queued by the apiserver. APFIsolated gives every
+	// tenant its own priority level, i.e., its own share of concurrency.
+	APFShared   = "shared"
+	APFIsolated = "isolated"
+
+	// APFMetricPrefix is the prefix of the APF metrics of the apiserver
+	APFMetricPrefix = "apiserver_flowcontrol_"
+
+	defaultAPFShares           = 10
+	defaultAPFQueues           = 64
+	defaultAPFHandSize         = 8
+	defaultAPFQueueLengthLimit = 50
+	// apfMatchingPrecedence is lower than the one of the suggested
+	// "service-accounts" and "global-default" flow schemas, so the requests
+	// of the tenants are matched by the flow schemas of the benchmark
+	apfMatchingPrecedence = 1000
+	apfReadyTimeout       = 30 * time.Second
+)
+
+// APFConfig configures the API Priority and Fairness objects created by the
+// baseline benchmark
+type APFConfig struct {
+	// Mode is one of "shared" or "isolated"
+	Mode string
+	// Shares is the assured concurrency shares of each priority level
+	Shares int32
+	// Queues, HandSize and QueueLengthLimit configure the queuing of each
+	// priority level
+	Queues           int32
+	HandSize         int32
+	QueueLengthLimit int32
+}
+
+// NewAPFConfig returns an APFConfig of the mode with the default shares
+// and queuing
+func NewAPFConfig(mode string) *APFConfig {
+	return &APFConfig{
+		Mode:             mode,
+		Shares:           defaultAPFShares,
+		Queues:           defaultAPFQueues,
+		HandSize:         defaultAPFHandSize,
+		QueueLengthLimit: defaultAPFQueueLengthLimit,
+	}
+}
+
+// apfPriorityLevel returns a PriorityLevelConfiguration with the name
+func (ac *APFConfig) apfPriorityLevel(name, runID string) *flowcontrolv1alpha1.PriorityLevelConfiguration {
+	pl := &flowcontrolv1alpha1.PriorityLevelConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: flowcontrolv1alpha1.PriorityLevelConfigurationSpec{
+			Type: flowcontrolv1alpha1.PriorityLevelEnablementLimited,
+			Limited: &flowcontrolv1alpha1.LimitedPriorityLevelConfiguration{
+				AssuredConcurrencyShares: ac.Shares,
+				LimitResponse: flowcontrolv1alpha1.LimitResponse{
+					Type: flowcontrolv1alpha1.LimitResponseTypeQueue,
+					Queuing: &flowcontrolv1alpha1.QueuingConfiguration{
+						Queues:           ac.Queues,
+						HandSize:         ac.HandSize,
+						QueueLengthLimit: ac.QueueLengthLimit,
+					},
+				},
+			},
+		},
+	}
+	setRunLabels(pl, runID)
+	return pl
+}
+
+// apfFlowSchema returns a FlowSchema that maps the requests of the
+// ServiceAccount of the tenant on pods in the namespace to the priority
+// level. The flow schema is per tenant, so each tenant is a flow.
+func apfFlowSchema(tenantID, namespace, priorityLevel, runID string) *flowcontrolv1alpha1.FlowSchema {
+	fs := &flowcontrolv1alpha1.FlowSchema{
+		ObjectMeta: metav1.ObjectMeta{Name: tenantSAName(tenantID)},
+		Spec: flowcontrolv1alpha1.FlowSchemaSpec{
+			PriorityLevelConfiguration: flowcontrolv1alpha1.PriorityLevelConfigurationReference{
+				Name: priorityLevel,
+			},
+			MatchingPrecedence: apfMatchingPrecedence,
+			Rules: []flowcontrolv1alpha1.PolicyRulesWithSubjects{
+				{
+					Subjects: []flowcontrolv1alpha1.Subject{
+						{
+							Kind: flowcontrolv1alpha1.SubjectKindServiceAccount,
+							ServiceAccount: &flowcontrolv1alpha1.ServiceAccountSubject{
+								Namespace: namespace,
+								Name:      tenantSAName(tenantID),
+							},
+						},
+					},
+					ResourceRules: []flowcontrolv1alpha1.ResourcePolicyRule{
+						{
+							Verbs:      []string{flowcontrolv1alpha1.VerbAll},
+							APIGroups:  []string{""},
+							Resources:  []string{"pods"},
+							Namespaces: []string{namespace},
+						},
+					},
+				},
+			},
+		},
+	}
+	setRunLabels(fs, runID)
+	return fs
+}
+
+// setupAPF creates the priority levels and a flow schema for every tenant,
+// and waits until the flow schemas are taken by the apiserver
+func (bbe *BaseBenchExecutor) setupAPF() error {
+	if bbe.TenantIdentity == "" || bbe.TenantIdentity == TenantIdentityShared {
+		// the requests of all tenants come from the same user, which is
+		// likely exempt from APF as a member of system:masters
+		return fmt.Errorf("API Priority and Fairness requires a per-tenant identity, i.e., 'token' or 'impersonate'")
+	}
+	var objs []runtime.Object
+	sharedLevel := tenantSAName("shared")
+	if bbe.APF.Mode == APFShared {
+		objs = append(objs, bbe.APF.apfPriorityLevel(sharedLevel, bbe.RunID))
+	}
+	for i := range bbe.CliLst {
+		tenantID := baseTenantID(i)
+		level := sharedLevel
+		if bbe.APF.Mode == APFIsolated {
+			level = tenantSAName(tenantID)
+			objs = append(objs, bbe.APF.apfPriorityLevel(level, bbe.RunID))
+		}
+		objs = append(objs, apfFlowSchema(tenantID, bbe.tenantNamespace(i), level, bbe.RunID))
+	}
+	for _, obj := range objs {
+		if err := bbe.adminCli.Create(context.TODO(), obj); err != nil {
+			return err
+		}
+		bbe.apfObjects = append(bbe.apfObjects, obj)
+	}
+	log.Printf("%d APF objects are created in %s mode", len(objs), bbe.APF.Mode)
+	return waitFlowSchemasReady(bbe.adminCli, bbe.apfObjects, time.Now().Add(apfReadyTimeout))
+}
+
+// waitFlowSchemasReady waits until the apiserver marks every flow schema as
+// not dangling, an error is returned if the deadline passes, as the run
+// would not be under the flows
+func waitFlowSchemasReady(cli client.Client, objs []runtime.Object, deadline time.Time) error {
+	for _, obj := range objs {
+		if _, ok := obj.(*flowcontrolv1alpha1.FlowSchema); !ok {
+			continue
+		}
+		name := obj.(metav1.Object).GetName()
+		for {
+			fs := &flowcontrolv1alpha1.FlowSchema{}
+			if err := cli.Get(context.TODO(), types.NamespacedName{Name: name}, fs); err == nil {
+				if flowSchemaReady(fs) {
+					break
+				}
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("flowschema %s is not taken by the apiserver in %s", name, apfReadyTimeout)
+			}
+			time.Sleep(time.Second)
+		}
+	}
+	return nil
+}
+
+func flowSchemaReady(fs *flowcontrolv1alpha1.FlowSchema) bool {
+	for _, c := range fs.Status.Conditions {
+		if c.Type == flowcontrolv1alpha1.FlowSchemaConditionDangling {
+			return c.Status == flowcontrolv1alpha1.ConditionFalse
+		}
+	}
+	return false
+}
+
+// removeAPF deletes the APF objects created by setupAPF
+func (bbe *BaseBenchExecutor) removeAPF() {
+	for _, obj := range bbe.apfObjects {
+		if err := bbe.adminCli.Delete(context.TODO(), obj); err != nil && !apierrors.IsNotFound(err) {
+			log.Printf("fail to delete %T %s: %s", obj, obj.(metav1.Object).GetName(), err)
+		}
+	}
+	log.Printf("%d APF objects are deleted", len(bbe.apfObjects))
+	bbe.apfObjects = nil
+}
+
+// APFStats summarizes the APF metrics of a pair of priority level and flow
+// schema during a run
+type APFStats struct {
+	PriorityLevel string
+	FlowSchema    string
+	// Dispatched and Rejected are the numbers of requests during the run
+	Dispatched float64
+	Rejected   float64
+	// MaxInqueue and MaxExecuting are the maxima of the gauges of requests
+	// waiting in the queues and being executed
+	MaxInqueue   float64
+	MaxExecuting float64
+	// WaitSum and WaitCount are the sum (in seconds) and count of the time
+	// requests wait in the queues
+	WaitSum   float64
+	WaitCount float64
+}
+
+// apfLabel returns the value of the label in either the camel case naming
+// of earlier releases or the snake case naming of later ones
+func apfLabel(lbs map[string]string, camel, snake string) string {
+	if v, exist := lbs[camel]; exist {
+		return v
+	}
+	return lbs[snake]
+}
+
+// seriesKey identifies a time series by the name and labels of the sample
+func seriesKey(s MetricSample) string {
+	var lbs []string
+	for k, v := range s.Labels {
+		lbs = append(lbs, k+"="+v)
+	}
+	sort.Strings(lbs)
+	return s.Name + "{" + strings.Join(lbs, ",") + "}"
+}
+
+// SummarizeAPFMetrics summarizes the APF metrics of the records scraped from
+// the apiserver. Counters are the increase from the first successful record
+// to the last record of each series, a series missing in the first record
+// is created during the run and increases from 0. Gauges are the maxima.
+func SummarizeAPFMetrics(records []MetricsRecord) []*APFStats {
+	var first map[string]float64
+	last := make(map[string]MetricSample)
+	stats := make(map[[2]string]*APFStats)
+	get := func(s MetricSample) *APFStats {
+		key := [2]string{
+			apfLabel(s.Labels, "priorityLevel", "priority_level"),
+			apfLabel(s.Labels, "flowSchema", "flow_schema"),
+		}
+		st, exist := stats[key]
+		if !exist {
+			st = &APFStats{PriorityLevel: key[0], FlowSchema: key[1]}
+			stats[key] = st
+		}
+		return st
+	}
+	for _, rec := range records {
+		if rec.Gap != "" {
+			continue
+		}
+		baseline := first == nil
+		if baseline {
+			first = make(map[string]float64)
+		}
+		for _, s := range rec.Samples {
+			if !strings.HasPrefix(s.Name, APFMetricPrefix) {
+				continue
+			}
+			switch strings.TrimPrefix(s.Name, APFMetricPrefix) {
+			case "current_inqueue_requests":
+				if st := get(s); s.Value > st.MaxInqueue {
+					st.MaxInqueue = s.Value
+				}
+			case "current_executing_requests":
+				if st := get(s); s.Value > st.MaxExecuting {
+					st.MaxExecuting = s.Value
+				}
+			default:
+				key := seriesKey(s)
+				if baseline {
+					first[key] = s.Value
+				}
+				last[key] = s
+			}
+		}
+	}
+	for key, s := range last {
+		delta := s.Value - first[key]
+		switch strings.TrimPrefix(s.Name, APFMetricPrefix) {
+		case "dispatched_requests_total":
+			get(s).Dispatched += delta
+		case "rejected_requests_total":
+			get(s).Rejected += delta
+		case "request_wait_duration_seconds_sum":
+			get(s).WaitSum += delta
+		case "request_wait_duration_seconds_count":
+			get(s).WaitCount += delta
+		}
+	}
+
+	var ret []*APFStats
+	for _, st := range stats {
+		ret = append(ret, st)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].PriorityLevel != ret[j].PriorityLevel {
+			return ret[i].PriorityLevel < ret[j].PriorityLevel
+		}
+		return ret[i].FlowSchema < ret[j].FlowSchema
+	})
+	return ret
+}
+
+// WriteAPFReport writes the APF stats of the flow schemas that served or
+// queued requests during the run to w
+func WriteAPFReport(w io.Writer, stats []*APFStats) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PRIORITY LEVEL\tFLOW SCHEMA\tDISPATCHED\tREJECTED\tMAX INQUEUE\tMAX EXECUTING\tMEAN WAIT(ms)")
+	for _, st := range stats {
+		if st.Dispatched == 0 && st.Rejected == 0 && st.MaxInqueue == 0 && st.MaxExecuting == 0 {
+			continue
+		}
+		meanWait := 0.0
+		if st.WaitCount != 0 {
+			meanWait = st.WaitSum / st.WaitCount * 1000
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%.0f\t%.0f\t%.0f\t%.0f\t%.1f\n", st.PriorityLevel, st.FlowSchema,
+			st.Dispatched, st.Rejected, st.MaxInqueue, st.MaxExecuting, meanWait)
+	}
+	return tw.Flush()
+}
+
+// deleteAPFObjects deletes the APF objects left by the run, or by any run
+// if runID is empty, of the baseline benchmark, e.g., a run that was
+// interrupted
+func deleteAPFObjects(cli client.Client, runID string) []string {
+	var errs []string
+	fsl := &flowcontrolv1alpha1.FlowSchemaList{}
+	pll := &flowcontrolv1alpha1.PriorityLevelConfigurationList{}
+	var objs []runtime.Object
+	if err := cli.List(context.TODO(), fsl, client.MatchingLabelsSelector{Selector: RunSelector(runID)}); err != nil {
+		errs = append(errs, fmt.Sprintf("fail to list flowschemas: %s", err))
+	}
+	for i := range fsl.Items {
+		objs = append(objs, &fsl.Items[i])
+	}
+	if err := cli.List(context.TODO(), pll, client.MatchingLabelsSelector{Selector: RunSelector(runID)}); err != nil {
+		errs = append(errs, fmt.Sprintf("fail to list prioritylevelconfigurations: %s", err))
+	}
+	for i := range pll.Items {
+		objs = append(objs, &pll.Items[i])
+	}
+	for _, obj := range objs {
+		if err := cli.Delete(context.TODO(), obj); err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, fmt.Sprintf("fail to delete %s: %s", obj.(metav1.Object).GetName(), err))
+		}
+	}
+	if len(objs) != 0 {
+		log.Printf("%d APF objects are deleted", len(objs))
+	}
+	return errs
+}
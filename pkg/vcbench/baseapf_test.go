@@ -0,0 +1,106 @@
+package vcbench
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	flowcontrolv1alpha1 "k8s.io/api/flowcontrol/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSummarizeAPFMetrics(t *testing.T) {
+	sample := func(name, fs string, value float64) MetricSample {
+		return MetricSample{Name: APFMetricPrefix + name, Value: value,
+			Labels: map[string]string{"priorityLevel": "vcbench-shared", "flowSchema": fs}}
+	}
+	records := []MetricsRecord{
+		{Timestamp: 1, Samples: []MetricSample{
+			sample("dispatched_requests_total", "vcbench-tenant0", 10),
+			sample("current_inqueue_requests", "vcbench-tenant0", 3),
+		}},
+		{Timestamp: 2, Gap: "timeout"},
+		{Timestamp: 3, Samples: []MetricSample{
+			sample("dispatched_requests_total", "vcbench-tenant0", 110),
+			sample("current_inqueue_requests", "vcbench-tenant0", 1),
+			sample("request_wait_duration_seconds_sum", "vcbench-tenant0", 0.5),
+			sample("request_wait_duration_seconds_count", "vcbench-tenant0", 100),
+			// the snake case labels of later releases
+			{Name: APFMetricPrefix + "rejected_requests_total", Value: 2,
+				Labels: map[string]string{"priority_level": "vcbench-shared", "flow_schema": "vcbench-tenant1", "reason": "queue-full"}},
+		}},
+	}
+	stats := SummarizeAPFMetrics(records)
+	if len(stats) != 2 {
+		t.Fatalf("expect 2 flow schemas, got %d", len(stats))
+	}
+	if st := stats[0]; st.FlowSchema != "vcbench-tenant0" || st.Dispatched != 100 || st.MaxInqueue != 3 || st.WaitCount != 100 {
+		t.Errorf("unexpected stats of tenant0: %+v", st)
+	}
+	// a series missing in the first record increases from 0
+	if st := stats[1]; st.FlowSchema != "vcbench-tenant1" || st.Rejected != 2 {
+		t.Errorf("unexpected stats of tenant1: %+v", st)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WriteAPFReport(buf, stats); err != nil {
+		t.Fatalf("fail to write report: %s", err)
+	}
+	if !strings.Contains(buf.String(), "vcbench-tenant0") || !strings.Contains(buf.String(), "vcbench-tenant1") {
+		t.Errorf("unexpected report:\n%s", buf.String())
+	}
+}
+
+func TestDeleteAPFObjects(t *testing.T) {
+	pl := NewAPFConfig(APFShared).apfPriorityLevel("vcbench-shared", "r")
+	fs := apfFlowSchema("tenant0", DefaultBenchNamespace, pl.GetName(), "r")
+	if sa := fs.Spec.Rules[0].Subjects[0].ServiceAccount; sa == nil || sa.Name != tenantSAName("tenant0") {
+		t.Fatalf("unexpected subjects %+v", fs.Spec.Rules[0].Subjects)
+	}
+	cli := fake.NewFakeClientWithScheme(scheme.Scheme, pl, fs,
+		&flowcontrolv1alpha1.FlowSchema{ObjectMeta: metav1.ObjectMeta{Name: "global-default"}})
+
+	if errs := deleteAPFObjects(cli, ""); len(errs) != 0 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	fsl := &flowcontrolv1alpha1.FlowSchemaList{}
+	if err := cli.List(context.TODO(), fsl); err != nil {
+		t.Fatalf("fail to list flowschemas: %s", err)
+	}
+	if len(fsl.Items) != 1 || fsl.Items[0].GetName() != "global-default" {
+		t.Errorf("expect only global-default to be kept, got %v", fsl.Items)
+	}
+	pll := &flowcontrolv1alpha1.PriorityLevelConfigurationList{}
+	if err := cli.List(context.TODO(), pll); err != nil {
+		t.Fatalf("fail to list priority levels: %s", err)
+	}
+	if len(pll.Items) != 0 {
+		t.Errorf("expect priority levels to be deleted, got %d", len(pll.Items))
+	}
+}
+
+func TestWaitFlowSchemasReady(t *testing.T) {
+	fs := apfFlowSchema("tenant0", DefaultBenchNamespace, "vcbench-shared", "r")
+	fs.Status.Conditions = []flowcontrolv1alpha1.FlowSchemaCondition{{
+		Type:   flowcontrolv1alpha1.FlowSchemaConditionDangling,
+		Status: flowcontrolv1alpha1.ConditionTrue,
+	}}
+	cli := fake.NewFakeClientWithScheme(scheme.Scheme, fs)
+	objs := []runtime.Object{fs}
+	if err := waitFlowSchemasReady(cli, objs, time.Now()); err == nil {
+		t.Errorf("expect an error for the dangling flowschema")
+	}
+
+	fs.Status.Conditions[0].Status = flowcontrolv1alpha1.ConditionFalse
+	if err := cli.Update(context.TODO(), fs); err != nil {
+		t.Fatalf("fail to update flowschema: %s", err)
+	}
+	if err := waitFlowSchemasReady(cli, objs, time.Now()); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
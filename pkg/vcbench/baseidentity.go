@@ -0,0 +1,112 @@
+package vcbench
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// TenantIdentityShared runs every tenant of the baseline benchmark with
+	// the identity of the kubeconfig. TenantIdentityToken and
+	// TenantIdentityImpersonate run each tenant as its own ServiceAccount,
+	// authenticated by the token of the ServiceAccount or by impersonation.
+	TenantIdentityShared      = "shared"
+	TenantIdentityToken       = "token"
+	TenantIdentityImpersonate = "impersonate"
+
+	tenantTokenTimeout = time.Minute
+)
+
+// tenantSAName is the name of the ServiceAccount, Role and RoleBinding of
+// the tenant
+func tenantSAName(tenantID string) string {
+	return "vcbench-" + tenantID
+}
+
+// setupTenantIdentity creates a ServiceAccount for the tenant in the
+// namespace, bound to a Role that manages pods in the namespace, and
+// returns the rest.Config that acts as the ServiceAccount
+func setupTenantIdentity(adminCli client.Client, adminCfg *rest.Config, mode, namespace, tenantID, runID string) (*rest.Config, error) {
+	name := tenantSAName(tenantID)
+	sa := &v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	role := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Rules: []rbacv1.PolicyRule{
+			{
+				APIGroups: []string{""},
+				Resources: []string{"pods"},
+				Verbs:     []string{"create", "get", "list", "watch", "delete"},
+			},
+		},
+	}
+	rb := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Subjects: []rbacv1.Subject{
+			{Kind: rbacv1.ServiceAccountKind, Name: name, Namespace: namespace},
+		},
+		RoleRef: rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: name},
+	}
+	for _, obj := range []runtime.Object{sa, role, rb} {
+		setRunLabels(obj.(metav1.Object), runID)
+		if err := adminCli.Create(context.TODO(), obj); err != nil && !apierrors.IsAlreadyExists(err) {
+			return nil, err
+		}
+	}
+	log.Printf("serviceaccount %s/%s is created for %s", namespace, name, tenantID)
+
+	switch mode {
+	case TenantIdentityImpersonate:
+		cfg := rest.CopyConfig(adminCfg)
+		cfg.Impersonate = rest.ImpersonationConfig{
+			UserName: fmt.Sprintf("system:serviceaccount:%s:%s", namespace, name),
+			Groups:   []string{"system:serviceaccounts", "system:serviceaccounts:" + namespace},
+		}
+		return cfg, nil
+	case TenantIdentityToken:
+		token, err := serviceAccountToken(adminCli, namespace, name)
+		if err != nil {
+			return nil, err
+		}
+		cfg := rest.AnonymousClientConfig(adminCfg)
+		cfg.BearerToken = token
+		return cfg, nil
+	default:
+		return nil, fmt.Errorf("unknown tenant identity %s", mode)
+	}
+}
+
+// serviceAccountToken waits for the token controller to populate the
+// token secret of the ServiceAccount and returns the token
+func serviceAccountToken(cli client.Client, namespace, name string) (string, error) {
+	deadline := time.Now().Add(tenantTokenTimeout)
+	for {
+		sa := &v1.ServiceAccount{}
+		if err := cli.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: name}, sa); err != nil {
+			return "", err
+		}
+		for _, ref := range sa.Secrets {
+			srt := &v1.Secret{}
+			if err := cli.Get(context.TODO(), types.NamespacedName{Namespace: namespace, Name: ref.Name}, srt); err != nil {
+				continue
+			}
+			if token := srt.Data[v1.ServiceAccountTokenKey]; srt.Type == v1.SecretTypeServiceAccountToken && len(token) != 0 {
+				return string(token), nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("token of serviceaccount %s/%s is not created in %s", namespace, name, tenantTokenTimeout)
+		}
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,119 @@
+package vcbench
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// baseStage is a stage of the startup of a pod on a plain cluster, which
+// starts at the timestamp returned by `from` and ends at the timestamp
+// returned by `to`
+type baseStage struct {
+	name string
+	from func(bs *BasePodStatiscs) int64
+	to   func(bs *BasePodStatiscs) int64
+}
+
+// baseStages lists the stages of the startup of a pod in order, i.e.,
+// create -> PodScheduled -> Initialized -> ContainersReady -> Ready ->
+// observed through the watch
+var baseStages = []baseStage{
+	{
+		name: "scheduling",
+		from: func(bs *BasePodStatiscs) int64 { return bs.CreationTimestamp },
+		to:   func(bs *BasePodStatiscs) int64 { return bs.ScheduledTimestamp },
+	},
+	{
+		name: "initializing",
+		from: func(bs *BasePodStatiscs) int64 { return bs.ScheduledTimestamp },
+		to:   func(bs *BasePodStatiscs) int64 { return bs.InitializedTimestamp },
+	},
+	{
+		name: "containersStarting",
+		from: func(bs *BasePodStatiscs) int64 { return bs.InitializedTimestamp },
+		to:   func(bs *BasePodStatiscs) int64 { return bs.ContainersReadyTimestamp },
+	},
+	{
+		name: "readiness",
+		from: func(bs *BasePodStatiscs) int64 { return bs.ContainersReadyTimestamp },
+		to:   func(bs *BasePodStatiscs) int64 { return bs.ReadyTimestamp },
+	},
+	{
+		name: "watchDelay",
+		from: func(bs *BasePodStatiscs) int64 { return bs.ReadyTimestamp },
+		to:   func(bs *BasePodStatiscs) int64 { return bs.ObservedReadyTimestamp },
+	},
+}
+
+// RecordBase records durations of the startup stages of the pod, and the
+// latency from creation to Ready as the total, in the global and tenant
+// scopes. Stages with a missing timestamp are skipped.
+func (sh *StageHistograms) RecordBase(bs *BasePodStatiscs) {
+	scopes := []string{ScopeAll}
+	if bs.TenantID != "" {
+		scopes = append(scopes, TenantScope(bs.TenantID))
+	}
+	sh.Lock()
+	defer sh.Unlock()
+	for _, stg := range baseStages {
+		from, to := stg.from(bs), stg.to(bs)
+		if from == 0 || to == 0 || to < from {
+			continue
+		}
+		for _, scope := range scopes {
+			sh.recordLocked(scope, stg.name, (to-from)*1000)
+		}
+	}
+	if bs.CreationTimestamp != 0 && bs.ReadyTimestamp >= bs.CreationTimestamp {
+		for _, scope := range scopes {
+			sh.recordLocked(scope, StageTotal, (bs.ReadyTimestamp-bs.CreationTimestamp)*1000)
+		}
+	}
+}
+
+// WriteBaseStatics writes the timestamps of every pod of the baseline
+// benchmark to logW and the durations of the stages to diffW, rows are
+// sorted by pod name. The first columns are kept as creationTimestamp and
+// readyTimestamp for the existing scripts.
+func WriteBaseStatics(logW, diffW io.Writer, rsMap map[string]*BasePodStatiscs) error {
+	if _, err := io.WriteString(logW, "#podName,creationTimestamp,readyTimestamp,podScheduled,initialized,containersReady,observedReady\n"); err != nil {
+		return err
+	}
+	diffHeader := []string{"podName"}
+	for _, stg := range baseStages {
+		diffHeader = append(diffHeader, stg.name)
+	}
+	diffHeader = append(diffHeader, "latency")
+	if _, err := fmt.Fprintf(diffW, "#%s\n", strings.Join(diffHeader, ",")); err != nil {
+		return err
+	}
+
+	var podNames []string
+	for pn := range rsMap {
+		podNames = append(podNames, pn)
+	}
+	sort.Strings(podNames)
+	for _, pn := range podNames {
+		bs := rsMap[pn]
+		if _, err := fmt.Fprintf(logW, "%s,%d,%d,%d,%d,%d,%d\n", pn,
+			bs.CreationTimestamp,
+			bs.ReadyTimestamp,
+			bs.ScheduledTimestamp,
+			bs.InitializedTimestamp,
+			bs.ContainersReadyTimestamp,
+			bs.ObservedReadyTimestamp); err != nil {
+			return err
+		}
+		row := []string{pn}
+		for _, stg := range baseStages {
+			row = append(row, fmt.Sprintf("%d", stg.to(bs)-stg.from(bs)))
+		}
+		row = append(row, fmt.Sprintf("%d", bs.ReadyTimestamp-bs.CreationTimestamp))
+		if _, err := fmt.Fprintf(diffW, "%s\n", strings.Join(row, ",")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
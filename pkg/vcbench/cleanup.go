@@ -0,0 +1,563 @@
+package vcbench
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/multi-tenancy/incubator/virtualcluster/pkg/syncer/conversion"
+)
+
+const (
+	// DefaultCleanUpWorkers and DefaultCleanUpTimeout are the defaults of
+	// CleanUpOptions
+	DefaultCleanUpWorkers = 10
+	DefaultCleanUpTimeout = 10 * time.Minute
+)
+
+// CleanUpOptions configures CleanUp
+type CleanUpOptions struct {
+	// Namespace is the benchmark namespace to be deleted on every vc
+	Namespace string
+	// Workers is the number of vc cleaned up concurrently
+	Workers int
+	// Timeout bounds the time waiting for the nodes and namespaces to be
+	// removed
+	Timeout time.Duration
+	// PollInterval is the interval of checking the termination
+	PollInterval time.Duration
+	// DeleteNodes deletes the virtual-kubelet nodes that only host
+	// benchmark pods before the pods
+	DeleteNodes bool
+}
+
+// CleanUpResult is the outcome of CleanUp
+type CleanUpResult struct {
+	// Duration is the time from the start of deletion to the termination of
+	// every namespace, or to the timeout
+	Duration time.Duration
+	// Leftovers lists the objects still existing after the timeout, e.g.
+	// "vc1: namespace podbench(Terminating)"
+	Leftovers []string
+	// Errors lists the failures of deleting objects
+	Errors []string
+}
+
+// CleanUp deletes the pods and the benchmark namespace on every vc, with at
+// most opts.Workers vc at a time, then waits until the namespaces are gone
+// on the vc and, if it is accessible, on the super cluster of the backend
+func (be *BenchExecutor) CleanUp(opts CleanUpOptions) *CleanUpResult {
+	opts.setDefaults()
+	superCli := be.superReader()
+	start := time.Now()
+	ret := &CleanUpResult{}
+	if opts.DeleteNodes {
+		ret.Leftovers = be.deleteVNodes(opts.Namespace, opts.Timeout, opts.PollInterval)
+	}
+
+	ret.Errors = forEachConcurrently(be.vcNames(), opts.Workers, func(vc string) error {
+		err := deleteNamespace(be.vcClients[vc], opts.Namespace, vc)
+		if err != nil {
+			log.Printf("fail to clean up vc(%s): %s", vc, err)
+		}
+		return err
+	})
+
+	nodeLeftovers := ret.Leftovers
+	ret.Leftovers = waitLeftovers(start.Add(opts.Timeout), opts.PollInterval, func() []string {
+		return be.cleanUpLeftovers(opts.Namespace, superCli)
+	})
+	ret.Leftovers = append(ret.Leftovers, nodeLeftovers...)
+	ret.Duration = time.Since(start)
+	sort.Strings(ret.Leftovers)
+	sort.Strings(ret.Errors)
+	return ret
+}
+
+// setDefaults fills the unset options with the defaults
+func (opts *CleanUpOptions) setDefaults() {
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultCleanUpWorkers
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultCleanUpTimeout
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 5 * time.Second
+	}
+}
+
+// superReader returns the client of the super cluster, which is used to
+// verify the syncer has removed the namespaces and pods mapped from the vc,
+// nil if the super cluster is not accessible
+func (be *BenchExecutor) superReader() client.Reader {
+	cli, _, err := be.backend.SuperCluster()
+	if err != nil {
+		log.Printf("will not verify the super cluster: %s", err)
+		return nil
+	}
+	return cli
+}
+
+// vcNames returns the names of the vc in order
+func (be *BenchExecutor) vcNames() []string {
+	var names []string
+	for vc := range be.vcClients {
+		names = append(names, vc)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// forEachConcurrently calls fn on every name with at most `workers` calls at
+// a time, and returns the failures in the form of "<name>: <error>"
+func forEachConcurrently(names []string, workers int, fn func(name string) error) []string {
+	var (
+		lock sync.Mutex
+		wg   sync.WaitGroup
+		errs []string
+	)
+	nameCh := make(chan string)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range nameCh {
+				if err := fn(name); err != nil {
+					lock.Lock()
+					errs = append(errs, fmt.Sprintf("%s: %s", name, err))
+					lock.Unlock()
+				}
+			}
+		}()
+	}
+	for _, name := range names {
+		nameCh <- name
+	}
+	close(nameCh)
+	wg.Wait()
+	return errs
+}
+
+// waitLeftovers polls the leftovers until there is none or the deadline
+// passes, and returns the last leftovers
+func waitLeftovers(deadline time.Time, pollInterval time.Duration, leftovers func() []string) []string {
+	for {
+		ret := leftovers()
+		if len(ret) == 0 || time.Now().After(deadline) {
+			return ret
+		}
+		log.Printf("waiting for %d objects to be removed", len(ret))
+		time.Sleep(pollInterval)
+	}
+}
+
+// deleteVNodes deletes the virtual-kubelet nodes on every vc that only
+// host benchmark pods, i.e. pods in the benchmark namespace or created by
+// vcbench, and waits for them to be removed until the timeout
+func (be *BenchExecutor) deleteVNodes(targetNs string, timeout, pollInterval time.Duration) []string {
+	start := time.Now()
+	nodesOnVc := make(map[string][]string)
+	for vc, vcCli := range be.vcClients {
+		nodes, err := benchOnlyVNodes(vcCli, targetNs)
+		if err != nil {
+			log.Printf("fail to find vk-nodes to be deleted on vc(%s): %s", vc, err)
+			continue
+		}
+		for _, n := range nodes {
+			log.Printf("will delete vk-node %s on vc %s", n, vc)
+			if err := vcCli.Delete(context.TODO(), &v1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: n},
+			}); err != nil && !apierrors.IsNotFound(err) {
+				log.Printf("fail to delete node %s on vc(%s): %s", n, vc, err)
+			}
+		}
+		nodesOnVc[vc] = nodes
+	}
+
+	var leftovers []string
+	for {
+		leftovers = nil
+		for vc, nodes := range nodesOnVc {
+			for _, n := range nodes {
+				err := be.vcClients[vc].Get(context.TODO(), types.NamespacedName{Name: n}, &v1.Node{})
+				if !apierrors.IsNotFound(err) {
+					leftovers = append(leftovers, fmt.Sprintf("%s: node %s", vc, n))
+				}
+			}
+		}
+		if len(leftovers) == 0 || time.Since(start) > timeout {
+			break
+		}
+		log.Printf("there are %d vk-nodes left", len(leftovers))
+		time.Sleep(pollInterval)
+	}
+	log.Printf("deleting vk-nodes took %d seconds", int(time.Since(start).Seconds()))
+	return leftovers
+}
+
+// benchOnlyVNodes returns the virtual-kubelet nodes that host benchmark
+// pods only
+func benchOnlyVNodes(cli client.Client, targetNs string) ([]string, error) {
+	selector, err := labels.Parse(virtualKubeletSelector)
+	if err != nil {
+		return nil, err
+	}
+	nl := &v1.NodeList{}
+	if err := cli.List(context.TODO(), nl, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	pl := &v1.PodList{}
+	if err := cli.List(context.TODO(), pl); err != nil {
+		return nil, err
+	}
+	benchPods := make(map[string]int)
+	otherPods := make(map[string]int)
+	for _, p := range pl.Items {
+		if p.Spec.NodeName == "" {
+			continue
+		}
+		if p.GetNamespace() == targetNs || p.GetLabels()[LabelManagedBy] == ManagedByVcbench {
+			benchPods[p.Spec.NodeName]++
+		} else {
+			otherPods[p.Spec.NodeName]++
+		}
+	}
+	var ret []string
+	for _, n := range nl.Items {
+		if benchPods[n.GetName()] > 0 && otherPods[n.GetName()] == 0 {
+			ret = append(ret, n.GetName())
+		}
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// DeletePods deletes all pods in the namespace
+func DeletePods(cli client.Client, targetNs, vc string) error {
+	var podLst v1.PodList
+	if err := cli.List(context.TODO(), &podLst, client.InNamespace(targetNs)); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("fail to list pod in ns/%s for %s: %s", targetNs, vc, err)
+	}
+	for i := range podLst.Items {
+		pod := &podLst.Items[i]
+		if err := cli.Delete(context.TODO(), pod); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("fail to delete pod/%s in ns/%s for %s: %s",
+				pod.GetName(), targetNs, vc, err)
+		}
+	}
+	log.Printf("delete %d pods in ns/%s for %s", len(podLst.Items), targetNs, vc)
+	return nil
+}
+
+// deleteNamespace deletes the pods in the namespace before the namespace
+func deleteNamespace(cli client.Client, targetNs, vc string) error {
+	if err := DeletePods(cli, targetNs, vc); err != nil {
+		return err
+	}
+	log.Printf("will delete namespace %s on vc %s", targetNs, vc)
+	if err := cli.Delete(context.TODO(), &v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: targetNs,
+		},
+	}); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("fail to delete namespace %s: %s", targetNs, err)
+	}
+	return nil
+}
+
+// cleanUpLeftovers lists the benchmark namespaces that still exist on the
+// vc, and the mapped namespaces and pods on the super cluster
+func (be *BenchExecutor) cleanUpLeftovers(targetNs string, superCli client.Reader) []string {
+	var leftovers []string
+	for vc := range be.vcClients {
+		leftovers = append(leftovers, be.namespaceLeftovers(vc, []string{targetNs}, superCli)...)
+	}
+	return leftovers
+}
+
+// namespaceLeftovers lists the namespaces that still exist on the vc, and
+// the mapped namespaces and pods on the super cluster
+func (be *BenchExecutor) namespaceLeftovers(vc string, namespaces []string, superCli client.Reader) []string {
+	var leftovers []string
+	for _, ns := range namespaces {
+		if desc, exist := namespaceLeftover(be.vcClients[vc], ns); exist {
+			leftovers = append(leftovers, fmt.Sprintf("%s: %s", vc, desc))
+		}
+		if superCli == nil {
+			continue
+		}
+		superNs := conversion.ToSuperMasterNamespace(be.vcClusterKeys[vc], ns)
+		if desc, exist := namespaceLeftover(superCli, superNs); exist {
+			leftovers = append(leftovers, fmt.Sprintf("%s: %s", ClusterSuper, desc))
+		}
+		pl := &v1.PodList{}
+		if err := superCli.List(context.TODO(), pl, client.InNamespace(superNs)); err != nil {
+			log.Printf("fail to list pods in ns(%s) of the super cluster: %s", superNs, err)
+			continue
+		}
+		for _, p := range pl.Items {
+			leftovers = append(leftovers, fmt.Sprintf("%s: pod %s/%s", ClusterSuper, superNs, p.GetName()))
+		}
+	}
+	return leftovers
+}
+
+// namespaceLeftover describes the namespace if it still exists, errors
+// other than NotFound are considered as existing
+func namespaceLeftover(cli client.Reader, name string) (string, bool) {
+	ns := &v1.Namespace{}
+	if err := cli.Get(context.TODO(), types.NamespacedName{Name: name}, ns); err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", false
+		}
+		return fmt.Sprintf("namespace %s(%s)", name, err), true
+	}
+	return fmt.Sprintf("namespace %s(%s)", name, ns.Status.Phase), true
+}
+
+// CleanUpRun deletes the pods and namespaces created by the run, or by any
+// run if runID is empty, on every vc, with at most opts.Workers vc at a
+// time, then waits until the deleted namespaces are gone on the vc and, if
+// it is accessible, on the super cluster of the backend. A labeled
+// namespace is kept if it still contains pods that are not selected. If
+// dryRun is set, the objects are only listed. opts.Namespace and
+// opts.DeleteNodes are ignored.
+func (be *BenchExecutor) CleanUpRun(runID string, dryRun bool, opts CleanUpOptions) *CleanUpResult {
+	opts.setDefaults()
+	selector := RunSelector(runID)
+	start := time.Now()
+	ret := &CleanUpResult{}
+	var lock sync.Mutex
+	deleted := make(map[string][]string)
+	ret.Errors = forEachConcurrently(be.vcNames(), opts.Workers, func(vc string) error {
+		namespaces, err := cleanUpSelected(vc, be.vcClients[vc], selector, dryRun)
+		if err != nil {
+			log.Printf("fail to clean up vc(%s): %s", vc, err)
+		}
+		lock.Lock()
+		deleted[vc] = namespaces
+		lock.Unlock()
+		return err
+	})
+	if dryRun {
+		ret.Duration = time.Since(start)
+		return ret
+	}
+
+	superCli := be.superReader()
+	ret.Leftovers = waitLeftovers(start.Add(opts.Timeout), opts.PollInterval, func() []string {
+		var leftovers []string
+		for vc, namespaces := range deleted {
+			leftovers = append(leftovers, selectedPodLeftovers(vc, be.vcClients[vc], "", selector)...)
+			leftovers = append(leftovers, be.namespaceLeftovers(vc, namespaces, superCli)...)
+		}
+		return leftovers
+	})
+	ret.Duration = time.Since(start)
+	sort.Strings(ret.Leftovers)
+	sort.Strings(ret.Errors)
+	return ret
+}
+
+// selectedPodLeftovers lists the selected pods that still exist in the
+// namespace, or in all namespaces if it is empty, of the cluster
+func selectedPodLeftovers(cluster string, cli client.Reader, namespace string, selector labels.Selector) []string {
+	pl := &v1.PodList{}
+	if err := cli.List(context.TODO(), pl, client.InNamespace(namespace),
+		client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return []string{fmt.Sprintf("%s: pods(%s)", cluster, err)}
+	}
+	var leftovers []string
+	for _, p := range pl.Items {
+		leftovers = append(leftovers, fmt.Sprintf("%s: pod %s/%s", cluster, p.GetNamespace(), p.GetName()))
+	}
+	return leftovers
+}
+
+// cleanUpSelected deletes the selected pods and namespaces on the cluster,
+// and returns the namespaces being deleted
+func cleanUpSelected(cluster string, cli client.Client, selector labels.Selector, dryRun bool) ([]string, error) {
+	pl := &v1.PodList{}
+	if err := cli.List(context.TODO(), pl, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	for i := range pl.Items {
+		pod := &pl.Items[i]
+		if dryRun {
+			log.Printf("[DRY-RUN] would delete pod %s/%s on %s", pod.GetNamespace(), pod.GetName(), cluster)
+			continue
+		}
+		if err := cli.Delete(context.TODO(), pod); err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("fail to delete pod %s/%s: %s", pod.GetNamespace(), pod.GetName(), err)
+		}
+	}
+	if !dryRun {
+		log.Printf("%d pods are deleted on %s", len(pl.Items), cluster)
+	}
+
+	nsl := &v1.NamespaceList{}
+	if err := cli.List(context.TODO(), nsl, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	var deleted []string
+	for i := range nsl.Items {
+		ns := &nsl.Items[i]
+		all := &v1.PodList{}
+		if err := cli.List(context.TODO(), all, client.InNamespace(ns.GetName())); err != nil {
+			return deleted, err
+		}
+		var others int
+		for _, p := range all.Items {
+			if !selector.Matches(labels.Set(p.GetLabels())) {
+				others++
+			}
+		}
+		if others > 0 {
+			log.Printf("namespace %s on %s is kept, it contains %d pods of other runs or users",
+				ns.GetName(), cluster, others)
+			continue
+		}
+		if dryRun {
+			log.Printf("[DRY-RUN] would delete namespace %s on %s", ns.GetName(), cluster)
+			continue
+		}
+		if err := cli.Delete(context.TODO(), ns); err != nil && !apierrors.IsNotFound(err) {
+			return deleted, fmt.Errorf("fail to delete namespace %s: %s", ns.GetName(), err)
+		}
+		deleted = append(deleted, ns.GetName())
+		log.Printf("namespace %s is deleted on %s", ns.GetName(), cluster)
+	}
+	return deleted, nil
+}
+
+// baseNamespaceRe matches the namespaces created by the baseline
+// benchmark, i.e. podbench or podbench-<i>
+var baseNamespaceRe = regexp.MustCompile("^" + DefaultBenchNamespace + `(-\d+)?$`)
+
+// CleanUpBase deletes the namespaces created by the baseline benchmark, i.e.
+// the ones named by baseNamespaceRe and labeled as managed by vcbench, with
+// at most opts.Workers namespaces at a time, then waits until they are gone.
+// If runID is empty, the namespaces and all pods in them are deleted along
+// with the API Priority and Fairness objects left by any run. Otherwise,
+// only the pods and APF objects of the run are deleted, and a namespace of
+// the run is kept if it still contains other pods. opts.Namespace and
+// opts.DeleteNodes are ignored.
+func CleanUpBase(cli client.Client, runID string, opts CleanUpOptions) (*CleanUpResult, error) {
+	opts.setDefaults()
+	start := time.Now()
+	nsl := &v1.NamespaceList{}
+	if err := cli.List(context.TODO(), nsl,
+		client.MatchingLabels{LabelManagedBy: ManagedByVcbench}); err != nil {
+		return nil, err
+	}
+	namespaces := make(map[string]*v1.Namespace)
+	var names []string
+	for i := range nsl.Items {
+		ns := &nsl.Items[i]
+		if baseNamespaceRe.MatchString(ns.GetName()) {
+			namespaces[ns.GetName()] = ns
+			names = append(names, ns.GetName())
+		}
+	}
+	log.Printf("will clean up %d benchmark namespaces", len(names))
+
+	var (
+		lock    sync.Mutex
+		deleted []string
+	)
+	ret := &CleanUpResult{}
+	ret.Errors = forEachConcurrently(names, opts.Workers, func(ns string) error {
+		gone, err := cleanUpBaseNamespace(cli, namespaces[ns], runID)
+		if gone {
+			lock.Lock()
+			deleted = append(deleted, ns)
+			lock.Unlock()
+		}
+		return err
+	})
+	ret.Errors = append(ret.Errors, deleteAPFObjects(cli, runID)...)
+
+	selector := RunSelector(runID)
+	ret.Leftovers = waitLeftovers(start.Add(opts.Timeout), opts.PollInterval, func() []string {
+		var leftovers []string
+		for _, ns := range names {
+			leftovers = append(leftovers, selectedPodLeftovers("base", cli, ns, selector)...)
+		}
+		for _, ns := range deleted {
+			if desc, exist := namespaceLeftover(cli, ns); exist {
+				leftovers = append(leftovers, fmt.Sprintf("base: %s", desc))
+			}
+		}
+		return leftovers
+	})
+	ret.Duration = time.Since(start)
+	sort.Strings(ret.Leftovers)
+	sort.Strings(ret.Errors)
+	return ret, nil
+}
+
+// cleanUpBaseNamespace deletes the namespace of the baseline benchmark and
+// all pods in it. If runID is set, only the pods of the run are deleted, and
+// the namespace is deleted only if it is labeled with the run and contains
+// no other pods. It returns whether the namespace is being deleted.
+func cleanUpBaseNamespace(cli client.Client, ns *v1.Namespace, runID string) (bool, error) {
+	if runID == "" {
+		return true, deleteNamespace(cli, ns.GetName(), "base")
+	}
+	selector := RunSelector(runID)
+	pl := &v1.PodList{}
+	if err := cli.List(context.TODO(), pl, client.InNamespace(ns.GetName())); err != nil {
+		return false, err
+	}
+	var others int
+	for i := range pl.Items {
+		pod := &pl.Items[i]
+		if !selector.Matches(labels.Set(pod.GetLabels())) {
+			others++
+			continue
+		}
+		if err := cli.Delete(context.TODO(), pod); err != nil && !apierrors.IsNotFound(err) {
+			return false, fmt.Errorf("fail to delete pod %s/%s: %s", pod.GetNamespace(), pod.GetName(), err)
+		}
+	}
+	if !selector.Matches(labels.Set(ns.GetLabels())) {
+		return false, nil
+	}
+	if others > 0 {
+		log.Printf("namespace %s is kept, it contains %d pods of other runs or users", ns.GetName(), others)
+		return false, nil
+	}
+	if err := cli.Delete(context.TODO(), ns); err != nil && !apierrors.IsNotFound(err) {
+		return false, fmt.Errorf("fail to delete namespace %s: %s", ns.GetName(), err)
+	}
+	log.Printf("namespace %s is deleted", ns.GetName())
+	return true, nil
+}
+
+// waitNamespacesGone waits until the namespaces are removed or the deadline
+// passes, and returns the namespaces that still exist
+func waitNamespacesGone(cli client.Reader, namespaces []string, deadline time.Time, pollInterval time.Duration) []string {
+	return waitLeftovers(deadline, pollInterval, func() []string {
+		var leftovers []string
+		for _, ns := range namespaces {
+			if desc, exist := namespaceLeftover(cli, ns); exist {
+				leftovers = append(leftovers, desc)
+			}
+		}
+		return leftovers
+	})
+}
@@ -0,0 +1,254 @@
+package vcbench
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func labeledMeta(name, namespace, runID string) metav1.ObjectMeta {
+	om := metav1.ObjectMeta{Name: name, Namespace: namespace}
+	if runID != "" {
+		setRunLabels(&om, runID)
+	}
+	return om
+}
+
+func TestCleanUpSelected(t *testing.T) {
+	newCli := func() client.Client {
+		return fake.NewFakeClientWithScheme(scheme.Scheme,
+			&v1.Namespace{ObjectMeta: labeledMeta("ns-a", "", "a")},
+			&v1.Namespace{ObjectMeta: labeledMeta("ns-shared", "", "a")},
+			&v1.Namespace{ObjectMeta: labeledMeta("ns-b", "", "b")},
+			&v1.Pod{ObjectMeta: labeledMeta("pod-a0", "ns-a", "a")},
+			&v1.Pod{ObjectMeta: labeledMeta("pod-a1", "ns-shared", "a")},
+			&v1.Pod{ObjectMeta: labeledMeta("pod-b0", "ns-shared", "b")},
+			&v1.Pod{ObjectMeta: labeledMeta("pod-other", "ns-shared", "")},
+			&v1.Pod{ObjectMeta: labeledMeta("pod-b1", "ns-b", "b")},
+		)
+	}
+	remaining := func(cli client.Client) map[string]bool {
+		ret := make(map[string]bool)
+		pl := &v1.PodList{}
+		if err := cli.List(context.TODO(), pl); err != nil {
+			t.Fatalf("fail to list pods: %s", err)
+		}
+		for _, p := range pl.Items {
+			ret["pod/"+p.GetName()] = true
+		}
+		nsl := &v1.NamespaceList{}
+		if err := cli.List(context.TODO(), nsl); err != nil {
+			t.Fatalf("fail to list namespaces: %s", err)
+		}
+		for _, ns := range nsl.Items {
+			ret["ns/"+ns.GetName()] = true
+		}
+		return ret
+	}
+
+	for _, c := range []struct {
+		name    string
+		runID   string
+		dryRun  bool
+		deleted []string
+	}{
+		{name: "dry run", runID: "a", dryRun: true},
+		{name: "run a", runID: "a", deleted: []string{"pod/pod-a0", "pod/pod-a1", "ns/ns-a"}},
+		{name: "all runs", deleted: []string{"pod/pod-a0", "pod/pod-a1", "pod/pod-b0", "pod/pod-b1", "ns/ns-a", "ns/ns-b"}},
+	} {
+		cli := newCli()
+		before := remaining(cli)
+		if _, err := cleanUpSelected("vc", cli, RunSelector(c.runID), c.dryRun); err != nil {
+			t.Fatalf("%s: fail to clean up: %s", c.name, err)
+		}
+		after := remaining(cli)
+		if len(before)-len(after) != len(c.deleted) {
+			t.Errorf("%s: expect %d objects deleted, remaining %v", c.name, len(c.deleted), after)
+		}
+		for _, d := range c.deleted {
+			if after[d] {
+				t.Errorf("%s: %s is not deleted", c.name, d)
+			}
+		}
+	}
+}
+
+func TestCleanUpReportsLeftovers(t *testing.T) {
+	be := &BenchExecutor{
+		vcClients: map[string]client.Client{
+			"vc1": fake.NewFakeClientWithScheme(scheme.Scheme,
+				&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: DefaultBenchNamespace}},
+				&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod0", Namespace: DefaultBenchNamespace}},
+			),
+			"vc2": fake.NewFakeClientWithScheme(scheme.Scheme),
+		},
+		vcClusterKeys: map[string]string{"vc1": "key1", "vc2": "key2"},
+		// the syncer hasn't removed the namespace mapped from vc2
+		backend: &FakeBackend{Super: fake.NewFakeClientWithScheme(scheme.Scheme,
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "key2-" + DefaultBenchNamespace}},
+			&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod0", Namespace: "key2-" + DefaultBenchNamespace}},
+		)},
+	}
+	result := be.CleanUp(CleanUpOptions{
+		Namespace:    DefaultBenchNamespace,
+		Workers:      1,
+		Timeout:      10 * time.Millisecond,
+		PollInterval: time.Millisecond,
+	})
+	if len(result.Errors) != 0 {
+		t.Fatalf("unexpected errors: %v", result.Errors)
+	}
+	want := []string{
+		"super: namespace key2-podbench()",
+		"super: pod key2-podbench/pod0",
+	}
+	if len(result.Leftovers) != len(want) {
+		t.Fatalf("expect leftovers %v, got %v", want, result.Leftovers)
+	}
+	for i := range want {
+		if result.Leftovers[i] != want[i] {
+			t.Errorf("expect leftovers %v, got %v", want, result.Leftovers)
+		}
+	}
+	if result.Duration < 10*time.Millisecond {
+		t.Errorf("expect to wait until the timeout, took %s", result.Duration)
+	}
+}
+
+func TestCleanUpRunReportsLeftovers(t *testing.T) {
+	be := &BenchExecutor{
+		vcClients: map[string]client.Client{
+			"vc1": fake.NewFakeClientWithScheme(scheme.Scheme,
+				&v1.Namespace{ObjectMeta: labeledMeta("ns-a", "", "a")},
+				&v1.Pod{ObjectMeta: labeledMeta("pod-a0", "ns-a", "a")},
+			),
+			"vc2": fake.NewFakeClientWithScheme(scheme.Scheme,
+				&v1.Namespace{ObjectMeta: labeledMeta("ns-a", "", "a")},
+				&v1.Namespace{ObjectMeta: labeledMeta("ns-b", "", "b")},
+			),
+		},
+		vcClusterKeys: map[string]string{"vc1": "key1", "vc2": "key2"},
+		// the syncer hasn't removed the namespace mapped from vc2
+		backend: &FakeBackend{Super: fake.NewFakeClientWithScheme(scheme.Scheme,
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "key2-ns-a"}},
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "key2-ns-b"}},
+		)},
+	}
+	result := be.CleanUpRun("a", false, CleanUpOptions{
+		Workers:      2,
+		Timeout:      10 * time.Millisecond,
+		PollInterval: time.Millisecond,
+	})
+	if len(result.Errors) != 0 {
+		t.Fatalf("unexpected errors: %v", result.Errors)
+	}
+	if len(result.Leftovers) != 1 || result.Leftovers[0] != "super: namespace key2-ns-a()" {
+		t.Fatalf("expect the namespace of run a on the super cluster left, got %v", result.Leftovers)
+	}
+	if err := be.vcClients["vc2"].Get(context.TODO(), client.ObjectKey{Name: "ns-b"}, &v1.Namespace{}); err != nil {
+		t.Errorf("expect the namespace of run b to be kept: %s", err)
+	}
+}
+
+func TestBenchOnlyVNodes(t *testing.T) {
+	vkLabels := map[string]string{"type": "virtual-kubelet"}
+	cli := fake.NewFakeClientWithScheme(scheme.Scheme,
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "vk-bench", Labels: vkLabels}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "vk-shared", Labels: vkLabels}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "vk-idle", Labels: vkLabels}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "real-node"}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p0", Namespace: DefaultBenchNamespace},
+			Spec: v1.PodSpec{NodeName: "vk-bench"}},
+		&v1.Pod{ObjectMeta: labeledMeta("p1", "other", "a"),
+			Spec: v1.PodSpec{NodeName: "vk-bench"}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p2", Namespace: DefaultBenchNamespace},
+			Spec: v1.PodSpec{NodeName: "vk-shared"}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p3", Namespace: "default"},
+			Spec: v1.PodSpec{NodeName: "vk-shared"}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "p4", Namespace: DefaultBenchNamespace},
+			Spec: v1.PodSpec{NodeName: "real-node"}},
+	)
+	nodes, err := benchOnlyVNodes(cli, DefaultBenchNamespace)
+	if err != nil {
+		t.Fatalf("fail to find nodes: %s", err)
+	}
+	if len(nodes) != 1 || nodes[0] != "vk-bench" {
+		t.Fatalf("expect [vk-bench], got %v", nodes)
+	}
+}
+
+func TestCleanUpBase(t *testing.T) {
+	newCli := func() client.Client {
+		return fake.NewFakeClientWithScheme(scheme.Scheme,
+			&v1.Namespace{ObjectMeta: labeledMeta("podbench", "", "a")},
+			&v1.Namespace{ObjectMeta: labeledMeta("podbench-0", "", "a")},
+			&v1.Namespace{ObjectMeta: labeledMeta("podbench-12", "", "b")},
+			&v1.Namespace{ObjectMeta: labeledMeta("podbench-x", "", "a")},
+			// not created by vcbench
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "podbench-1"}},
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+			&v1.Pod{ObjectMeta: labeledMeta("pod0", "podbench-0", "a")},
+			&v1.Pod{ObjectMeta: labeledMeta("pod1", "podbench-0", "b")},
+			&v1.Pod{ObjectMeta: labeledMeta("pod2", "podbench-12", "b")},
+			&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod3", Namespace: "podbench-1"}},
+			&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod4", Namespace: "default"}},
+		)
+	}
+	remaining := func(cli client.Client) []string {
+		var ret []string
+		nsl := &v1.NamespaceList{}
+		if err := cli.List(context.TODO(), nsl); err != nil {
+			t.Fatalf("fail to list namespaces: %s", err)
+		}
+		for _, ns := range nsl.Items {
+			ret = append(ret, "ns/"+ns.GetName())
+		}
+		pl := &v1.PodList{}
+		if err := cli.List(context.TODO(), pl); err != nil {
+			t.Fatalf("fail to list pods: %s", err)
+		}
+		for _, p := range pl.Items {
+			ret = append(ret, "pod/"+p.GetNamespace()+"/"+p.GetName())
+		}
+		sort.Strings(ret)
+		return ret
+	}
+
+	for _, c := range []struct {
+		name   string
+		runID  string
+		expect []string
+	}{
+		{
+			name:   "all runs",
+			expect: []string{"ns/default", "ns/podbench-1", "ns/podbench-x", "pod/default/pod4", "pod/podbench-1/pod3"},
+		},
+		{
+			// podbench-0 is kept for the pod of run b
+			name:  "run a",
+			runID: "a",
+			expect: []string{"ns/default", "ns/podbench-0", "ns/podbench-1", "ns/podbench-12", "ns/podbench-x",
+				"pod/default/pod4", "pod/podbench-0/pod1", "pod/podbench-1/pod3", "pod/podbench-12/pod2"},
+		},
+	} {
+		cli := newCli()
+		result, err := CleanUpBase(cli, c.runID, CleanUpOptions{Workers: 2, PollInterval: time.Millisecond})
+		if err != nil {
+			t.Fatalf("%s: fail to clean up: %s", c.name, err)
+		}
+		if len(result.Errors) != 0 || len(result.Leftovers) != 0 {
+			t.Fatalf("%s: unexpected result: %+v", c.name, result)
+		}
+		if got := remaining(cli); !reflect.DeepEqual(got, c.expect) {
+			t.Errorf("%s: expect %v to be kept, got %v", c.name, c.expect, got)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package vcbench
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// DiscoverPod resolves a target into pods, selected by name or label
+	DiscoverPod = "pod"
+	// DiscoverNode resolves a target into nodes selected by label
+	DiscoverNode = "node"
+	// DiscoverVirtualKubelet resolves a target into every virtual-kubelet
+	// node, i.e., nodes with label "type: virtual-kubelet"
+	DiscoverVirtualKubelet = "virtual-kubelet"
+
+	virtualKubeletSelector = "type=virtual-kubelet"
+)
+
+// TargetDiscovery locates scrape targets through the Kubernetes API. The
+// discovered pods and nodes are scraped through the pods/proxy and
+// nodes/proxy subresources of the apiserver, so they don't need to expose
+// an address to the benchmark.
+type TargetDiscovery struct {
+	// Kind is one of "pod", "node" or "virtual-kubelet"
+	Kind string `yaml:"kind"`
+	// Namespace of the pods
+	Namespace string `yaml:"namespace"`
+	// PodName selects a single pod, e.g. "vc-syncer-0"
+	PodName string `yaml:"podName"`
+	// LabelSelector selects pods or nodes, e.g. "app=vc-syncer"
+	LabelSelector string `yaml:"labelSelector"`
+	// Port is the port of the metrics endpoint, by default the apiserver
+	// chooses the port
+	Port string `yaml:"port"`
+	// Path is the path of the metrics endpoint, default to /metrics
+	Path string `yaml:"path"`
+}
+
+// discoverTargets replaces targets with discovery by the pods or nodes
+// they select. The apiserver is accessed with the credentials of the
+// target (i.e. Kubeconfig or RestConfig).
+func discoverTargets(targets []ScrapeTarget) ([]ScrapeTarget, error) {
+	var ret []ScrapeTarget
+	for _, t := range targets {
+		if t.Discovery == nil {
+			ret = append(ret, t)
+			continue
+		}
+		if t.RestConfig == nil {
+			return nil, fmt.Errorf("scrape target %s: discovery requires kubeconfig", t.Name)
+		}
+		cli, err := client.New(t.RestConfig, client.Options{Scheme: scheme.Scheme})
+		if err != nil {
+			return nil, err
+		}
+		proxyPaths, err := t.Discovery.discover(cli)
+		if err != nil {
+			return nil, fmt.Errorf("scrape target %s: %s", t.Name, err)
+		}
+		if len(proxyPaths) == 0 {
+			return nil, fmt.Errorf("scrape target %s: nothing is discovered", t.Name)
+		}
+		for name, proxyPath := range proxyPaths {
+			dt := t
+			dt.Discovery = nil
+			dt.Name = t.Name + "-" + name
+			dt.Addr = proxyPath
+			if t.OutFile != "" {
+				dt.OutFile = name + "." + t.OutFile
+			}
+			log.Printf("discovered scrape target %s(%s)", dt.Name, dt.Addr)
+			ret = append(ret, dt)
+		}
+	}
+	return ret, nil
+}
+
+// discover returns the apiserver proxy path of the metrics endpoint of
+// every selected pod or node, keyed by the name of the pod or node
+func (d *TargetDiscovery) discover(cli client.Reader) (map[string]string, error) {
+	metricsPath := d.Path
+	if metricsPath == "" {
+		metricsPath = defaultApiserverMetricsPath
+	}
+	if !strings.HasPrefix(metricsPath, "/") {
+		metricsPath = "/" + metricsPath
+	}
+	withPort := func(name string) string {
+		if d.Port == "" {
+			return name
+		}
+		return name + ":" + d.Port
+	}
+
+	ret := make(map[string]string)
+	switch d.Kind {
+	case DiscoverPod:
+		if d.Namespace == "" {
+			return nil, fmt.Errorf("namespace is required to discover pods")
+		}
+		var podNames []string
+		if d.PodName != "" {
+			pod := &v1.Pod{}
+			if err := cli.Get(context.TODO(), client.ObjectKey{Namespace: d.Namespace, Name: d.PodName}, pod); err != nil {
+				return nil, err
+			}
+			podNames = append(podNames, pod.GetName())
+		} else {
+			selector, err := labels.Parse(d.LabelSelector)
+			if err != nil {
+				return nil, err
+			}
+			pl := &v1.PodList{}
+			if err := cli.List(context.TODO(), pl, client.InNamespace(d.Namespace),
+				client.MatchingLabelsSelector{Selector: selector}); err != nil {
+				return nil, err
+			}
+			for _, p := range pl.Items {
+				if p.Status.Phase == v1.PodRunning {
+					podNames = append(podNames, p.GetName())
+				}
+			}
+		}
+		for _, pn := range podNames {
+			ret[pn] = fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/proxy%s", d.Namespace, withPort(pn), metricsPath)
+		}
+	case DiscoverNode, DiscoverVirtualKubelet:
+		labelSelector := d.LabelSelector
+		if d.Kind == DiscoverVirtualKubelet {
+			labelSelector = virtualKubeletSelector
+		}
+		selector, err := labels.Parse(labelSelector)
+		if err != nil {
+			return nil, err
+		}
+		nl := &v1.NodeList{}
+		if err := cli.List(context.TODO(), nl, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+			return nil, err
+		}
+		for _, n := range nl.Items {
+			ret[n.GetName()] = fmt.Sprintf("/api/v1/nodes/%s/proxy%s", withPort(n.GetName()), metricsPath)
+		}
+	default:
+		return nil, fmt.Errorf("unknown discovery kind %s", d.Kind)
+	}
+	return ret, nil
+}
@@ -0,0 +1,63 @@
+package vcbench
+
+import (
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestDiscover(t *testing.T) {
+	cli := fake.NewFakeClientWithScheme(scheme.Scheme,
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "vc-syncer-0", Namespace: "vc-manager",
+				Labels: map[string]string{"app": "vc-syncer"}},
+			Status: v1.PodStatus{Phase: v1.PodRunning},
+		},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "vkubelet-mock-0",
+			Labels: map[string]string{"type": "virtual-kubelet"}}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "vkubelet-mock-1",
+			Labels: map[string]string{"type": "virtual-kubelet"}}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "master"}},
+	)
+
+	for _, c := range []struct {
+		discovery TargetDiscovery
+		want      map[string]string
+	}{
+		{
+			discovery: TargetDiscovery{Kind: DiscoverPod, Namespace: "vc-manager", PodName: "vc-syncer-0", Port: "8080"},
+			want: map[string]string{
+				"vc-syncer-0": "/api/v1/namespaces/vc-manager/pods/vc-syncer-0:8080/proxy/metrics",
+			},
+		},
+		{
+			discovery: TargetDiscovery{Kind: DiscoverPod, Namespace: "vc-manager", LabelSelector: "app=vc-syncer"},
+			want: map[string]string{
+				"vc-syncer-0": "/api/v1/namespaces/vc-manager/pods/vc-syncer-0/proxy/metrics",
+			},
+		},
+		{
+			discovery: TargetDiscovery{Kind: DiscoverVirtualKubelet, Path: "stats/summary"},
+			want: map[string]string{
+				"vkubelet-mock-0": "/api/v1/nodes/vkubelet-mock-0/proxy/stats/summary",
+				"vkubelet-mock-1": "/api/v1/nodes/vkubelet-mock-1/proxy/stats/summary",
+			},
+		},
+	} {
+		get, err := c.discovery.discover(cli)
+		if err != nil {
+			t.Fatalf("fail to discover %+v: %s", c.discovery, err)
+		}
+		if len(get) != len(c.want) {
+			t.Fatalf("%+v: want %v, get %v", c.discovery, c.want, get)
+		}
+		for name, p := range c.want {
+			if get[name] != p {
+				t.Fatalf("%+v: want %v, get %v", c.discovery, c.want, get)
+			}
+		}
+	}
+}
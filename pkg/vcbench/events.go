@@ -0,0 +1,446 @@
+package vcbench
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ClusterSuper is the cluster name of events collected from the super
+	// cluster
+	ClusterSuper = "super"
+
+	defaultEventPollInterval = 5 * time.Second
+)
+
+// EventRecord is an Event observed during a run, stored as a line of
+// <outDataDir>.events.jsonl
+type EventRecord struct {
+	// Cluster is the name of the vc, or "super"
+	Cluster   string `json:"cluster"`
+	Namespace string `json:"namespace"`
+	// Kind and Name of the involved object
+	Kind    string `json:"kind"`
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+	Count   int32  `json:"count"`
+	// FirstTimestamp, LastTimestamp and ObservedAt are unix time in seconds
+	FirstTimestamp int64 `json:"firstTimestamp"`
+	LastTimestamp  int64 `json:"lastTimestamp"`
+	ObservedAt     int64 `json:"observedAt"`
+}
+
+type eventSource struct {
+	cluster   string
+	namespace string
+	lw        cache.ListerWatcher
+}
+
+// EventCollector watches the Events of namespaces on multiple clusters and
+// appends every new or updated Event to a JSON Lines file, stamped with the
+// time it is observed
+type EventCollector struct {
+	OutPath string
+	// Interval is the interval of retrying a failed list or watch, and of
+	// polling the sources not served by an apiserver
+	Interval time.Duration
+
+	sources []eventSource
+	sync.Mutex
+	// seen maps the uid of an event to its latest observed resourceVersion
+	seen map[string]string
+	w    io.WriteCloser
+	enc  *json.Encoder
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewEventCollector creates a collector writing to outPath
+func NewEventCollector(outPath string) *EventCollector {
+	return &EventCollector{
+		OutPath:  outPath,
+		Interval: defaultEventPollInterval,
+		seen:     make(map[string]string),
+	}
+}
+
+// AddSource adds a namespace of a cluster whose events will be collected.
+// The events are watched through the apiserver at cfg, or polled with cli
+// if cfg is nil, i.e., the cluster is not served by an apiserver.
+func (ec *EventCollector) AddSource(cluster, namespace string, cli client.Reader, cfg *rest.Config) error {
+	if cfg == nil {
+		ec.addListWatchSource(cluster, namespace, ec.pollingEventListWatch(cli, namespace))
+		return nil
+	}
+	cs, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	ec.addListWatchSource(cluster, namespace, eventListWatch(cs, namespace))
+	return nil
+}
+
+func (ec *EventCollector) addListWatchSource(cluster, namespace string, lw cache.ListerWatcher) {
+	ec.sources = append(ec.sources, eventSource{cluster: cluster, namespace: namespace, lw: lw})
+}
+
+func eventListWatch(cs kubernetes.Interface, namespace string) cache.ListerWatcher {
+	return &cache.ListWatch{
+		ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+			return cs.CoreV1().Events(namespace).List(context.TODO(), opts)
+		},
+		WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+			return cs.CoreV1().Events(namespace).Watch(context.TODO(), opts)
+		},
+	}
+}
+
+// pollingEventListWatch watches the events by listing them at every
+// Interval, the resourceVersion is ignored
+func (ec *EventCollector) pollingEventListWatch(cli client.Reader, namespace string) cache.ListerWatcher {
+	list := func() (*v1.EventList, error) {
+		el := &v1.EventList{}
+		return el, cli.List(context.TODO(), el, client.InNamespace(namespace))
+	}
+	return &cache.ListWatch{
+		ListFunc: func(metav1.ListOptions) (runtime.Object, error) {
+			return list()
+		},
+		WatchFunc: func(metav1.ListOptions) (watch.Interface, error) {
+			pw := &pollingWatcher{
+				result: make(chan watch.Event),
+				stopCh: make(chan struct{}),
+			}
+			go pw.run(func() ([]runtime.Object, error) {
+				el, err := list()
+				if err != nil {
+					return nil, err
+				}
+				var objs []runtime.Object
+				for i := range el.Items {
+					objs = append(objs, &el.Items[i])
+				}
+				return objs, nil
+			}, ec.Interval)
+			return pw, nil
+		},
+	}
+}
+
+// Start starts watching events
+func (ec *EventCollector) Start() error {
+	f, err := os.Create(ec.OutPath)
+	if err != nil {
+		return err
+	}
+	ec.w = f
+	ec.enc = json.NewEncoder(f)
+	ec.stop = make(chan struct{})
+	for _, src := range ec.sources {
+		ec.wg.Add(1)
+		go func(src eventSource) {
+			defer ec.wg.Done()
+			ec.collect(src)
+		}(src)
+	}
+	return nil
+}
+
+// Stop stops watching events and closes the output file
+func (ec *EventCollector) Stop() {
+	if ec.stop == nil {
+		return
+	}
+	close(ec.stop)
+	ec.wg.Wait()
+	ec.stop = nil
+	if err := ec.w.Close(); err != nil {
+		log.Printf("fail to close %s: %s", ec.OutPath, err)
+	}
+	log.Printf("%d events are stored in %s", len(ec.seen), ec.OutPath)
+}
+
+// collect lists the events of the source and watches them from the listed
+// resourceVersion, the watch is resumed from the last observed
+// resourceVersion once it is closed, and the events are listed again if the
+// resourceVersion is too old
+func (ec *EventCollector) collect(src eventSource) {
+	var rv string
+	for {
+		if rv == "" {
+			obj, err := src.lw.List(metav1.ListOptions{})
+			if err != nil {
+				log.Printf("fail to list events in ns(%s) of %s: %s", src.namespace, src.cluster, err)
+				if !ec.wait() {
+					return
+				}
+				continue
+			}
+			el := obj.(*v1.EventList)
+			for i := range el.Items {
+				ec.record(src.cluster, &el.Items[i])
+			}
+			rv = el.GetResourceVersion()
+		}
+		w, err := src.lw.Watch(metav1.ListOptions{ResourceVersion: rv})
+		if err != nil {
+			log.Printf("fail to watch events in ns(%s) of %s: %s", src.namespace, src.cluster, err)
+			if apierrors.IsResourceExpired(err) || apierrors.IsGone(err) {
+				rv = ""
+			}
+			if !ec.wait() {
+				return
+			}
+			continue
+		}
+		var stopped bool
+		if rv, stopped = ec.consume(src, w, rv); stopped {
+			return
+		}
+	}
+}
+
+// consume records the events from the watch until it is closed or the
+// collector is stopped, it returns the last observed resourceVersion
+func (ec *EventCollector) consume(src eventSource, w watch.Interface, rv string) (string, bool) {
+	defer func() {
+		w.Stop()
+		// wait for the watcher to exit
+		for range w.ResultChan() {
+		}
+	}()
+	for {
+		select {
+		case <-ec.stop:
+			return rv, true
+		case e, ok := <-w.ResultChan():
+			if !ok {
+				return rv, false
+			}
+			switch e.Type {
+			case watch.Added, watch.Modified:
+				if ev, ok := e.Object.(*v1.Event); ok {
+					ec.record(src.cluster, ev)
+					rv = ev.GetResourceVersion()
+				}
+			case watch.Error:
+				log.Printf("watch events in ns(%s) of %s: %s", src.namespace, src.cluster,
+					apierrors.FromObject(e.Object))
+				// e.g., the resourceVersion is too old, list again
+				return "", false
+			}
+		}
+	}
+}
+
+// wait waits for the Interval, false if the collector is stopped
+func (ec *EventCollector) wait() bool {
+	select {
+	case <-ec.stop:
+		return false
+	case <-time.After(ec.Interval):
+		return true
+	}
+}
+
+// record writes the event if it is new or updated
+func (ec *EventCollector) record(cluster string, e *v1.Event) {
+	ec.Lock()
+	defer ec.Unlock()
+	key := cluster + "/" + string(e.GetUID())
+	if rv, exist := ec.seen[key]; exist && rv == e.GetResourceVersion() {
+		return
+	}
+	ec.seen[key] = e.GetResourceVersion()
+	if err := ec.enc.Encode(toEventRecord(cluster, *e, time.Now().Unix())); err != nil {
+		log.Printf("fail to write event: %s", err)
+	}
+}
+
+func toEventRecord(cluster string, e v1.Event, observedAt int64) *EventRecord {
+	er := &EventRecord{
+		Cluster:        cluster,
+		Namespace:      e.GetNamespace(),
+		Kind:           e.InvolvedObject.Kind,
+		Name:           e.InvolvedObject.Name,
+		Type:           e.Type,
+		Reason:         e.Reason,
+		Message:        e.Message,
+		Count:          e.Count,
+		FirstTimestamp: e.FirstTimestamp.Unix(),
+		LastTimestamp:  e.LastTimestamp.Unix(),
+		ObservedAt:     observedAt,
+	}
+	if e.FirstTimestamp.IsZero() {
+		er.FirstTimestamp = e.EventTime.Unix()
+	}
+	if e.LastTimestamp.IsZero() {
+		er.LastTimestamp = er.FirstTimestamp
+	}
+	return er
+}
+
+// LoadEventRecords reads the events stored by the EventCollector
+func LoadEventRecords(eventsPath string) ([]EventRecord, error) {
+	f, err := os.Open(eventsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var ret []EventRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		er := EventRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), &er); err != nil {
+			return nil, fmt.Errorf("%s: %s", eventsPath, err)
+		}
+		ret = append(ret, er)
+	}
+	return ret, scanner.Err()
+}
+
+// PodEvents groups the events of pods by pod name, the pods on the super
+// cluster share the names of the pods on the vc. Updates of an event are
+// merged into its latest record.
+func PodEvents(events []EventRecord) map[string][]EventRecord {
+	latest := make(map[string]int)
+	var deduped []EventRecord
+	for _, er := range events {
+		if er.Kind != "Pod" {
+			continue
+		}
+		key := strings.Join([]string{er.Cluster, er.Namespace, er.Name, er.Reason, er.Message}, "/")
+		if i, exist := latest[key]; exist {
+			deduped[i] = er
+			continue
+		}
+		latest[key] = len(deduped)
+		deduped = append(deduped, er)
+	}
+	ret := make(map[string][]EventRecord)
+	for _, er := range deduped {
+		ret[er.Name] = append(ret[er.Name], er)
+	}
+	return ret
+}
+
+// WriteEventReport writes the number of events by reason, and the
+// non-Normal events of each pod together with its total creation latency
+// (in seconds), slowest pods first
+func WriteEventReport(w io.Writer, events []EventRecord, rsMap map[string]*RuntimeStatics) error {
+	podEvents := PodEvents(events)
+
+	type reasonKey struct{ cluster, typ, reason string }
+	reasonCounts := make(map[reasonKey][2]int)
+	for _, ers := range podEvents {
+		pods := make(map[reasonKey]bool)
+		for _, er := range ers {
+			rk := reasonKey{er.Cluster, er.Type, er.Reason}
+			if er.Cluster != ClusterSuper {
+				rk.cluster = "vc"
+			}
+			c := reasonCounts[rk]
+			// events reported through the events.k8s.io api have no count
+			n := int(er.Count)
+			if n == 0 {
+				n = 1
+			}
+			c[0] += n
+			if !pods[rk] {
+				c[1]++
+				pods[rk] = true
+			}
+			reasonCounts[rk] = c
+		}
+	}
+	var rks []reasonKey
+	for rk := range reasonCounts {
+		rks = append(rks, rk)
+	}
+	sort.Slice(rks, func(i, j int) bool {
+		ci, cj := reasonCounts[rks[i]][0], reasonCounts[rks[j]][0]
+		if ci != cj {
+			return ci > cj
+		}
+		return fmt.Sprint(rks[i]) < fmt.Sprint(rks[j])
+	})
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CLUSTER\tTYPE\tREASON\tCOUNT\tPODS")
+	for _, rk := range rks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\n", rk.cluster, rk.typ, rk.reason,
+			reasonCounts[rk][0], reasonCounts[rk][1])
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	var podNames []string
+	for pn, ers := range podEvents {
+		for _, er := range ers {
+			if er.Type != v1.EventTypeNormal {
+				podNames = append(podNames, pn)
+				break
+			}
+		}
+	}
+	if len(podNames) == 0 {
+		return nil
+	}
+	total := func(pn string) int {
+		rs, exist := rsMap[pn]
+		if !exist || rs.SuperUpdate == 0 || rs.TenantCreation == 0 {
+			return -1
+		}
+		return rs.SuperUpdate - rs.TenantCreation
+	}
+	sort.Slice(podNames, func(i, j int) bool {
+		ti, tj := total(podNames[i]), total(podNames[j])
+		if ti != tj {
+			return ti > tj
+		}
+		return podNames[i] < podNames[j]
+	})
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "POD\tTOTAL(s)\tCLUSTER\tREASON\tCOUNT\tMESSAGE")
+	for _, pn := range podNames {
+		totalStr := "-"
+		if t := total(pn); t >= 0 {
+			totalStr = fmt.Sprintf("%d", t)
+		}
+		for _, er := range podEvents[pn] {
+			if er.Type == v1.EventTypeNormal {
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n", pn, totalStr, er.Cluster,
+				er.Reason, er.Count, er.Message)
+		}
+	}
+	return tw.Flush()
+}
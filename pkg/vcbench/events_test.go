@@ -0,0 +1,92 @@
+package vcbench
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestEventCollector(t *testing.T) {
+	vcCli := fake.NewFakeClientWithScheme(scheme.Scheme, &v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: "e1", Namespace: DefaultBenchNamespace, UID: "1"},
+		InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: "vc1-t1-pod0"},
+		Type:           v1.EventTypeNormal,
+		Reason:         "Scheduled",
+		Count:          1,
+	})
+	superEvent := &v1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: "e2", Namespace: "key-podbench", UID: "2"},
+		InvolvedObject: v1.ObjectReference{Kind: "Pod", Name: "vc1-t1-pod0"},
+		Type:           v1.EventTypeWarning,
+		Reason:         "FailedScheduling",
+		Message:        "0/1 nodes are available",
+		Count:          1,
+	}
+	// the events of the super cluster are watched, the ones of the vc are
+	// polled as the fake client can't watch
+	superCs := kubefake.NewSimpleClientset(superEvent.DeepCopy())
+
+	dir, err := ioutil.TempDir("", "events")
+	if err != nil {
+		t.Fatalf("fail to create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	eventsPath := path.Join(dir, "run.events.jsonl")
+	ec := NewEventCollector(eventsPath)
+	ec.Interval = 10 * time.Millisecond
+	if err := ec.AddSource("vc1", DefaultBenchNamespace, vcCli, nil); err != nil {
+		t.Fatalf("fail to add source: %s", err)
+	}
+	ec.addListWatchSource(ClusterSuper, "key-podbench", eventListWatch(superCs, "key-podbench"))
+	if err := ec.Start(); err != nil {
+		t.Fatalf("fail to start collector: %s", err)
+	}
+	// the update of the event is observed by the watch
+	time.Sleep(100 * time.Millisecond)
+	superEvent.Count = 3
+	superEvent.ResourceVersion = "2"
+	if _, err := superCs.CoreV1().Events("key-podbench").Update(context.TODO(), superEvent, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("fail to update event: %s", err)
+	}
+	var events []EventRecord
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if events, err = LoadEventRecords(eventsPath); err != nil {
+			t.Fatalf("fail to load events: %s", err)
+		}
+		if len(events) >= 3 {
+			break
+		}
+	}
+	ec.Stop()
+	if len(events) != 3 {
+		t.Fatalf("expect 3 records, got %d: %+v", len(events), events)
+	}
+
+	podEvents := PodEvents(events)
+	if len(podEvents["vc1-t1-pod0"]) != 2 {
+		t.Fatalf("expect the updates to be merged, got %+v", podEvents)
+	}
+
+	rsMap := map[string]*RuntimeStatics{"vc1-t1-pod0": {TenantCreation: 10, SuperUpdate: 25}}
+	buf := &bytes.Buffer{}
+	if err := WriteEventReport(buf, events, rsMap); err != nil {
+		t.Fatalf("fail to write event report: %s", err)
+	}
+	report := buf.String()
+	for _, want := range []string{"FailedScheduling  3", "vc1-t1-pod0  15", "0/1 nodes are available"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("%q not found in report:\n%s", want, report)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package vcbench
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+
+	"github.com/charleszheng44/vc-bench/pkg/util/hdrhistogram"
+)
+
+const (
+	// ScopeAll is the scope that covers all pods of a run
+	ScopeAll = "all"
+	// StageTotal is the stage that covers the whole lifecycle of a pod
+	StageTotal = "total"
+
+	scopeVcPrefix     = "vc/"
+	scopeTenantPrefix = "tenant/"
+
+	// durations are recorded in milliseconds and up to one day
+	histHighestTrackableValue = 24 * 3600 * 1000
+	histSignificantFigures    = 3
+	histUnit                  = "ms"
+)
+
+// StageHistograms holds a HDR histogram for every stage in every scope,
+// i.e., all pods, pods on one vc and pods of one tenant. Histograms from
+// different runs can be merged without keeping the per pod data.
+type StageHistograms struct {
+	sync.Mutex `json:"-"`
+
+	Unit   string                                        `json:"unit"`
+	Scopes map[string]map[string]*hdrhistogram.Histogram `json:"scopes"`
+}
+
+func NewStageHistograms() *StageHistograms {
+	return &StageHistograms{
+		Unit:   histUnit,
+		Scopes: make(map[string]map[string]*hdrhistogram.Histogram),
+	}
+}
+
+// VcScope returns the scope of pods on the given vc
+func VcScope(vc string) string {
+	return scopeVcPrefix + vc
+}
+
+// TenantScope returns the scope of pods of the given tenant
+func TenantScope(tenantID string) string {
+	return scopeTenantPrefix + tenantID
+}
+
+// Record records durations of the lifecycle stages of the pod in the
+// global, vc and tenant scopes. Stages with a missing timestamp are skipped.
+func (sh *StageHistograms) Record(rs *RuntimeStatics) {
+	scopes := []string{ScopeAll}
+	if rs.ClusterName != "" {
+		scopes = append(scopes, VcScope(rs.ClusterName))
+	}
+	if rs.TenantID != "" {
+		scopes = append(scopes, TenantScope(rs.TenantID))
+	}
+	sh.Lock()
+	defer sh.Unlock()
+	for _, stg := range lifecycleStages {
+		from, to := stg.from(rs), stg.to(rs)
+		if from == 0 || to == 0 || to < from {
+			continue
+		}
+		for _, scope := range scopes {
+			sh.recordLocked(scope, stg.name, int64(to-from)*1000)
+		}
+	}
+	if rs.TenantCreation != 0 && rs.SuperUpdate >= rs.TenantCreation {
+		for _, scope := range scopes {
+			sh.recordLocked(scope, StageTotal, int64(rs.SuperUpdate-rs.TenantCreation)*1000)
+		}
+	}
+}
+
+// RecordDuration records a duration (in milliseconds) of the stage in the
+// given scope
+func (sh *StageHistograms) RecordDuration(scope, stage string, ms int64) {
+	sh.Lock()
+	defer sh.Unlock()
+	sh.recordLocked(scope, stage, ms)
+}
+
+func (sh *StageHistograms) recordLocked(scope, stage string, ms int64) {
+	stages, exist := sh.Scopes[scope]
+	if !exist {
+		stages = make(map[string]*hdrhistogram.Histogram)
+		sh.Scopes[scope] = stages
+	}
+	h, exist := stages[stage]
+	if !exist {
+		// the configuration is constant, New never fails here
+		h, _ = hdrhistogram.New(histHighestTrackableValue, histSignificantFigures)
+		stages[stage] = h
+	}
+	h.RecordValue(ms)
+}
+
+// Merge adds all histograms of other to sh
+func (sh *StageHistograms) Merge(other *StageHistograms) {
+	sh.Lock()
+	defer sh.Unlock()
+	for scope, stages := range other.Scopes {
+		for stage, oh := range stages {
+			if _, exist := sh.Scopes[scope]; !exist {
+				sh.Scopes[scope] = make(map[string]*hdrhistogram.Histogram)
+			}
+			h, exist := sh.Scopes[scope][stage]
+			if !exist {
+				h, _ = hdrhistogram.New(histHighestTrackableValue, histSignificantFigures)
+				sh.Scopes[scope][stage] = h
+			}
+			h.Merge(oh)
+		}
+	}
+}
+
+// WriteFile serializes the histograms to the file
+func (sh *StageHistograms) WriteFile(histPath string) error {
+	sh.Lock()
+	defer sh.Unlock()
+	byts, err := json.Marshal(sh)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(histPath, byts, 0644)
+}
+
+// LoadStageHistograms reads the histograms serialized by WriteFile
+func LoadStageHistograms(histPath string) (*StageHistograms, error) {
+	byts, err := ioutil.ReadFile(histPath)
+	if err != nil {
+		return nil, err
+	}
+	sh := NewStageHistograms()
+	if err := json.Unmarshal(byts, sh); err != nil {
+		return nil, err
+	}
+	return sh, nil
+}
@@ -0,0 +1,226 @@
+package vcbench
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/charleszheng44/vc-bench/pkg/tenant"
+)
+
+// legacyDirPatterns infer the run parameters from names of legacy
+// result directories, e.g. "100tenants4000pods", "100-tenants-random-4000"
+// or "pod4000-tenant100-vcsleep0-podsleep0-<time>"
+var legacyDirPatterns = []struct {
+	re     *regexp.Regexp
+	fields []string
+}{
+	{regexp.MustCompile(`^(\d+)tenants(\d+)pods`), []string{"tenants", "pods"}},
+	{regexp.MustCompile(`^(\d+)-tenants-random-(\d+)`), []string{"tenants", "pods"}},
+	{regexp.MustCompile(`^pod(\d+)-tenant(\d+)-vcsleep(\d+)-podsleep(\d+)`),
+		[]string{"pods", "tenants", "tenantInterval", "podInterval"}},
+}
+
+// inferFromDirName fills the manifest with parameters encoded in the name
+// of the legacy directory, it returns false if the name is not recognized
+func inferFromDirName(dirName string, m *RunManifest) bool {
+	for _, p := range legacyDirPatterns {
+		subs := p.re.FindStringSubmatch(dirName)
+		if subs == nil {
+			continue
+		}
+		for i, field := range p.fields {
+			v, _ := strconv.Atoi(subs[i+1])
+			switch field {
+			case "tenants":
+				m.NumTenants = v
+			case "pods":
+				m.NumPods = v
+			case "tenantInterval":
+				m.TenantInterval = v
+			case "podInterval":
+				m.PodInterval = v
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// ImportLegacyDir normalizes a result directory of the old layout (i.e.
+// outData.log, outData.diff, raw metrics and the tenant json, without a
+// manifest) into outDataDir, so that it can be consumed like the output of
+// `vcbench run`. Rows with missing stages are listed in the
+// <outDataDir>.incomplete file.
+func ImportLegacyDir(legacyDir, outDataDir string) (*RunManifest, error) {
+	srcAbs, err := filepath.Abs(legacyDir)
+	if err != nil {
+		return nil, err
+	}
+	dstAbs, err := filepath.Abs(outDataDir)
+	if err != nil {
+		return nil, err
+	}
+	if srcAbs == dstAbs {
+		return nil, fmt.Errorf("output directory(%s) must differ from the legacy directory", outDataDir)
+	}
+	if err := os.MkdirAll(outDataDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	name := path.Base(dstAbs)
+	m := &RunManifest{
+		Name:         name,
+		Mode:         RunModeVC,
+		ImportedFrom: legacyDir,
+	}
+	warnf := func(format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		log.Printf("[IMPORT] %s", msg)
+		m.Warnings = append(m.Warnings, msg)
+	}
+
+	// 1. parameters encoded in the directory name
+	if !inferFromDirName(path.Base(srcAbs), m) {
+		warnf("can't infer parameters from the directory name(%s)", path.Base(srcAbs))
+	}
+
+	// 2. the tenant json, if any, is more reliable than the directory name
+	tenantJsons, err := filepath.Glob(path.Join(legacyDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	for _, tj := range tenantJsons {
+		tl, err := tenant.ParseTenantsJson(tj)
+		if err != nil || len(tl) == 0 {
+			continue
+		}
+		var numPods int
+		for _, t := range tl {
+			numPods += t.NumPods
+		}
+		if m.NumTenants != 0 && m.NumTenants != len(tl) {
+			warnf("directory name implies %d tenants, but %s contains %d", m.NumTenants, path.Base(tj), len(tl))
+		}
+		if m.NumPods != 0 && m.NumPods != numPods {
+			warnf("directory name implies %d pods, but %s contains %d", m.NumPods, path.Base(tj), numPods)
+		}
+		m.NumTenants, m.NumPods, m.Tenants = len(tl), numPods, tl
+		m.TenantJson = path.Base(tj)
+		if err := copyFile(tj, path.Join(outDataDir, m.TenantJson)); err != nil {
+			return nil, err
+		}
+		break
+	}
+
+	// 3. normalize the runtime data log and regenerate the derived files
+	logPath, err := FindOutDataFile(legacyDir, ".log")
+	if err != nil {
+		return nil, err
+	}
+	rsLst, err := LoadRuntimeStatics(logPath)
+	if err != nil {
+		return nil, err
+	}
+	AssignTenants(rsLst, m.Tenants)
+	if m.NumPods != 0 && m.NumPods != len(rsLst) {
+		warnf("expect %d pods, but %s contains %d rows", m.NumPods, path.Base(logPath), len(rsLst))
+	}
+	rsMap := make(map[string]*RuntimeStatics)
+	vcs := make(map[string]struct{})
+	sh := NewStageHistograms()
+	for _, rs := range rsLst {
+		rsMap[rs.PodName] = rs
+		if rs.ClusterName != "" {
+			vcs[rs.ClusterName] = struct{}{}
+		}
+		sh.Record(rs)
+	}
+	m.NumVC = len(vcs)
+
+	if err := writeOutDataFiles(outDataDir, name, rsMap); err != nil {
+		return nil, err
+	}
+	if err := sh.WriteFile(path.Join(outDataDir, name+".hist.json")); err != nil {
+		return nil, err
+	}
+	incompleteFd, err := os.Create(path.Join(outDataDir, name+".incomplete"))
+	if err != nil {
+		return nil, err
+	}
+	defer incompleteFd.Close()
+	if m.IncompletePods, err = WriteIncompletePods(incompleteFd, rsMap); err != nil {
+		return nil, err
+	}
+	if m.IncompletePods != 0 {
+		warnf("%d pods have missing stages", m.IncompletePods)
+	}
+
+	// 4. raw metrics are copied with normalized names
+	for _, suffix := range []string{".syncer.metrics", ".kubelet.metrics"} {
+		metricsPath, err := FindOutDataFile(legacyDir, suffix)
+		if err != nil {
+			continue
+		}
+		if err := copyFile(metricsPath, path.Join(outDataDir, name+suffix)); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, m.WriteFile(outDataDir)
+}
+
+// WriteIncompletePods lists the pods with missing stages and the missing
+// columns, it returns the number of listed pods
+func WriteIncompletePods(w io.Writer, rsMap map[string]*RuntimeStatics) (int, error) {
+	if _, err := io.WriteString(w, "#podName,missingStages\n"); err != nil {
+		return 0, err
+	}
+	var incomplete int
+	for _, pn := range sortedPodNames(rsMap) {
+		missing := MissingStages(rsMap[pn])
+		if len(missing) == 0 {
+			continue
+		}
+		incomplete++
+		if _, err := fmt.Fprintf(w, "%s,%s\n", pn, strings.Join(missing, ";")); err != nil {
+			return incomplete, err
+		}
+	}
+	return incomplete, nil
+}
+
+// writeOutDataFiles writes the <name>.log and <name>.diff to outDataDir
+func writeOutDataFiles(outDataDir, name string, rsMap map[string]*RuntimeStatics) error {
+	logFd, err := os.Create(path.Join(outDataDir, name+".log"))
+	if err != nil {
+		return err
+	}
+	defer logFd.Close()
+	diffFd, err := os.Create(path.Join(outDataDir, name+".diff"))
+	if err != nil {
+		return err
+	}
+	defer diffFd.Close()
+	return WriteRuntimeStatics(logFd, diffFd, rsMap)
+}
+
+func copyFile(src, dst string) error {
+	srcFd, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcFd.Close()
+	dstFd, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer dstFd.Close()
+	_, err = io.Copy(dstFd, srcFd)
+	return err
+}
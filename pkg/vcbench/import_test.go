@@ -0,0 +1,27 @@
+package vcbench
+
+import (
+	"testing"
+)
+
+func TestInferFromDirName(t *testing.T) {
+	for dirName, want := range map[string]RunManifest{
+		"100tenants4000pods":      {NumTenants: 100, NumPods: 4000},
+		"100-tenants-random-1000": {NumTenants: 100, NumPods: 1000},
+		"pod500-tenant10-vcsleep100-podsleep20-20101010150405": {
+			NumPods: 500, NumTenants: 10, TenantInterval: 100, PodInterval: 20,
+		},
+	} {
+		m := RunManifest{}
+		if !inferFromDirName(dirName, &m) {
+			t.Fatalf("fail to infer parameters from %s", dirName)
+		}
+		if m.NumTenants != want.NumTenants || m.NumPods != want.NumPods ||
+			m.TenantInterval != want.TenantInterval || m.PodInterval != want.PodInterval {
+			t.Fatalf("%s: want %+v, get %+v", dirName, want, m)
+		}
+	}
+	if inferFromDirName("outData", &RunManifest{}) {
+		t.Fatalf("unexpected parameters inferred from outData")
+	}
+}
@@ -0,0 +1,45 @@
+package vcbench
+
+import (
+	"fmt"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/rand"
+)
+
+const (
+	// LabelManagedBy and ManagedByVcbench mark every object created by
+	// vcbench
+	LabelManagedBy   = "app.kubernetes.io/managed-by"
+	ManagedByVcbench = "vcbench"
+	// LabelRunID is the id of the run that created the object
+	LabelRunID = "vcbench.io/run-id"
+)
+
+// NewRunID generates an id of a run, e.g. 20201010-150405-x7k2p
+func NewRunID() string {
+	return fmt.Sprintf("%s-%s", time.Now().Format("20060102-150405"), rand.String(5))
+}
+
+// setRunLabels labels the object as created by the run
+func setRunLabels(obj metav1.Object, runID string) {
+	lbs := obj.GetLabels()
+	if lbs == nil {
+		lbs = make(map[string]string)
+	}
+	lbs[LabelManagedBy] = ManagedByVcbench
+	lbs[LabelRunID] = runID
+	obj.SetLabels(lbs)
+}
+
+// RunSelector selects the objects created by the run, or by any run of
+// vcbench if runID is empty
+func RunSelector(runID string) labels.Selector {
+	set := labels.Set{LabelManagedBy: ManagedByVcbench}
+	if runID != "" {
+		set[LabelRunID] = runID
+	}
+	return labels.SelectorFromSet(set)
+}
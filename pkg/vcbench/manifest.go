@@ -0,0 +1,81 @@
+package vcbench
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path"
+
+	"github.com/charleszheng44/vc-bench/pkg/tenant"
+)
+
+const (
+	// ManifestFileName is the name of the file, in the output data
+	// directory, that describes the parameters of a run
+	ManifestFileName = "manifest.json"
+
+	// RunModeVC is the mode of runs that submit pods through VirtualClusters
+	RunModeVC = "vc"
+	// RunModeBase is the mode of runs that submit pods to a plain cluster
+	RunModeBase = "base"
+	// RunModeProvision is the mode of runs that provision vc
+	RunModeProvision = "provision"
+	// RunModeSimulate is the mode of runs against in-memory clusters synced
+	// by a simulated syncer
+	RunModeSimulate = "simulate"
+)
+
+// RunManifest describes how a run was conducted, so that results of
+// different runs can be compared
+type RunManifest struct {
+	Name           string          `json:"name"`
+	RunID          string          `json:"runID,omitempty"`
+	Mode           string          `json:"mode"`
+	NumPods        int             `json:"numPods"`
+	NumTenants     int             `json:"numTenants"`
+	NumVC          int             `json:"numVC,omitempty"`
+	TenantInterval int             `json:"tenantInterval"`
+	PodInterval    int             `json:"podInterval"`
+	TenantJson     string          `json:"tenantJson,omitempty"`
+	Tenants        []tenant.Tenant `json:"tenants,omitempty"`
+	// ClusterVersion and VCRate are the ClusterVersion of the provisioned
+	// vc and the number of vc provisioned per minute
+	ClusterVersion string  `json:"clusterVersion,omitempty"`
+	VCRate         float64 `json:"vcRate,omitempty"`
+	// SimSyncer is the queueing model of the simulated syncer
+	SimSyncer *SimSyncerOptions `json:"simSyncer,omitempty"`
+
+	// ImportedFrom is the legacy directory the run is imported from
+	ImportedFrom string `json:"importedFrom,omitempty"`
+	// IncompletePods is the number of pods with missing stages
+	IncompletePods int `json:"incompletePods"`
+	// Warnings contains problems found while producing the results
+	Warnings []string `json:"warnings,omitempty"`
+
+	// TeardownSeconds is the time `vcbench clean` took to remove the
+	// benchmark namespaces, and TeardownLeftovers lists the objects that
+	// were not removed in time
+	TeardownSeconds   float64  `json:"teardownSeconds,omitempty"`
+	TeardownLeftovers []string `json:"teardownLeftovers,omitempty"`
+}
+
+// WriteFile writes the manifest to the output data directory
+func (m *RunManifest) WriteFile(outDataDir string) error {
+	byts, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(outDataDir, ManifestFileName), byts, 0644)
+}
+
+// LoadRunManifest reads the manifest from the output data directory
+func LoadRunManifest(outDataDir string) (*RunManifest, error) {
+	byts, err := ioutil.ReadFile(path.Join(outDataDir, ManifestFileName))
+	if err != nil {
+		return nil, err
+	}
+	m := &RunManifest{}
+	if err := json.Unmarshal(byts, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
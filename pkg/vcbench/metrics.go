@@ -0,0 +1,188 @@
+package vcbench
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var markerRe = regexp.MustCompile(`^---------- (start|end|record|gap) at (\d+)(: (.*))? ----------$`)
+
+// MetricSample is a sample of the Prometheus text exposition format
+type MetricSample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+// MetricsRecord contains the samples of one scrape, or the reason of a
+// failed scrape if Gap is not empty
+type MetricsRecord struct {
+	Timestamp int64
+	Samples   []MetricSample
+	Gap       string
+}
+
+// OpenMetricsFile opens a file written by the Scraper, gzip-compressed
+// files are decompressed transparently
+func OpenMetricsFile(metricsPath string) (io.ReadCloser, error) {
+	f, err := os.Open(metricsPath)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(f)
+	magic, err := br.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &readCloser{Reader: gz, closers: []io.Closer{gz, f}}, nil
+	}
+	return &readCloser{Reader: br, closers: []io.Closer{f}}, nil
+}
+
+type readCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (rc *readCloser) Close() error {
+	var retErr error
+	for _, c := range rc.closers {
+		if err := c.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}
+	return retErr
+}
+
+// ParseMetricsFile parses a plain or compressed metrics file written by
+// the Scraper into records. If filter is not nil, only samples with a
+// name accepted by filter are kept.
+func ParseMetricsFile(metricsPath string, filter func(name string) bool) ([]MetricsRecord, error) {
+	rc, err := OpenMetricsFile(metricsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var (
+		records []MetricsRecord
+		samples []MetricSample
+		lineNum int
+	)
+	scanner := bufio.NewScanner(rc)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if subs := markerRe.FindStringSubmatch(line); subs != nil {
+			ts, _ := strconv.ParseInt(subs[2], 10, 64)
+			switch subs[1] {
+			case "record":
+				records = append(records, MetricsRecord{Timestamp: ts, Samples: samples})
+			case "gap":
+				records = append(records, MetricsRecord{Timestamp: ts, Gap: subs[4]})
+			}
+			samples = nil
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		s, err := parseSample(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: line %d: %s", metricsPath, lineNum, err)
+		}
+		if filter == nil || filter(s.Name) {
+			samples = append(samples, s)
+		}
+	}
+	return records, scanner.Err()
+}
+
+// parseSample parses a line like `name{k1="v1",k2="v2"} value [timestamp]`
+func parseSample(line string) (MetricSample, error) {
+	s := MetricSample{}
+	name, rest := splitMetricName(line)
+	if name == "" {
+		return s, fmt.Errorf("invalid sample %q", line)
+	}
+	s.Name = name
+	if strings.HasPrefix(rest, "{") {
+		labels, remain, err := parseLabels(rest[1:])
+		if err != nil {
+			return s, err
+		}
+		s.Labels = labels
+		rest = remain
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return s, fmt.Errorf("missing value in %q", line)
+	}
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return s, err
+	}
+	s.Value = v
+	return s, nil
+}
+
+func splitMetricName(line string) (name, rest string) {
+	i := strings.IndexAny(line, "{ \t")
+	if i < 0 {
+		return line, ""
+	}
+	return line[:i], line[i:]
+}
+
+// parseLabels parses the label pairs after the opening brace and returns
+// the remaining of the line after the closing brace
+func parseLabels(s string) (map[string]string, string, error) {
+	labels := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " ,")
+		if strings.HasPrefix(s, "}") {
+			return labels, s[1:], nil
+		}
+		eq := strings.Index(s, "=")
+		if eq < 0 || len(s) < eq+2 || s[eq+1] != '"' {
+			return nil, "", fmt.Errorf("invalid labels %q", s)
+		}
+		key := strings.TrimSpace(s[:eq])
+		s = s[eq+2:]
+		val := &bytes.Buffer{}
+		closed := false
+		for i := 0; i < len(s); i++ {
+			if s[i] == '\\' && i+1 < len(s) {
+				switch s[i+1] {
+				case 'n':
+					val.WriteByte('\n')
+				default:
+					val.WriteByte(s[i+1])
+				}
+				i++
+				continue
+			}
+			if s[i] == '"' {
+				s = s[i+1:]
+				closed = true
+				break
+			}
+			val.WriteByte(s[i])
+		}
+		if !closed {
+			return nil, "", fmt.Errorf("unterminated label value of %s", key)
+		}
+		labels[key] = val.String()
+	}
+}
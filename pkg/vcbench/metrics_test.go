@@ -0,0 +1,84 @@
+package vcbench
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const testExposition = `# HELP apiserver_flowcontrol_dispatched_requests_total Number of requests released by API Priority and Fairness
+# TYPE apiserver_flowcontrol_dispatched_requests_total counter
+apiserver_flowcontrol_dispatched_requests_total{flowSchema="tenant-1",priorityLevel="tenant-1"} 12
+apiserver_flowcontrol_dispatched_requests_total{flowSchema="global-default",priorityLevel="global-default"} 3
+# HELP go_goroutines Number of goroutines that currently exist.
+# TYPE go_goroutines gauge
+go_goroutines 42
+`
+
+func TestParseSample(t *testing.T) {
+	s, err := parseSample(`http_requests_total{method="GET",path="/a\"b",code="200"} 1027 1395066363000`)
+	if err != nil {
+		t.Fatalf("fail to parse sample: %s", err)
+	}
+	want := MetricSample{
+		Name:   "http_requests_total",
+		Labels: map[string]string{"method": "GET", "path": `/a"b`, "code": "200"},
+		Value:  1027,
+	}
+	if !reflect.DeepEqual(s, want) {
+		t.Fatalf("want %+v, get %+v", want, s)
+	}
+}
+
+func TestScrapeFilteredAndCompressed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testExposition))
+	}))
+	defer srv.Close()
+
+	outDataDir, err := ioutil.TempDir("", "metrics")
+	if err != nil {
+		t.Fatalf("fail to create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(outDataDir)
+
+	s, err := NewScraper(outDataDir, []ScrapeTarget{
+		{
+			Name:        "compressed",
+			Addr:        srv.URL,
+			MetricAllow: []string{"^apiserver_flowcontrol_"},
+			LabelDeny:   []string{`flowSchema="global-default"`},
+			Compress:    true,
+		},
+		{Name: "plain", Addr: srv.URL},
+	})
+	if err != nil {
+		t.Fatalf("fail to create scraper: %s", err)
+	}
+	s.Start()
+	<-time.After(100 * time.Millisecond)
+	s.Stop()
+
+	compressed, err := ParseMetricsFile(path.Join(outDataDir, s.Targets[0].OutFile), nil)
+	if err != nil {
+		t.Fatalf("fail to parse compressed metrics: %s", err)
+	}
+	if len(compressed) != 1 || len(compressed[0].Samples) != 1 ||
+		compressed[0].Samples[0].Labels["flowSchema"] != "tenant-1" {
+		t.Fatalf("unexpected compressed records: %+v", compressed)
+	}
+
+	plain, err := ParseMetricsFile(path.Join(outDataDir, s.Targets[1].OutFile),
+		func(name string) bool { return name == "go_goroutines" })
+	if err != nil {
+		t.Fatalf("fail to parse plain metrics: %s", err)
+	}
+	if len(plain) != 1 || len(plain[0].Samples) != 1 || plain[0].Samples[0].Value != 42 {
+		t.Fatalf("unexpected plain records: %+v", plain)
+	}
+}
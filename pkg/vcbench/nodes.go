@@ -0,0 +1,514 @@
+package vcbench
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// DefaultNodePrefix names the i-th mock node <prefix>-<i>, as the
+	// virtual-kubelet nodes started by the former script/run-vks
+	DefaultNodePrefix            = "vkubelet-mock"
+	DefaultNodeHeartbeatInterval = 10 * time.Second
+
+	// labelNodeType and nodeTypeVirtualKubelet are the label selected by
+	// the nodeSelector of defaultPodTemp
+	labelNodeType          = "type"
+	nodeTypeVirtualKubelet = "virtual-kubelet"
+	// taintVKProvider is the taint tolerated by defaultPodTemp
+	taintVKProvider = "virtual-kubelet.io/provider"
+	vkProviderMock  = "mock"
+	// nodeLeaseDurationSeconds is the lease duration of the kubelet
+	nodeLeaseDurationSeconds = 40
+	nodeFleetQPS             = 100
+)
+
+// LatencyDistribution samples the time a mock node takes to start a pod
+type LatencyDistribution struct {
+	// Kind is one of "fixed", "uniform" or "exp"
+	Kind string
+	// Min is the fixed latency, or the lower bound of the uniform
+	// distribution; Max is the upper bound of the uniform distribution;
+	// Mean is the mean of the exponential distribution
+	Min, Max, Mean time.Duration
+}
+
+// ParseLatencyDistribution parses a latency distribution, which is a
+// duration (e.g. 500ms), a uniform range (e.g. 100ms-2s) or an exponential
+// distribution with the mean (e.g. exp:1s)
+func ParseLatencyDistribution(spec string) (LatencyDistribution, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "exp:") {
+		mean, err := time.ParseDuration(strings.TrimPrefix(spec, "exp:"))
+		if err != nil || mean < 0 {
+			return LatencyDistribution{}, fmt.Errorf("invalid latency distribution %q", spec)
+		}
+		return LatencyDistribution{Kind: "exp", Mean: mean}, nil
+	}
+	if i := strings.Index(spec, "-"); i > 0 {
+		min, err := time.ParseDuration(spec[:i])
+		if err != nil {
+			return LatencyDistribution{}, fmt.Errorf("invalid latency distribution %q: %s", spec, err)
+		}
+		max, err := time.ParseDuration(spec[i+1:])
+		if err != nil || min < 0 || max < min {
+			return LatencyDistribution{}, fmt.Errorf("invalid latency distribution %q", spec)
+		}
+		return LatencyDistribution{Kind: "uniform", Min: min, Max: max}, nil
+	}
+	d, err := time.ParseDuration(spec)
+	if err != nil || d < 0 {
+		return LatencyDistribution{}, fmt.Errorf("invalid latency distribution %q", spec)
+	}
+	return LatencyDistribution{Kind: "fixed", Min: d}, nil
+}
+
+// Sample returns a latency of the distribution
+func (ld LatencyDistribution) Sample() time.Duration {
+	switch ld.Kind {
+	case "uniform":
+		if ld.Max == ld.Min {
+			return ld.Min
+		}
+		return ld.Min + time.Duration(rand.Int63n(int64(ld.Max-ld.Min)))
+	case "exp":
+		return time.Duration(rand.ExpFloat64() * float64(ld.Mean))
+	default:
+		return ld.Min
+	}
+}
+
+// MarshalText and UnmarshalText encode the distribution in the format of
+// ParseLatencyDistribution
+func (ld LatencyDistribution) MarshalText() ([]byte, error) {
+	return []byte(ld.String()), nil
+}
+
+func (ld *LatencyDistribution) UnmarshalText(text []byte) error {
+	parsed, err := ParseLatencyDistribution(string(text))
+	if err != nil {
+		return err
+	}
+	*ld = parsed
+	return nil
+}
+
+func (ld LatencyDistribution) String() string {
+	switch ld.Kind {
+	case "uniform":
+		return fmt.Sprintf("%s-%s", ld.Min, ld.Max)
+	case "exp":
+		return fmt.Sprintf("exp:%s", ld.Mean)
+	default:
+		return ld.Min.String()
+	}
+}
+
+// NodeFleetOptions configures a NodeFleet
+type NodeFleetOptions struct {
+	Count  int
+	Prefix string
+	// CPU, Memory and Pods are the capacity of each node
+	CPU    string
+	Memory string
+	Pods   string
+	// HeartbeatInterval is the interval of renewing the leases and the
+	// status of the nodes
+	HeartbeatInterval time.Duration
+	// StartLatency is the time a node takes from the pod being bound to the
+	// pod being Running and Ready
+	StartLatency LatencyDistribution
+}
+
+func (opts *NodeFleetOptions) setDefaults() {
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultNodePrefix
+	}
+	if opts.CPU == "" {
+		opts.CPU = "64"
+	}
+	if opts.Memory == "" {
+		opts.Memory = "256Gi"
+	}
+	if opts.Pods == "" {
+		opts.Pods = "500"
+	}
+	if opts.HeartbeatInterval <= 0 {
+		opts.HeartbeatInterval = DefaultNodeHeartbeatInterval
+	}
+}
+
+// NodeFleet registers mock virtual-kubelet nodes in the super cluster,
+// renews their leases and status, and starts the pods bound to them, so
+// that the benchmark pods get Running and Ready without real kubelets
+type NodeFleet struct {
+	Options NodeFleetOptions
+	// RunID labels the nodes of the fleet
+	RunID string
+
+	clientset kubernetes.Interface
+	nodes     map[string]bool
+	sync.Mutex
+	// starting is the set of pods waiting to be started
+	starting map[types.UID]bool
+}
+
+func NewNodeFleet(kbCfgPath string, opts NodeFleetOptions) (*NodeFleet, error) {
+	opts.setDefaults()
+	for _, q := range []string{opts.CPU, opts.Memory, opts.Pods} {
+		if _, err := resource.ParseQuantity(q); err != nil {
+			return nil, fmt.Errorf("invalid capacity %q: %s", q, err)
+		}
+	}
+	cfg, err := clientcmd.BuildConfigFromFlags("", kbCfgPath)
+	if err != nil {
+		return nil, err
+	}
+	// every node renews its lease and status, and every pod is started
+	// through the apiserver, which the default client rate limit throttles
+	cfg.QPS, cfg.Burst = nodeFleetQPS, 2*nodeFleetQPS
+	cs, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newNodeFleet(cs, opts), nil
+}
+
+func newNodeFleet(cs kubernetes.Interface, opts NodeFleetOptions) *NodeFleet {
+	opts.setDefaults()
+	nf := &NodeFleet{
+		Options:   opts,
+		RunID:     NewRunID(),
+		clientset: cs,
+		nodes:     make(map[string]bool),
+		starting:  make(map[types.UID]bool),
+	}
+	for _, name := range nf.NodeNames() {
+		nf.nodes[name] = true
+	}
+	return nf
+}
+
+// NodeNames returns the names of the nodes of the fleet
+func (nf *NodeFleet) NodeNames() []string {
+	var names []string
+	for i := 0; i < nf.Options.Count; i++ {
+		names = append(names, fmt.Sprintf("%s-%d", nf.Options.Prefix, i))
+	}
+	return names
+}
+
+// Run registers the nodes, then keeps them alive and starts the pods bound
+// to them until the stop channel is closed
+func (nf *NodeFleet) Run(stopCh <-chan struct{}) error {
+	if err := nf.Register(); err != nil {
+		return err
+	}
+	log.Printf("%d mock nodes are registered", nf.Options.Count)
+
+	factory := informers.NewSharedInformerFactory(nf.clientset, 0)
+	podInformer := factory.Core().V1().Pods().Informer()
+	podInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			if pod, ok := obj.(*v1.Pod); ok {
+				nf.handlePod(pod)
+			}
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			if pod, ok := obj.(*v1.Pod); ok {
+				nf.handlePod(pod)
+			}
+		},
+	})
+	factory.Start(stopCh)
+
+	ticker := time.NewTicker(nf.Options.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return nil
+		case <-ticker.C:
+			nf.heartbeat()
+		}
+	}
+}
+
+// newNode returns a Ready node with the label and taint of a mock
+// virtual-kubelet
+func (nf *NodeFleet) newNode(name string) *v1.Node {
+	capacity := v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse(nf.Options.CPU),
+		v1.ResourceMemory: resource.MustParse(nf.Options.Memory),
+		v1.ResourcePods:   resource.MustParse(nf.Options.Pods),
+	}
+	node := &v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				labelNodeType:            nodeTypeVirtualKubelet,
+				"kubernetes.io/role":     "agent",
+				"kubernetes.io/hostname": name,
+				"kubernetes.io/os":       "linux",
+			},
+		},
+		Spec: v1.NodeSpec{
+			Taints: []v1.Taint{{Key: taintVKProvider, Value: vkProviderMock, Effect: v1.TaintEffectNoSchedule}},
+		},
+		Status: v1.NodeStatus{
+			Capacity:    capacity,
+			Allocatable: capacity,
+			NodeInfo: v1.NodeSystemInfo{
+				OperatingSystem: "linux",
+				Architecture:    "amd64",
+				KubeletVersion:  "vcbench-mock",
+			},
+			Addresses:  []v1.NodeAddress{{Type: v1.NodeInternalIP, Address: "127.0.0.1"}},
+			Conditions: nodeConditions(metav1.Now()),
+		},
+	}
+	setRunLabels(node, nf.RunID)
+	return node
+}
+
+// nodeConditions returns the conditions of a healthy node
+func nodeConditions(now metav1.Time) []v1.NodeCondition {
+	conds := []v1.NodeCondition{
+		{Type: v1.NodeReady, Status: v1.ConditionTrue, Reason: "KubeletReady"},
+		{Type: v1.NodeMemoryPressure, Status: v1.ConditionFalse, Reason: "KubeletHasSufficientMemory"},
+		{Type: v1.NodeDiskPressure, Status: v1.ConditionFalse, Reason: "KubeletHasNoDiskPressure"},
+		{Type: v1.NodePIDPressure, Status: v1.ConditionFalse, Reason: "KubeletHasSufficientPID"},
+		{Type: v1.NodeNetworkUnavailable, Status: v1.ConditionFalse, Reason: "RouteCreated"},
+	}
+	for i := range conds {
+		conds[i].LastHeartbeatTime = now
+		conds[i].LastTransitionTime = now
+	}
+	return conds
+}
+
+// Register creates the nodes and their leases, existing nodes are taken
+// over by the fleet
+func (nf *NodeFleet) Register() error {
+	for _, name := range nf.NodeNames() {
+		node := nf.newNode(name)
+		created, err := nf.clientset.CoreV1().Nodes().Create(context.TODO(), node, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) {
+			created, err = nf.clientset.CoreV1().Nodes().Get(context.TODO(), name, metav1.GetOptions{})
+		}
+		if err != nil {
+			return fmt.Errorf("fail to create node %s: %s", name, err)
+		}
+		// an existing node keeps the status of its former owner
+		created.Status = node.Status
+		if _, err := nf.clientset.CoreV1().Nodes().UpdateStatus(context.TODO(), created, metav1.UpdateOptions{}); err != nil {
+			return fmt.Errorf("fail to update status of node %s: %s", name, err)
+		}
+		if err := nf.renewLease(created); err != nil {
+			return fmt.Errorf("fail to create lease of node %s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// renewLease creates or renews the lease of the node in kube-node-lease
+func (nf *NodeFleet) renewLease(node *v1.Node) error {
+	now := metav1.NewMicroTime(time.Now())
+	leases := nf.clientset.CoordinationV1().Leases(v1.NamespaceNodeLease)
+	lease, err := leases.Get(context.TODO(), node.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		holder, duration := node.GetName(), int32(nodeLeaseDurationSeconds)
+		_, err = leases.Create(context.TODO(), &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      node.GetName(),
+				Namespace: v1.NamespaceNodeLease,
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: "v1",
+					Kind:       "Node",
+					Name:       node.GetName(),
+					UID:        node.GetUID(),
+				}},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &holder,
+				LeaseDurationSeconds: &duration,
+				RenewTime:            &now,
+			},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(context.TODO(), lease, metav1.UpdateOptions{})
+	return err
+}
+
+// heartbeat renews the leases and the conditions of the nodes
+func (nf *NodeFleet) heartbeat() {
+	for _, name := range nf.NodeNames() {
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			node, err := nf.clientset.CoreV1().Nodes().Get(context.TODO(), name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if err := nf.renewLease(node); err != nil {
+				return err
+			}
+			node.Status.Conditions = nodeConditions(metav1.Now())
+			_, err = nf.clientset.CoreV1().Nodes().UpdateStatus(context.TODO(), node, metav1.UpdateOptions{})
+			return err
+		})
+		if err != nil {
+			log.Printf("fail to renew node %s: %s", name, err)
+		}
+	}
+}
+
+// handlePod starts a pending pod bound to the fleet after a sampled latency,
+// and removes a terminating pod, as the kubelet would
+func (nf *NodeFleet) handlePod(pod *v1.Pod) {
+	if !nf.nodes[pod.Spec.NodeName] {
+		return
+	}
+	if pod.GetDeletionTimestamp() != nil {
+		var grace int64
+		err := nf.clientset.CoreV1().Pods(pod.GetNamespace()).Delete(context.TODO(), pod.GetName(),
+			metav1.DeleteOptions{GracePeriodSeconds: &grace, Preconditions: metav1.NewUIDPreconditions(string(pod.GetUID()))})
+		if err != nil && !apierrors.IsNotFound(err) {
+			log.Printf("fail to delete %s/pod(%s): %s", pod.GetNamespace(), pod.GetName(), err)
+		}
+		return
+	}
+	if pod.Status.Phase != v1.PodPending && pod.Status.Phase != "" {
+		return
+	}
+	nf.Lock()
+	defer nf.Unlock()
+	if nf.starting[pod.GetUID()] {
+		return
+	}
+	nf.starting[pod.GetUID()] = true
+	// the pod is initialized once taken by the node, the sampled latency is
+	// the time its containers take to start
+	initialized := metav1.Now()
+	latency := nf.Options.StartLatency.Sample()
+	time.AfterFunc(latency, func() {
+		if err := nf.startPod(pod.GetNamespace(), pod.GetName(), initialized); err != nil {
+			log.Printf("fail to start %s/pod(%s): %s", pod.GetNamespace(), pod.GetName(), err)
+		}
+		nf.Lock()
+		delete(nf.starting, pod.GetUID())
+		nf.Unlock()
+	})
+}
+
+// startPod marks every container of the pod running, and the pod Running
+// and Ready. The PodScheduled condition set at binding is kept, and the pod
+// is Initialized at the given time.
+func (nf *NodeFleet) startPod(namespace, name string, initialized metav1.Time) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		pod, err := nf.clientset.CoreV1().Pods(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if pod.GetDeletionTimestamp() != nil || pod.Status.Phase == v1.PodRunning {
+			return nil
+		}
+		now := metav1.Now()
+		pod.Status.Phase = v1.PodRunning
+		pod.Status.HostIP = "127.0.0.1"
+		pod.Status.PodIP = "127.0.0.1"
+		pod.Status.StartTime = &initialized
+		// like the kubelet, a pod bound without the scheduler is taken as
+		// scheduled once it is on the node
+		if !hasPodCondition(pod, v1.PodScheduled) {
+			setPodCondition(pod, v1.PodScheduled, initialized)
+		}
+		setPodCondition(pod, v1.PodInitialized, initialized)
+		setPodCondition(pod, v1.ContainersReady, now)
+		setPodCondition(pod, v1.PodReady, now)
+		pod.Status.ContainerStatuses = nil
+		for _, c := range pod.Spec.Containers {
+			pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, v1.ContainerStatus{
+				Name:  c.Name,
+				Image: c.Image,
+				Ready: true,
+				State: v1.ContainerState{Running: &v1.ContainerStateRunning{StartedAt: now}},
+			})
+		}
+		_, err = nf.clientset.CoreV1().Pods(namespace).UpdateStatus(context.TODO(), pod, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+func hasPodCondition(pod *v1.Pod, ct v1.PodConditionType) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == ct {
+			return true
+		}
+	}
+	return false
+}
+
+// setPodCondition sets the condition of the pod True since the given time,
+// a condition that is True already is not changed
+func setPodCondition(pod *v1.Pod, ct v1.PodConditionType, since metav1.Time) {
+	for i := range pod.Status.Conditions {
+		c := &pod.Status.Conditions[i]
+		if c.Type != ct {
+			continue
+		}
+		if c.Status != v1.ConditionTrue {
+			c.Status = v1.ConditionTrue
+			c.LastTransitionTime = since
+			c.Reason, c.Message = "", ""
+		}
+		return
+	}
+	pod.Status.Conditions = append(pod.Status.Conditions, v1.PodCondition{
+		Type:               ct,
+		Status:             v1.ConditionTrue,
+		LastTransitionTime: since,
+	})
+}
+
+// Deregister deletes the nodes and their leases, it returns the nodes that
+// fail to be deleted
+func (nf *NodeFleet) Deregister() []string {
+	var failed []string
+	for _, name := range nf.NodeNames() {
+		err := nf.clientset.CoreV1().Nodes().Delete(context.TODO(), name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			log.Printf("fail to delete node %s: %s", name, err)
+			failed = append(failed, name)
+			continue
+		}
+		err = nf.clientset.CoordinationV1().Leases(v1.NamespaceNodeLease).Delete(context.TODO(), name, metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			log.Printf("fail to delete lease of node %s: %s", name, err)
+		}
+	}
+	return failed
+}
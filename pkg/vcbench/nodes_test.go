@@ -0,0 +1,123 @@
+package vcbench
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestParseLatencyDistribution(t *testing.T) {
+	for spec, expect := range map[string]LatencyDistribution{
+		"500ms":     {Kind: "fixed", Min: 500 * time.Millisecond},
+		"100ms-2s":  {Kind: "uniform", Min: 100 * time.Millisecond, Max: 2 * time.Second},
+		"exp:1s":    {Kind: "exp", Mean: time.Second},
+		" 0s ":      {Kind: "fixed"},
+		"2s-1s":     {},
+		"-1s":       {},
+		"exp:never": {},
+	} {
+		ld, err := ParseLatencyDistribution(spec)
+		if expect.Kind == "" {
+			if err == nil {
+				t.Errorf("expect %q to be invalid", spec)
+			}
+			continue
+		}
+		if err != nil || ld != expect {
+			t.Errorf("%q: expect %+v, got %+v %v", spec, expect, ld, err)
+		}
+		if reparsed, err := ParseLatencyDistribution(ld.String()); err != nil || reparsed != ld {
+			t.Errorf("%q: %s is parsed as %+v %v", spec, ld, reparsed, err)
+		}
+	}
+	ld := LatencyDistribution{Kind: "uniform", Min: time.Second, Max: 2 * time.Second}
+	for i := 0; i < 100; i++ {
+		if d := ld.Sample(); d < ld.Min || d >= ld.Max {
+			t.Fatalf("sample %s is out of %s", d, ld)
+		}
+	}
+}
+
+func TestNodeFleet(t *testing.T) {
+	cs := fake.NewSimpleClientset(
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod0", Namespace: DefaultBenchNamespace, UID: "pod0"},
+			Spec:       v1.PodSpec{NodeName: "vk-1", Containers: []v1.Container{{Name: "pod0", Image: "busybox"}}},
+			Status: v1.PodStatus{Phase: v1.PodPending, Conditions: []v1.PodCondition{{
+				Type: v1.PodScheduled, Status: v1.ConditionTrue, LastTransitionTime: metav1.Unix(100, 0)}}},
+		},
+		// bound to another node
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: DefaultBenchNamespace, UID: "pod1"},
+			Spec:       v1.PodSpec{NodeName: "kind-worker"},
+			Status:     v1.PodStatus{Phase: v1.PodPending},
+		},
+	)
+	startLatency := LatencyDistribution{Kind: "fixed", Min: 50 * time.Millisecond}
+	nf := newNodeFleet(cs, NodeFleetOptions{Count: 2, Prefix: "vk", HeartbeatInterval: 10 * time.Millisecond, StartLatency: startLatency})
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	errCh := make(chan error, 1)
+	go func() { errCh <- nf.Run(stopCh) }()
+
+	err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		select {
+		case err := <-errCh:
+			return false, err
+		default:
+		}
+		pod, err := cs.CoreV1().Pods(DefaultBenchNamespace).Get(context.TODO(), "pod0", metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return pod.Status.Phase == v1.PodRunning, nil
+	})
+	if err != nil {
+		t.Fatalf("pod0 is not started: %s", err)
+	}
+	pod, _ := cs.CoreV1().Pods(DefaultBenchNamespace).Get(context.TODO(), "pod0", metav1.GetOptions{})
+	if len(pod.Status.Conditions) != 4 || pod.Status.Conditions[3].Type != v1.PodReady || len(pod.Status.ContainerStatuses) != 1 || pod.Status.ContainerStatuses[0].State.Running == nil {
+		t.Errorf("unexpected status of pod0 %+v", pod.Status)
+	}
+	// the binding time is kept, and the start latency is taken by the
+	// containers
+	conditions := make(map[v1.PodConditionType]time.Time)
+	for _, c := range pod.Status.Conditions {
+		conditions[c.Type] = c.LastTransitionTime.Time
+	}
+	if !conditions[v1.PodScheduled].Equal(time.Unix(100, 0)) {
+		t.Errorf("expect PodScheduled to be kept, got %s", conditions[v1.PodScheduled])
+	}
+	if d := conditions[v1.ContainersReady].Sub(conditions[v1.PodInitialized]); d < startLatency.Min {
+		t.Errorf("expect containers to start in %s, got %s", startLatency.Min, d)
+	}
+	if pod, _ := cs.CoreV1().Pods(DefaultBenchNamespace).Get(context.TODO(), "pod1", metav1.GetOptions{}); pod.Status.Phase != v1.PodPending {
+		t.Errorf("expect pod1 on another node to be untouched, got %s", pod.Status.Phase)
+	}
+
+	node, err := cs.CoreV1().Nodes().Get(context.TODO(), "vk-1", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("fail to get node vk-1: %s", err)
+	}
+	if node.GetLabels()[labelNodeType] != nodeTypeVirtualKubelet ||
+		len(node.Spec.Taints) != 1 || node.Spec.Taints[0].Key != taintVKProvider {
+		t.Errorf("node vk-1 can not host the benchmark pods: %+v", node)
+	}
+	lease, err := cs.CoordinationV1().Leases(v1.NamespaceNodeLease).Get(context.TODO(), "vk-0", metav1.GetOptions{})
+	if err != nil || lease.Spec.RenewTime == nil {
+		t.Fatalf("lease of vk-0 is not renewed: %v", err)
+	}
+
+	if failed := nf.Deregister(); len(failed) != 0 {
+		t.Errorf("fail to deregister %v", failed)
+	}
+	nodes, _ := cs.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	if len(nodes.Items) != 0 {
+		t.Errorf("expect nodes to be deleted, got %d", len(nodes.Items))
+	}
+}
@@ -0,0 +1,271 @@
+package vcbench
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/charleszheng44/vc-bench/pkg/tenant"
+	"github.com/charleszheng44/vc-bench/pkg/util/hdrhistogram"
+)
+
+// column names used by the header of the data log of the baseline
+// benchmark (i.e. base-*.data)
+const (
+	colCreation        = "creationTimestamp"
+	colReady           = "readyTimestamp"
+	colPodScheduled    = "podScheduled"
+	colInitialized     = "initialized"
+	colContainersReady = "containersReady"
+	colObservedReady   = "observedReady"
+)
+
+// basePodNameRe matches the names of the pods of the baseline benchmark,
+// i.e., tenant<i>-pod<n> in the shared namespace or podbench-<i>-pod<n> in
+// the namespace of the tenant, where n starts from 1
+var basePodNameRe = regexp.MustCompile(`^(?:tenant|` + DefaultBenchNamespace + `-)(\d+)-` + defaultPodBaseName + `(\d+)$`)
+
+// OverheadKey identifies a pod by its tenant and the order, starting from
+// 0, in which the tenant submitted it. Pods of a baseline run and a vc run
+// of the same workload are aligned by the key.
+type OverheadKey struct {
+	TenantID string
+	Index    int
+}
+
+func (k OverheadKey) String() string {
+	return fmt.Sprintf("%s/%d", k.TenantID, k.Index)
+}
+
+// OverheadPair is a pod of the baseline run and its counterpart of the vc
+// run
+type OverheadPair struct {
+	Key  OverheadKey
+	Base *BasePodStatiscs
+	VC   *RuntimeStatics
+}
+
+// LoadBaseStatics reads the data log written by `vcbench base`, the key of
+// the returned map is the pod name. Columns are located by the header line,
+// logs without a header have the creation and ready timestamps only.
+func LoadBaseStatics(dataPath string) (map[string]*BasePodStatiscs, error) {
+	f, err := os.Open(dataPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header := map[string]int{colCreation: 1, colReady: 2}
+	rsMap := make(map[string]*BasePodStatiscs)
+	lineNum := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			header = make(map[string]int)
+			for i, col := range strings.Split(strings.TrimPrefix(line, "#"), ",") {
+				header[strings.TrimSpace(col)] = i
+			}
+			continue
+		}
+		fields := strings.Split(line, ",")
+		bs := &BasePodStatiscs{}
+		for col, dst := range map[string]*int64{
+			colCreation:        &bs.CreationTimestamp,
+			colReady:           &bs.ReadyTimestamp,
+			colPodScheduled:    &bs.ScheduledTimestamp,
+			colInitialized:     &bs.InitializedTimestamp,
+			colContainersReady: &bs.ContainersReadyTimestamp,
+			colObservedReady:   &bs.ObservedReadyTimestamp,
+		} {
+			idx, exist := header[col]
+			if !exist || idx >= len(fields) {
+				continue
+			}
+			v, err := strconv.ParseInt(strings.TrimSpace(fields[idx]), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: line %d: invalid %s: %s", dataPath, lineNum, col, err)
+			}
+			*dst = v
+		}
+		rsMap[strings.TrimSpace(fields[0])] = bs
+	}
+	return rsMap, scanner.Err()
+}
+
+// baseOverheadKey returns the key of a pod of the baseline benchmark, the
+// i-th tenant is tenants[i] if tenants are given
+func baseOverheadKey(podName string, tenants []tenant.Tenant) (OverheadKey, bool) {
+	subs := basePodNameRe.FindStringSubmatch(podName)
+	if subs == nil {
+		return OverheadKey{}, false
+	}
+	tenantIdx, _ := strconv.Atoi(subs[1])
+	podNum, _ := strconv.Atoi(subs[2])
+	tenantID := baseTenantID(tenantIdx)
+	if len(tenants) != 0 {
+		if tenantIdx >= len(tenants) {
+			return OverheadKey{}, false
+		}
+		tenantID = tenants[tenantIdx].ID
+	}
+	return OverheadKey{TenantID: tenantID, Index: podNum - 1}, true
+}
+
+// vcOverheadKey returns the key of a pod of the vc benchmark, which is
+// named <vc>-<tenant>-pod<n> with n starting from 0
+func vcOverheadKey(podName string, tenants []tenant.Tenant) (OverheadKey, bool) {
+	_, tenantID, index, ok := splitVCPodName(podName, tenants)
+	return OverheadKey{TenantID: tenantID, Index: index}, ok
+}
+
+// AlignOverhead pairs the complete pods of the baseline run with the
+// complete pods of the vc run by tenant and index. The pairs are sorted by
+// key, pods without a counterpart are returned as unmatched.
+func AlignOverhead(base map[string]*BasePodStatiscs, baseTenants []tenant.Tenant,
+	vc []*RuntimeStatics, vcTenants []tenant.Tenant) (pairs []OverheadPair, unmatched []string) {
+	baseByKey := make(map[OverheadKey]*BasePodStatiscs)
+	for pn, bs := range base {
+		if bs.CreationTimestamp == 0 || bs.ReadyTimestamp == 0 {
+			continue
+		}
+		key, ok := baseOverheadKey(pn, baseTenants)
+		if !ok {
+			unmatched = append(unmatched, "base/"+pn)
+			continue
+		}
+		baseByKey[key] = bs
+	}
+	for _, rs := range vc {
+		if rs.TenantCreation == 0 || rs.SuperUpdate == 0 {
+			continue
+		}
+		key, ok := vcOverheadKey(rs.PodName, vcTenants)
+		if !ok {
+			unmatched = append(unmatched, "vc/"+rs.PodName)
+			continue
+		}
+		bs, exist := baseByKey[key]
+		if !exist {
+			unmatched = append(unmatched, "vc/"+rs.PodName)
+			continue
+		}
+		delete(baseByKey, key)
+		pairs = append(pairs, OverheadPair{Key: key, Base: bs, VC: rs})
+	}
+	for key := range baseByKey {
+		unmatched = append(unmatched, "base/"+key.String())
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Key.TenantID != pairs[j].Key.TenantID {
+			return pairs[i].Key.TenantID < pairs[j].Key.TenantID
+		}
+		return pairs[i].Key.Index < pairs[j].Key.Index
+	})
+	sort.Strings(unmatched)
+	return pairs, unmatched
+}
+
+// overheadStage compares a stage of the vc path with its counterpart on the
+// plain cluster, stages only on the vc path have no `base`. A negative
+// duration means a missing timestamp.
+type overheadStage struct {
+	name string
+	base func(bs *BasePodStatiscs) int64
+	vc   func(rs *RuntimeStatics) int64
+}
+
+// baseStartup is the time a pod takes from creation to Ready on the plain
+// cluster
+func baseStartup(bs *BasePodStatiscs) int64 {
+	return bs.ReadyTimestamp - bs.CreationTimestamp
+}
+
+// overheadStages compares every lifecycle stage of the vc path, where the
+// pod creation on the super cluster corresponds to the whole startup on
+// the plain cluster, and the total latency of both paths
+func overheadStages() []overheadStage {
+	var stages []overheadStage
+	for _, stg := range lifecycleStages {
+		stg := stg
+		ostg := overheadStage{
+			name: stg.name,
+			vc: func(rs *RuntimeStatics) int64 {
+				from, to := stg.from(rs), stg.to(rs)
+				if from == 0 || to == 0 {
+					return -1
+				}
+				return int64(to - from)
+			},
+		}
+		if stg.name == "superCreation" {
+			ostg.base = baseStartup
+		}
+		stages = append(stages, ostg)
+	}
+	return append(stages, overheadStage{
+		name: StageTotal,
+		base: baseStartup,
+		vc:   func(rs *RuntimeStatics) int64 { return int64(rs.SuperUpdate - rs.TenantCreation) },
+	})
+}
+
+// WriteOverheadReport writes, for every stage, the mean and percentiles of
+// the baseline and vc runs and the latency added by the vc path in
+// milliseconds. The added mean is the mean of the per-pod differences, the
+// added percentiles are the differences of the percentiles.
+func WriteOverheadReport(w io.Writer, pairs []OverheadPair) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STAGE\tPAIRS\tSTAT\tBASE\tVC\tADDED(ms)")
+	for _, stg := range overheadStages() {
+		baseHist, _ := hdrhistogram.New(histHighestTrackableValue, histSignificantFigures)
+		vcHist, _ := hdrhistogram.New(histHighestTrackableValue, histSignificantFigures)
+		var (
+			count    int64
+			addedSum int64
+		)
+		for _, p := range pairs {
+			vcVal := stg.vc(p.VC) * 1000
+			var baseVal int64
+			if stg.base != nil {
+				baseVal = stg.base(p.Base) * 1000
+			}
+			if vcVal < 0 || baseVal < 0 {
+				continue
+			}
+			count++
+			addedSum += vcVal - baseVal
+			vcHist.RecordValue(vcVal)
+			baseHist.RecordValue(baseVal)
+		}
+		if count == 0 {
+			continue
+		}
+		baseCol := func(v float64) string {
+			if stg.base == nil {
+				return "-"
+			}
+			return fmt.Sprintf("%.1f", v)
+		}
+		fmt.Fprintf(tw, "%s\t%d\tMEAN\t%s\t%.1f\t%.1f\n", stg.name, count,
+			baseCol(baseHist.Mean()), vcHist.Mean(), float64(addedSum)/float64(count))
+		for _, q := range reportQuantiles {
+			b, v := baseHist.ValueAtQuantile(q), vcHist.ValueAtQuantile(q)
+			fmt.Fprintf(tw, "%s\t%d\tP%v\t%s\t%d\t%d\n", stg.name, count, q,
+				baseCol(float64(b)), v, v-b)
+		}
+		fmt.Fprintf(tw, "%s\t%d\tMAX\t%s\t%d\t%d\n", stg.name, count,
+			baseCol(float64(baseHist.Max())), vcHist.Max(), vcHist.Max()-baseHist.Max())
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,99 @@
+package vcbench
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/charleszheng44/vc-bench/pkg/tenant"
+)
+
+func TestLoadBaseStatics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "overhead")
+	if err != nil {
+		t.Fatalf("fail to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	rsMap := map[string]*BasePodStatiscs{
+		"podbench-0-pod1": {CreationTimestamp: 10, ScheduledTimestamp: 11, ReadyTimestamp: 14, ObservedReadyTimestamp: 15},
+	}
+	logBuf, diffBuf := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := WriteBaseStatics(logBuf, diffBuf, rsMap); err != nil {
+		t.Fatalf("fail to write base statics: %s", err)
+	}
+	dataPath := path.Join(dir, "base.data")
+	if err := ioutil.WriteFile(dataPath, logBuf.Bytes(), 0644); err != nil {
+		t.Fatalf("fail to write data: %s", err)
+	}
+	loaded, err := LoadBaseStatics(dataPath)
+	if err != nil {
+		t.Fatalf("fail to load base statics: %s", err)
+	}
+	if bs := loaded["podbench-0-pod1"]; bs == nil || *bs != *rsMap["podbench-0-pod1"] {
+		t.Errorf("unexpected loaded statics %+v", bs)
+	}
+
+	// logs written before the header was added
+	if err := ioutil.WriteFile(dataPath, []byte("tenant0-pod1,10,14\n"), 0644); err != nil {
+		t.Fatalf("fail to write data: %s", err)
+	}
+	if loaded, err = LoadBaseStatics(dataPath); err != nil {
+		t.Fatalf("fail to load base statics: %s", err)
+	}
+	if bs := loaded["tenant0-pod1"]; bs == nil || bs.CreationTimestamp != 10 || bs.ReadyTimestamp != 14 {
+		t.Errorf("unexpected loaded statics %+v", bs)
+	}
+}
+
+func TestAlignOverhead(t *testing.T) {
+	tenants := []tenant.Tenant{{ID: "tenant-1", NumPods: 2}, {ID: "tenant-1-a", NumPods: 1}}
+	base := map[string]*BasePodStatiscs{
+		"podbench-0-pod1": {CreationTimestamp: 100, ReadyTimestamp: 102},
+		"podbench-0-pod2": {CreationTimestamp: 100, ReadyTimestamp: 104},
+		"podbench-1-pod1": {CreationTimestamp: 100, ReadyTimestamp: 103},
+		// not Ready, skipped
+		"podbench-1-pod2": {CreationTimestamp: 100},
+	}
+	vc := []*RuntimeStatics{
+		{PodName: "vc-1-tenant-1-pod0", TenantCreation: 200, DwsDequeue: 201, SuperCreation: 201, SuperReady: 203, UwsDequeue: 204, SuperUpdate: 205},
+		{PodName: "vc-1-tenant-1-pod1", TenantCreation: 200, DwsDequeue: 201, SuperCreation: 201, SuperReady: 205, UwsDequeue: 206, SuperUpdate: 207},
+		{PodName: "vc-2-tenant-1-a-pod0", TenantCreation: 200, DwsDequeue: 201, SuperCreation: 202, SuperReady: 205, UwsDequeue: 205, SuperUpdate: 206},
+		{PodName: "vc-2-tenant-1-a-pod5", TenantCreation: 200, SuperUpdate: 206},
+	}
+	pairs, unmatched := AlignOverhead(base, tenants, vc, tenants)
+	if len(pairs) != 3 {
+		t.Fatalf("expect 3 pairs, got %d", len(pairs))
+	}
+	expectKeys := []OverheadKey{{"tenant-1", 0}, {"tenant-1", 1}, {"tenant-1-a", 0}}
+	for i, p := range pairs {
+		if p.Key != expectKeys[i] {
+			t.Errorf("pair %d: expect %v, got %v", i, expectKeys[i], p.Key)
+		}
+	}
+	if p := pairs[2]; p.Base != base["podbench-1-pod1"] || p.VC != vc[2] {
+		t.Errorf("tenant-1-a/0 is aligned to the wrong pods")
+	}
+	if len(unmatched) != 1 || unmatched[0] != "vc/vc-2-tenant-1-a-pod5" {
+		t.Errorf("unexpected unmatched pods %v", unmatched)
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WriteOverheadReport(buf, pairs); err != nil {
+		t.Fatalf("fail to write report: %s", err)
+	}
+	for _, line := range strings.Split(buf.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 6 && fields[0] == StageTotal && fields[2] == "MEAN" {
+			// vc totals are 5, 7, 6 seconds, base totals are 2, 4, 3 seconds
+			if fields[5] != "3000.0" {
+				t.Errorf("expect 3000ms added on average, got %s", fields[5])
+			}
+			return
+		}
+	}
+	t.Errorf("no mean of the total latency in report:\n%s", buf.String())
+}
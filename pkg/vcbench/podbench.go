@@ -5,9 +5,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"strconv"
 	"sync"
 	"text/template"
 	"time"
@@ -19,15 +17,16 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 
-	"sigs.k8s.io/multi-tenancy/incubator/virtualcluster/pkg/apis"
 	tenancyv1alpha1 "sigs.k8s.io/multi-tenancy/incubator/virtualcluster/pkg/apis/tenancy/v1alpha1"
 	"sigs.k8s.io/multi-tenancy/incubator/virtualcluster/pkg/controller/secret"
+	"sigs.k8s.io/multi-tenancy/incubator/virtualcluster/pkg/syncer/conversion"
 
-	"github.com/charleszheng44/vc-bench/pkg/constants"
 	"github.com/charleszheng44/vc-bench/pkg/tenant"
+	"github.com/charleszheng44/vc-bench/pkg/util/perftimestamp"
 )
 
 const (
@@ -60,12 +59,19 @@ spec:
 	DefaultBenchNamespace   = "podbench"
 	defaultVcKubeconfigName = "admin-kubeconfig"
 	superTimeFormat         = "2020-02-20T21:12:41Z"
+	defaultNamespaceWait    = 10 * time.Second
+	defaultPollInterval     = 20 * time.Second
 )
 
 type PodBenchConfig struct {
 	RsrcTemp       string
 	TenantInterval int
 	PodInterval    int
+	// NamespaceWait is the time waiting for the serviceaccount of the
+	// benchmark namespace to be created before submitting pods
+	NamespaceWait time.Duration
+	// PollInterval is the interval of checking the pods on every vc
+	PollInterval time.Duration
 }
 
 type RuntimeStatics struct {
@@ -77,7 +83,45 @@ type RuntimeStatics struct {
 	SuperReady     int
 	PodName        string
 	ClusterName    string
+	TenantID       string
 	PodCreated     bool
+	// Extra holds additional timestamps keyed by column name, e.g. the
+	// markers parsed from the syncer log
+	Extra map[string]int
+}
+
+// stageFields maps every lifecycle stage to the field holding its timestamp
+func (rs *RuntimeStatics) stageFields() map[perftimestamp.Stage]*int {
+	return map[perftimestamp.Stage]*int{
+		perftimestamp.StageTenantCreation: &rs.TenantCreation,
+		perftimestamp.StageDWSReconcile:   &rs.DwsDequeue,
+		perftimestamp.StageSuperCreation:  &rs.SuperCreation,
+		perftimestamp.StageSuperReady:     &rs.SuperReady,
+		perftimestamp.StageUWSReconcile:   &rs.UwsDequeue,
+		perftimestamp.StageFirstUpdate:    &rs.SuperUpdate,
+	}
+}
+
+// Timeline returns the recorded stages of the pod, a zero timestamp is
+// taken as not recorded
+func (rs *RuntimeStatics) Timeline() perftimestamp.StageTimeline {
+	tl := make(perftimestamp.StageTimeline)
+	for stage, ts := range rs.stageFields() {
+		if *ts != 0 {
+			tl[stage] = int64(*ts)
+		}
+	}
+	return tl
+}
+
+// SetTimeline records the stages of the timeline, stages missing from the
+// timeline are kept as they are
+func (rs *RuntimeStatics) SetTimeline(tl perftimestamp.StageTimeline) {
+	for stage, ts := range rs.stageFields() {
+		if v, exist := tl[stage]; exist {
+			*ts = int(v)
+		}
+	}
 }
 
 type BenchExecutor struct {
@@ -85,50 +129,62 @@ type BenchExecutor struct {
 	*PodBenchConfig
 	sync.Mutex
 
-	Tenants         []tenant.Tenant
-	scheme          *runtime.Scheme
-	RuntimeStatics  map[string]*RuntimeStatics
+	Tenants []tenant.Tenant
+	// RunID labels every object created by the run
+	RunID          string
+	scheme         *runtime.Scheme
+	RuntimeStatics map[string]*RuntimeStatics
+	Histograms     *StageHistograms
+	// Scraper, if set, collects metrics while the benchmark is running
+	Scraper *Scraper
+	// Profiler, if set, captures pprof profiles while the benchmark is
+	// running
+	Profiler *Profiler
+	// Events, if set, collects the events of the benchmark namespaces while
+	// the benchmark is running
+	Events          *EventCollector
+	backend         Backend
 	vcClients       map[string]client.Client
+	vcRestConfigs   map[string]*rest.Config
+	vcClusterKeys   map[string]string
 	waitingPodsOnVc map[string]int
 }
 
 func NewBenchExecutor(tenantsKbCfg string, tenants []tenant.Tenant, tenantInterval, podInterval, numOfVC int) (*BenchExecutor, error) {
-	// generate rest.Config for accessing tenant-masters k8s
-	tntKbCfgbyts, err := ioutil.ReadFile(tenantsKbCfg)
-	if err != nil {
-		return nil, err
-	}
-	tntCfg, err := clientcmd.RESTConfigFromKubeConfig(tntKbCfgbyts)
+	backend, err := NewKubeBackend(tenantsKbCfg)
 	if err != nil {
 		return nil, err
 	}
+	log.Print("built client for tenants master kube")
+	return NewBenchExecutorWithBackend(backend, tenants, tenantInterval, podInterval, numOfVC)
+}
+
+// NewBenchExecutorWithBackend builds a BenchExecutor of the first numOfVC
+// vc in the tenant masters cluster of the backend
+func NewBenchExecutorWithBackend(backend Backend, tenants []tenant.Tenant, tenantInterval, podInterval, numOfVC int) (*BenchExecutor, error) {
 	be := &BenchExecutor{
+		Client:          backend.Client(),
+		backend:         backend,
 		scheme:          scheme.Scheme,
 		RuntimeStatics:  make(map[string]*RuntimeStatics),
+		Histograms:      NewStageHistograms(),
 		vcClients:       make(map[string]client.Client),
+		vcRestConfigs:   make(map[string]*rest.Config),
+		vcClusterKeys:   make(map[string]string),
 		waitingPodsOnVc: make(map[string]int),
 		Tenants:         tenants,
+		RunID:           NewRunID(),
 		PodBenchConfig: &PodBenchConfig{
 			TenantInterval: tenantInterval,
 			PodInterval:    podInterval,
+			NamespaceWait:  defaultNamespaceWait,
+			PollInterval:   defaultPollInterval,
 		},
 	}
 
-	// add Virtualcluster and ClusterVersion schemes to client
-	if err := apis.AddToScheme(be.scheme); err != nil {
-		return nil, err
-	}
-	// build client for tenant
-	cli, err := client.New(tntCfg, client.Options{Scheme: be.scheme})
-	if err != nil {
-		return nil, err
-	}
-	be.Client = cli
-	log.Print("built client for tenants master kube")
-
 	// build clients for each vc
 	vcLst := &tenancyv1alpha1.VirtualClusterList{}
-	if err = cli.List(context.TODO(), vcLst); err != nil {
+	if err := be.List(context.TODO(), vcLst); err != nil {
 		return nil, err
 	}
 
@@ -138,11 +194,16 @@ func NewBenchExecutor(tenantsKbCfg string, tenants []tenant.Tenant, tenantInterv
 	log.Printf("there are %d vc on tenants-master kube", len(vcLst.Items))
 	var vcCounter int
 	for _, vc := range vcLst.Items {
-		vcCli, err := buildVcClient(be.Client, &vc)
+		vcCli, vcRestCfg, err := backend.VCClient(&vc)
 		if err != nil {
 			return nil, err
 		}
+		log.Printf("client is created for vc(%s)", vc.GetName())
 		be.vcClients[vc.GetName()] = vcCli
+		if vcRestCfg != nil {
+			be.vcRestConfigs[vc.GetName()] = vcRestCfg
+		}
+		be.vcClusterKeys[vc.GetName()] = conversion.ToClusterKey(&vc)
 		vcCounter++
 		if vcCounter == numOfVC {
 			break
@@ -152,7 +213,76 @@ func NewBenchExecutor(tenantsKbCfg string, tenants []tenant.Tenant, tenantInterv
 	return be, nil
 }
 
-func buildVcClient(tenantKubeCli client.Client, vc *tenancyv1alpha1.VirtualCluster) (client.Client, error) {
+// NumVC returns the number of vc used by the benchmark
+func (be *BenchExecutor) NumVC() int {
+	return len(be.vcClients)
+}
+
+// TenantApiserverTargets returns a scrape target for the apiserver of every
+// vc, which are accessed with the admin-kubeconfig of the vc
+func (be *BenchExecutor) TenantApiserverTargets(interval int) []ScrapeTarget {
+	var targets []ScrapeTarget
+	for vc, cfg := range be.vcRestConfigs {
+		targets = append(targets, ScrapeTarget{
+			Name:       "apiserver-" + vc,
+			Addr:       defaultApiserverMetricsPath,
+			Interval:   interval,
+			RestConfig: cfg,
+		})
+	}
+	return targets
+}
+
+// AddEventSources adds the benchmark namespace of every vc, and the
+// namespaces the syncer maps them to on the super cluster, to the event
+// collector. The super cluster is skipped if superCli is nil, its events
+// are polled if superCfg is nil.
+func (be *BenchExecutor) AddEventSources(ec *EventCollector, superCli client.Reader, superCfg *rest.Config) error {
+	for vc, vcCli := range be.vcClients {
+		if err := ec.AddSource(vc, DefaultBenchNamespace, vcCli, be.vcRestConfigs[vc]); err != nil {
+			return err
+		}
+		if superCli != nil {
+			superNs := conversion.ToSuperMasterNamespace(be.vcClusterKeys[vc], DefaultBenchNamespace)
+			if err := ec.AddSource(ClusterSuper, superNs, superCli, superCfg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CollectEvents sets up the collection of events into outPath during the
+// run, only the events on the vc are collected if the super cluster of the
+// backend is not accessible
+func (be *BenchExecutor) CollectEvents(outPath string) error {
+	ec := NewEventCollector(outPath)
+	superCli, superCfg, err := be.backend.SuperCluster()
+	if err != nil {
+		log.Printf("will not collect events on the super cluster: %s", err)
+		superCli, superCfg = nil, nil
+	}
+	if err := be.AddEventSources(ec, superCli, superCfg); err != nil {
+		return err
+	}
+	be.Events = ec
+	return nil
+}
+
+// NewClient builds a client of the cluster in the kubeconfig, which also
+// knows the VirtualCluster and ClusterVersion types
+func NewClient(kbCfgPath string) (client.Client, error) {
+	cfg, err := clientcmd.BuildConfigFromFlags("", kbCfgPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := addVCTypesToScheme(); err != nil {
+		return nil, err
+	}
+	return client.New(cfg, client.Options{Scheme: scheme.Scheme})
+}
+
+func buildVcRestConfig(tenantKubeCli client.Client, vc *tenancyv1alpha1.VirtualCluster) (*rest.Config, error) {
 	rootNs := vc.Status.ClusterNamespace
 	admKbCfgSrt := &v1.Secret{}
 	if err := tenantKubeCli.Get(context.TODO(), types.NamespacedName{
@@ -176,16 +306,7 @@ func buildVcClient(tenantKubeCli client.Client, vc *tenancyv1alpha1.VirtualClust
 	// 	return nil, err
 	// }
 	log.Printf("update admin-kubeconfig for vc(%s)", vc.GetName())
-	vcRestCfg, err := clientcmd.RESTConfigFromKubeConfig(admKbCfgBytes)
-	if err != nil {
-		return nil, err
-	}
-	vcCli, err := client.New(vcRestCfg, client.Options{Scheme: scheme.Scheme})
-	if err != nil {
-		return nil, err
-	}
-	log.Printf("client is created for vc(%s)", vc.GetName())
-	return vcCli, nil
+	return clientcmd.RESTConfigFromKubeConfig(admKbCfgBytes)
 }
 
 func yamlBytsToObject(scheme *runtime.Scheme, yamlByts []byte) (runtime.Object, error) {
@@ -198,99 +319,6 @@ func yamlBytsToObject(scheme *runtime.Scheme, yamlByts []byte) (runtime.Object,
 	return obj, nil
 }
 
-func (be *BenchExecutor) CleanUp(targetNs string) {
-	startTime2 := time.Now().Unix()
-	for vc, vcCli := range be.vcClients {
-		log.Printf("will delete vk-nodes on vc %s", vc)
-		if err := vcCli.DeleteAllOf(context.TODO(), &v1.Node{}, &client.DeleteAllOfOptions{}); err != nil {
-			log.Printf("fail to delete nodes on vc(%s): %s", vc, err)
-		}
-	}
-	allNodesRemoved := false
-	for !allNodesRemoved {
-		allNodesRemoved = true
-		for vc, vcCli := range be.vcClients {
-			nl := &v1.NodeList{}
-			if err := vcCli.List(context.TODO(), nl, &client.ListOptions{}); err != nil {
-				log.Printf("fail to list node on vc(%s): %s", vc, err)
-			}
-			if len(nl.Items) != 0 {
-				log.Printf("there are %d nodes left on vc(%s)", len(nl.Items), vc)
-				time.Sleep(1 * time.Second)
-				allNodesRemoved = false
-			}
-		}
-	}
-	endTime2 := time.Now().Unix()
-	log.Printf("deleting all nodes took %d seconds", int(endTime2-startTime2))
-
-	// startTime := time.Now().Unix()
-	// deletingNamespace := 0
-	for vc, vcCli := range be.vcClients {
-		go be.DeletePods(vcCli, targetNs, vc)
-		// log.Printf("will delete namespace %s on vc %s", targetNs, vc)
-		// if err := vcCli.Delete(context.TODO(), &v1.Namespace{
-		// 	ObjectMeta: metav1.ObjectMeta{
-		// 		Name: targetNs,
-		// 	},
-		// }); err != nil {
-		// 	log.Printf("fail to delete namespace %s on vc(%s): %s", targetNs, vc, err)
-		// }
-		// deletingNamespace++
-	}
-
-	for vc, vcCli := range be.vcClients {
-		log.Printf("will delete namespace %s on vc %s", targetNs, vc)
-		if err := vcCli.Delete(context.TODO(), &v1.Namespace{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: targetNs,
-			},
-		}); err != nil {
-			log.Printf("fail to delete namespace %s on vc(%s): %s", targetNs, vc, err)
-		}
-	}
-	// for deletingNamespace > 0 {
-	// 	<-time.After(20 * time.Second)
-	// 	log.Printf("there are %d namespace left", deletingNamespace)
-	// 	for vc, vcCli := range be.vcClients {
-	// 		ns := v1.Namespace{}
-	// 		if err := vcCli.Get(context.TODO(), types.NamespacedName{
-	// 			Name:      DefaultBenchNamespace,
-	// 			Namespace: "default",
-	// 		}, &ns); err != nil {
-	// 			if apierrors.IsNotFound(err) {
-	// 				deletingNamespace--
-	// 				continue
-	// 			}
-	// 			log.Printf("fail to delete namespace(%s) for vc(%s)",
-	// 				DefaultBenchNamespace, vc)
-	// 			return
-	// 		} else {
-	// 			log.Printf("%s(%s) on vc(%s) is still exist",
-	// 				DefaultBenchNamespace, ns.Status.Phase, vc)
-	// 		}
-	// 	}
-	// }
-	// endTime := time.Now().Unix()
-	// log.Printf("deleting all namespaces took %d seconds", int(endTime-startTime))
-}
-
-func (be *BenchExecutor) DeletePods(cli client.Client, targetNs, vc string) {
-	var podLst v1.PodList
-	if err := cli.List(context.TODO(), &podLst, &client.ListOptions{
-		Namespace: targetNs,
-	}); err != nil && !apierrors.IsNotFound(err) {
-		log.Fatalf("fail to list pod in ns/%s for %s", targetNs, vc)
-	}
-	for _, pod := range podLst.Items {
-		if err := cli.Delete(context.TODO(), &pod); err != nil {
-			log.Fatalf("fail to delete pod/%s in ns/%s for %s",
-				pod.GetName(), targetNs, vc)
-		}
-	}
-	log.Printf("delete pods in ns/%s for %s", targetNs, vc)
-}
-
 func (be *BenchExecutor) SubmitPods(vc string, vcCli client.Client, tenant tenant.Tenant, wg *sync.WaitGroup) {
 	defer wg.Done()
 	log.Printf("[GOROUTINE] start submitting pod on vc(%s)", vc)
@@ -299,14 +327,16 @@ func (be *BenchExecutor) SubmitPods(vc string, vcCli client.Client, tenant tenan
 			Name: DefaultBenchNamespace,
 		},
 	}
+	// an existing namespace is not labeled, as it may be shared with others
+	setRunLabels(benchNs, be.RunID)
 	if err := vcCli.Create(context.TODO(), benchNs); err != nil && !apierrors.IsAlreadyExists(err) {
 		log.Printf("fail to create ns(%s) on vc(%s): %s", DefaultBenchNamespace, vc, err)
 		return
 	}
 	log.Printf("[GOROUTINE] benchmark namespace(%s) is created on vc(%s)",
 		DefaultBenchNamespace, vc)
-	log.Println("will sleep for 10 seconds to wait for sa been created")
-	<-time.After(time.Duration(10) * time.Second)
+	log.Printf("will sleep for %s to wait for sa been created", be.NamespaceWait)
+	<-time.After(be.NamespaceWait)
 	for i := 0; i < tenant.NumPods; i++ {
 		// subsitute rsrc yaml
 		podName := fmt.Sprintf("%s-%s-%s%d", vc, tenant.ID, defaultPodBaseName, i)
@@ -331,6 +361,7 @@ func (be *BenchExecutor) SubmitPods(vc string, vcCli client.Client, tenant tenan
 			log.Printf("[GOROUTINE] fail to submit pods on vc(%s): %s", vc, err)
 			return
 		}
+		setRunLabels(pod, be.RunID)
 		// submit rsrc
 		if err = vcCli.Create(context.TODO(), pod); err != nil {
 			log.Printf("[GOROUTINE] fail to submit pods on vc(%s): %s", vc, err)
@@ -342,6 +373,7 @@ func (be *BenchExecutor) SubmitPods(vc string, vcCli client.Client, tenant tenan
 		be.RuntimeStatics[podName] = &RuntimeStatics{
 			PodName:     podName,
 			ClusterName: vc,
+			TenantID:    tenant.ID,
 		}
 		if _, exist := be.waitingPodsOnVc[vc]; !exist {
 			be.waitingPodsOnVc[vc] = 1
@@ -355,6 +387,21 @@ func (be *BenchExecutor) SubmitPods(vc string, vcCli client.Client, tenant tenan
 }
 
 func (be *BenchExecutor) RunBench() error {
+	if be.Scraper != nil {
+		be.Scraper.Start()
+		defer be.Scraper.Stop()
+	}
+	if be.Events != nil {
+		if err := be.Events.Start(); err != nil {
+			return err
+		}
+		defer be.Events.Stop()
+	}
+	if be.Profiler != nil {
+		be.Profiler.Start()
+		defer be.Profiler.Stop()
+		be.Profiler.Capture("start")
+	}
 	// equally spread rsrc to each vc
 	var wg sync.WaitGroup
 	tenantCounter := 0
@@ -370,10 +417,13 @@ func (be *BenchExecutor) RunBench() error {
 	log.Printf("waiting for submitting pod on vc...")
 	wg.Wait()
 	log.Printf("all pod submitted to vc")
+	if be.Profiler != nil {
+		be.Profiler.Capture("submitted")
+	}
 
-	// periodically(20 seconds) check if resources are created
+	// periodically check if resources are created
 	for len(be.waitingPodsOnVc) > 0 {
-		<-time.After(20 * time.Second)
+		<-time.After(be.PollInterval)
 		log.Print("checking waiting pod status")
 		var remainingPods int
 		for vc := range be.waitingPodsOnVc {
@@ -386,21 +436,28 @@ func (be *BenchExecutor) RunBench() error {
 					DefaultBenchNamespace, vc, err)
 			}
 			for _, p := range pl.Items {
-				dwsdq, uwsdq, tct, sct, fut, srt := getTimeInfoOnSuper(p)
-				if _, exist := be.RuntimeStatics[p.GetName()]; !exist {
-					be.RuntimeStatics[p.GetName()] = &RuntimeStatics{}
+				tl, err := perftimestamp.ParseTimeline(&p)
+				if err != nil {
+					log.Printf("pod(%s) on vc(%s): %s", p.GetName(), vc, err)
+				}
+				rs, exist := be.RuntimeStatics[p.GetName()]
+				if !exist {
+					rs = &RuntimeStatics{}
+					be.RuntimeStatics[p.GetName()] = rs
 				}
-				be.RuntimeStatics[p.GetName()].DwsDequeue = dwsdq
-				be.RuntimeStatics[p.GetName()].UwsDequeue = uwsdq
-				be.RuntimeStatics[p.GetName()].TenantCreation = tct
-				be.RuntimeStatics[p.GetName()].SuperCreation = sct
-				be.RuntimeStatics[p.GetName()].SuperUpdate = fut
-				be.RuntimeStatics[p.GetName()].SuperReady = srt
-				if sct != 0 && fut != 0 && be.RuntimeStatics[p.GetName()].PodCreated == false {
-					// got all information, no need to poll the pod in
+				rs.SetTimeline(tl)
+				if tl.Has(perftimestamp.StageSuperCreation) && tl.Has(perftimestamp.StageFirstUpdate) && !rs.PodCreated {
+					// the pod is synced back, no need to poll the pod in
 					// the future
 					log.Printf("creation lifecycle of pod(%s) is complete", p.GetName())
-					be.RuntimeStatics[p.GetName()].PodCreated = true
+					if missing := tl.Missing(); len(missing) != 0 {
+						log.Printf("pod(%s) on vc(%s) misses stages %v", p.GetName(), vc, missing)
+					}
+					if err := tl.Validate(); err != nil {
+						log.Printf("pod(%s) on vc(%s): %s", p.GetName(), vc, err)
+					}
+					rs.PodCreated = true
+					be.Histograms.Record(rs)
 					be.waitingPodsOnVc[vc]--
 				}
 				if be.waitingPodsOnVc[vc] == 0 {
@@ -413,6 +470,9 @@ func (be *BenchExecutor) RunBench() error {
 		}
 		log.Printf("There are %d pods remain in total", remainingPods)
 	}
+	if be.Profiler != nil {
+		be.Profiler.Capture("complete")
+	}
 
 	// TODO clean up once done
 	// delete all pods
@@ -433,62 +493,6 @@ func (be *BenchExecutor) RunBench() error {
 	return nil
 }
 
-func getTimeInfoOnSuper(pod v1.Pod) (dwsdq, uwsdq, tct, sct, fut, srt int) {
-	tctt := pod.GetCreationTimestamp()
-	if tctt.IsZero() {
-		return
-	}
-	tct = int(tctt.Unix())
-
-	annos := pod.GetAnnotations()
-	dwsdqStr, exist := annos[constants.LabelPerfBenchDWSReconcileTime]
-	if !exist {
-		return
-	}
-	dwsdq, err := strconv.Atoi(dwsdqStr)
-	if err != nil {
-		return
-	}
-
-	uwsdqStr, exist := annos[constants.LabelPerfBenchUWSReconcileTime]
-	if !exist {
-		return
-	}
-	uwsdq, err = strconv.Atoi(uwsdqStr)
-	if err != nil {
-		return
-	}
-
-	futStr, exist := annos[constants.LabelPerfBenchFirstUpdateTime]
-	if !exist {
-		return
-	}
-	fut, err = strconv.Atoi(futStr)
-	if err != nil {
-		return
-	}
-
-	sctStr, exist := annos[constants.LabelPerfBenchSuperCreationTime]
-	if !exist {
-		return
-	}
-	sct, err = strconv.Atoi(sctStr)
-	if err != nil {
-		return
-	}
-
-	srtStr, exist := annos[constants.LabelPerfBenchSuperReadyTime]
-	if !exist {
-		return
-	}
-	srt, err = strconv.Atoi(srtStr)
-	if err != nil {
-		return
-	}
-
-	return
-}
-
 func fillOutTemplate(kubeConfigTmpl string, context interface{}) ([]byte, error) {
 	t, tmplPrsErr := template.New("test").Parse(kubeConfigTmpl)
 	if tmplPrsErr != nil {
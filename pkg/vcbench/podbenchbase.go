@@ -2,22 +2,55 @@ package vcbench
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"log"
+	"strings"
 	"sync"
 	"time"
 
 	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes/scheme"
-	"k8s.io/client-go/tools/clientcmd"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/charleszheng44/vc-bench/pkg/tenant"
+)
+
+const (
+	// labelBaseTenant is the tenant of a pod of the baseline benchmark
+	labelBaseTenant = "vcbench.io/tenant"
+
+	// ExistingNsFail, ExistingNsReuse and ExistingNsRecreate are the ways
+	// the baseline benchmark handles a benchmark namespace that already
+	// exists. "reuse" keeps the namespace but deletes the pods in it, while
+	// "recreate" deletes the namespace and waits for its termination.
+	ExistingNsFail     = "fail"
+	ExistingNsReuse    = "reuse"
+	ExistingNsRecreate = "recreate"
+
+	existingNsTimeout = 5 * time.Minute
+
+	// DefaultReadyTimeout is the default time waiting for the submitted
+	// pods to be Ready once the submission is done
+	DefaultReadyTimeout = 10 * time.Minute
 )
 
+// BasePodStatiscs holds the transition times (unix time in seconds) of the
+// conditions of a pod of the baseline benchmark, and the time the Ready pod
+// is first observed through the watch
 type BasePodStatiscs struct {
-	CreationTimestamp int64
-	ReadyTimestamp    int64
+	CreationTimestamp        int64
+	ScheduledTimestamp       int64
+	InitializedTimestamp     int64
+	ContainersReadyTimestamp int64
+	ReadyTimestamp           int64
+	ObservedReadyTimestamp   int64
+	TenantID                 string
 }
 
 type BaseBenchExecutor struct {
@@ -27,26 +60,52 @@ type BaseBenchExecutor struct {
 	PodInterval    int
 	RuntimeStatics map[string]*BasePodStatiscs
 	ShareNamespace bool
+	// RunID labels every object created by the run
+	RunID string
+	// ExistingNs is one of "fail", "reuse" or "recreate"
+	ExistingNs string
+	Histograms *StageHistograms
+	// TenantIdentity is one of "shared", "token" or "impersonate"
+	TenantIdentity string
+	// Tenants, if set, replaces the equal split of NumPod among NumTenants,
+	// tenant i submits Tenants[i].NumPods pods and is labeled by its ID
+	Tenants []tenant.Tenant
+	// APF, if not nil, maps the tenants to flows of API Priority and
+	// Fairness during the run
+	APF *APFConfig
+	// ReadyTimeout bounds the wait for the submitted pods to be Ready,
+	// starting when all tenants are done with the submission
+	ReadyTimeout time.Duration
+	// backend watches the benchmark pods and builds the clients of tenants,
+	// adminCli is used to create namespaces and the identities of tenants
+	backend    TenantMasterCluster
+	adminCli   client.Client
+	apfObjects []runtime.Object
+	// submitted are the keys of the pods created by the run
+	submittedMu sync.Mutex
+	submitted   []string
 }
 
 func NewBaseBenchExecutor(kubeconfigPath string, numPod, podInterval, numTenants int, shareNs bool) (*BaseBenchExecutor, error) {
+	backend, err := NewKubeBackend(kubeconfigPath)
+	if err != nil {
+		return nil, err
+	}
+	return NewBaseBenchExecutorWithBackend(backend, numPod, podInterval, numTenants, shareNs)
+}
+
+// NewBaseBenchExecutorWithBackend builds a BaseBenchExecutor running on the
+// tenant masters cluster of the backend
+func NewBaseBenchExecutorWithBackend(backend TenantMasterCluster, numPod, podInterval, numTenants int, shareNs bool) (*BaseBenchExecutor, error) {
 	if numPod < numTenants {
 		return nil, fmt.Errorf("numPod(%d) and numTenant(%d) is wrong", numPod, numTenants)
 	}
 
 	log.Printf("There are %d pods and %d tenants", numPod, numTenants)
 
-	kbCfgByts, err := ioutil.ReadFile(kubeconfigPath)
-	if err != nil {
-		return nil, err
-	}
-	kbCfg, err := clientcmd.RESTConfigFromKubeConfig(kbCfgByts)
-	if err != nil {
-		return nil, err
-	}
 	cliLst := []client.Client{}
 	for i := 0; i < numTenants; i++ {
-		cli, err := client.New(kbCfg, client.Options{Scheme: scheme.Scheme})
+		cli, err := backend.NewClient()
 		if err != nil {
 			return nil, err
 		}
@@ -60,23 +119,19 @@ func NewBaseBenchExecutor(kubeconfigPath string, numPod, podInterval, numTenants
 		PodInterval:    podInterval,
 		RuntimeStatics: make(map[string]*BasePodStatiscs),
 		ShareNamespace: shareNs,
+		RunID:          NewRunID(),
+		ExistingNs:     ExistingNsFail,
+		Histograms:     NewStageHistograms(),
+		TenantIdentity: TenantIdentityShared,
+		ReadyTimeout:   DefaultReadyTimeout,
+		backend:        backend,
+		adminCli:       backend.Client(),
 	}, nil
 }
 
-func (bbe *BaseBenchExecutor) SubmitPods(cli client.Client, numPod, tenantId int, wg *sync.WaitGroup) error {
-	defer wg.Done()
-	if !bbe.ShareNamespace {
-		// create namespace
-		tenantNs := fmt.Sprintf("%s-%d", DefaultBenchNamespace, tenantId)
-		if err := cli.Create(context.TODO(), &v1.Namespace{
-			ObjectMeta: metav1.ObjectMeta{
-				Name: tenantNs,
-			},
-		}); err != nil {
-			return err
-		}
-		log.Printf("benchmark namespace %s is created", tenantNs)
-	}
+// SubmitPods submits the pods of the tenant, it returns the number of pods
+// created before the first failure
+func (bbe *BaseBenchExecutor) SubmitPods(cli client.Client, numPod, tenantId int) (int, error) {
 	for i := 1; i <= numPod; i++ {
 		podName := fmt.Sprintf("%s%d", defaultPodBaseName, i)
 		ctx := map[string]string{
@@ -91,92 +146,335 @@ func (bbe *BaseBenchExecutor) SubmitPods(cli client.Client, numPod, tenantId int
 		podYaml, err := fillOutTemplate(defaultPodTemp, ctx)
 		if err != nil {
 			log.Printf("fail to submit pods(%s) by tenant(%d): %s", podName, tenantId, err)
-			return err
+			return i - 1, err
 		}
 
 		obj, err := yamlBytsToObject(scheme.Scheme, podYaml)
 		if err != nil {
 			log.Printf("fail to submit pods(%s) by tenant(%d): %s", podName, tenantId, err)
-			return err
+			return i - 1, err
 		}
 
 		pod, ok := obj.(*v1.Pod)
 		if !ok {
 			log.Printf("fail to assert pod(%s) by tenant(%d)", podName, tenantId)
-			return err
+			return i - 1, err
 		}
+		setRunLabels(pod, bbe.RunID)
+		pod.Labels[labelBaseTenant] = bbe.tenantID(tenantId)
 		if err = cli.Create(context.TODO(), pod); err != nil {
 			log.Printf("fail to submit pod(%s) by tenant(%d): %s", podName, tenantId, err)
-			return err
+			return i - 1, err
 		}
+		bbe.submittedMu.Lock()
+		bbe.submitted = append(bbe.submitted, bbe.podKey(pod.GetNamespace(), pod.GetName()))
+		bbe.submittedMu.Unlock()
 		log.Printf("pod(%s) submitted by tenant %d", podName, tenantId)
 		<-time.After(time.Duration(bbe.PodInterval) * time.Second)
 	}
-	return nil
+	return numPod, nil
+}
+
+// createNamespace creates the benchmark namespace, an existing one is
+// handled according to bbe.ExistingNs
+func (bbe *BaseBenchExecutor) createNamespace(cli client.Client, ns *v1.Namespace) error {
+	setRunLabels(ns, bbe.RunID)
+	err := cli.Create(context.TODO(), ns)
+	if !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+	switch bbe.ExistingNs {
+	case ExistingNsReuse:
+		log.Printf("reuse the existing namespace %s", ns.GetName())
+		if err := DeletePods(cli, ns.GetName(), "base"); err != nil {
+			return err
+		}
+		// pods of the new run have the same names as the terminating ones
+		deadline := time.Now().Add(existingNsTimeout)
+		for {
+			pl := &v1.PodList{}
+			if err := cli.List(context.TODO(), pl, client.InNamespace(ns.GetName())); err != nil {
+				return err
+			}
+			if len(pl.Items) == 0 {
+				return nil
+			}
+			if time.Now().After(deadline) {
+				return fmt.Errorf("%d pods in %s are not removed in %s", len(pl.Items), ns.GetName(), existingNsTimeout)
+			}
+			time.Sleep(time.Second)
+		}
+	case ExistingNsRecreate:
+		log.Printf("recreate the existing namespace %s", ns.GetName())
+		if err := deleteNamespace(cli, ns.GetName(), "base"); err != nil {
+			return err
+		}
+		if leftovers := waitNamespacesGone(cli, []string{ns.GetName()},
+			time.Now().Add(existingNsTimeout), time.Second); len(leftovers) != 0 {
+			return fmt.Errorf("%s is not removed in %s", leftovers[0], existingNsTimeout)
+		}
+		ns.SetResourceVersion("")
+		return cli.Create(context.TODO(), ns)
+	default:
+		return err
+	}
 }
 
 func (bbe *BaseBenchExecutor) RunBaseBench() error {
 
 	// submit pods
-	waitingPods := (bbe.NumPod / bbe.NumTenants) * bbe.NumTenants
-	podPerTenant := bbe.NumPod / bbe.NumTenants
+	waitingPods := 0
+	for i := range bbe.CliLst {
+		waitingPods += bbe.tenantNumPods(i)
+	}
 
 	if bbe.ShareNamespace {
-		sharedNs := DefaultBenchNamespace
-		if err := bbe.CliLst[0].Create(context.TODO(), &v1.Namespace{
+		sharedNs := &v1.Namespace{
 			ObjectMeta: metav1.ObjectMeta{
-				Name: sharedNs,
+				Name: DefaultBenchNamespace,
 			},
-		}); err != nil {
+		}
+		if err := bbe.createNamespace(bbe.adminCli, sharedNs); err != nil {
+			return err
+		}
+	}
+	for i := range bbe.CliLst {
+		if err := bbe.prepareTenant(i); err != nil {
+			return err
+		}
+	}
+	if bbe.APF != nil {
+		defer bbe.removeAPF()
+		if err := bbe.setupAPF(); err != nil {
 			return err
 		}
 	}
 
-	var wg sync.WaitGroup
+	// the watch is established before the submission, so that the time
+	// pods become Ready can be observed
+	w, err := bbe.watchPods()
+	if err != nil {
+		return err
+	}
+	// the pods failed to be created are not waited for
+	unsubmitted := make(chan int, len(bbe.CliLst))
+	done := make(chan error)
+	go func() {
+		done <- bbe.waitForPods(w, waitingPods, unsubmitted)
+	}()
+
+	var (
+		wg         sync.WaitGroup
+		submitErrs = make([]error, len(bbe.CliLst))
+	)
 	for i := range bbe.CliLst {
 		wg.Add(1)
-		go bbe.SubmitPods(bbe.CliLst[i], podPerTenant, i, &wg)
+		go func(i int) {
+			defer wg.Done()
+			numPods := bbe.tenantNumPods(i)
+			created, err := bbe.SubmitPods(bbe.CliLst[i], numPods, i)
+			if err != nil {
+				submitErrs[i] = fmt.Errorf("tenant(%d): %d of %d pods are not submitted: %s", i, numPods-created, numPods, err)
+				unsubmitted <- numPods - created
+			}
+		}(i)
 	}
 	wg.Wait()
+	close(unsubmitted)
 	log.Printf("all pods submitted")
+	waitErr := <-done
 
-	// periodically check pod status
-CHECKPOD:
-	for waitingPods != 0 {
-		log.Printf("checking waiting pods, there are %d pod remaining", waitingPods)
-		<-time.After(20 * time.Second)
-		for i := 0; i < bbe.NumTenants; i++ {
-			tnNs := DefaultBenchNamespace
-			if !bbe.ShareNamespace {
-				tnNs = fmt.Sprintf("%s-%d", DefaultBenchNamespace, i)
+	var errMsgs []string
+	for _, err := range submitErrs {
+		if err != nil {
+			errMsgs = append(errMsgs, err.Error())
+		}
+	}
+	if waitErr != nil {
+		errMsgs = append(errMsgs, waitErr.Error())
+	}
+	if len(errMsgs) != 0 {
+		return errors.New(strings.Join(errMsgs, "; "))
+	}
+	return nil
+}
+
+// prepareTenant creates the namespace of the tenant, unless the namespace
+// is shared, and replaces the client of the tenant with one acting as its
+// own ServiceAccount if TenantIdentity is not "shared"
+func (bbe *BaseBenchExecutor) prepareTenant(tenantId int) error {
+	tenantNs := bbe.tenantNamespace(tenantId)
+	if !bbe.ShareNamespace {
+		ns := &v1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: tenantNs,
+			},
+		}
+		if err := bbe.createNamespace(bbe.adminCli, ns); err != nil {
+			return err
+		}
+		log.Printf("benchmark namespace %s is created", tenantNs)
+	}
+	if bbe.TenantIdentity == "" || bbe.TenantIdentity == TenantIdentityShared {
+		return nil
+	}
+	if bbe.backend.RestConfig() == nil {
+		return fmt.Errorf("tenant identity %q requires a cluster served by an apiserver", bbe.TenantIdentity)
+	}
+	cfg, err := setupTenantIdentity(bbe.adminCli, bbe.backend.RestConfig(), bbe.TenantIdentity,
+		tenantNs, baseTenantID(tenantId), bbe.RunID)
+	if err != nil {
+		return err
+	}
+	cli, err := client.New(cfg, client.Options{Scheme: scheme.Scheme})
+	if err != nil {
+		return err
+	}
+	bbe.CliLst[tenantId] = cli
+	return nil
+}
+
+// tenantNamespace returns the namespace of the pods of the tenant
+func (bbe *BaseBenchExecutor) tenantNamespace(tenantId int) string {
+	if bbe.ShareNamespace {
+		return DefaultBenchNamespace
+	}
+	return fmt.Sprintf("%s-%d", DefaultBenchNamespace, tenantId)
+}
+
+// tenantNumPods returns the number of pods submitted by the tenant
+func (bbe *BaseBenchExecutor) tenantNumPods(tenantId int) int {
+	if len(bbe.Tenants) != 0 {
+		return bbe.Tenants[tenantId].NumPods
+	}
+	return bbe.NumPod / bbe.NumTenants
+}
+
+// tenantID returns the id of the tenant recorded with its pods
+func (bbe *BaseBenchExecutor) tenantID(tenantId int) string {
+	if len(bbe.Tenants) != 0 {
+		return bbe.Tenants[tenantId].ID
+	}
+	return baseTenantID(tenantId)
+}
+
+func baseTenantID(tenantId int) string {
+	return fmt.Sprintf("tenant%d", tenantId)
+}
+
+// watchPods watches the pods created by the run in all namespaces
+func (bbe *BaseBenchExecutor) watchPods() (watch.Interface, error) {
+	return bbe.backend.WatchPods(labels.SelectorFromSet(labels.Set{LabelRunID: bbe.RunID}))
+}
+
+// waitForPods records the pods observed to be Ready until all waiting pods
+// are Ready, the watch is re-established if it is closed by the apiserver.
+// Pods received from unsubmitted are not waited for, and once it is closed,
+// the pods that are not Ready in ReadyTimeout are reported by the error.
+func (bbe *BaseBenchExecutor) waitForPods(w watch.Interface, waitingPods int, unsubmitted <-chan int) error {
+	defer func() {
+		if w != nil {
+			w.Stop()
+		}
+	}()
+	var timeout <-chan time.Time
+	for waitingPods > 0 {
+		var (
+			events <-chan watch.Event
+			retry  <-chan time.Time
+		)
+		if w != nil {
+			events = w.ResultChan()
+		} else {
+			retry = time.After(time.Second)
+		}
+		select {
+		case n, ok := <-unsubmitted:
+			if !ok {
+				unsubmitted = nil
+				timeout = time.After(bbe.ReadyTimeout)
+				continue
 			}
-			log.Printf("check pod status in namespace(%s)", tnNs)
-			pl := &v1.PodList{}
-			if err := bbe.CliLst[0].List(context.TODO(), pl, client.InNamespace(tnNs)); err != nil {
-				log.Printf("fail to list pod: %s", err)
+			waitingPods -= n
+		case ev, ok := <-events:
+			if !ok {
+				w.Stop()
+				w = nil
+				log.Printf("watch is closed, there are %d pod remaining", waitingPods)
+				continue
 			}
-			for _, p := range pl.Items {
-				log.Printf("checking pod(%s)", p.GetName())
-				tmpPodName := p.GetName()
-				if !bbe.ShareNamespace {
-					tmpPodName = fmt.Sprintf("%s-%s", tnNs, p.GetName())
-				}
-				if p.Status.Phase == v1.PodRunning {
-					if _, exist := bbe.RuntimeStatics[tmpPodName]; exist {
-						continue
-					}
-					log.Printf("new %s/pod(%s) finished", tnNs, p.GetName())
-					bbe.RuntimeStatics[tmpPodName] = &BasePodStatiscs{
-						CreationTimestamp: p.GetCreationTimestamp().Unix(),
-						ReadyTimestamp:    p.Status.Conditions[0].LastTransitionTime.Unix(),
-					}
-					waitingPods--
-					if waitingPods == 0 {
-						break CHECKPOD
-					}
+			pod, ok := ev.Object.(*v1.Pod)
+			if !ok || !bbe.recordPod(pod, time.Now().Unix()) {
+				continue
+			}
+			waitingPods--
+			if waitingPods%100 == 0 {
+				log.Printf("there are %d pod remaining", waitingPods)
+			}
+		case <-retry:
+			var err error
+			if w, err = bbe.watchPods(); err != nil {
+				log.Printf("fail to watch pods: %s", err)
+				w = nil
+			}
+		case <-timeout:
+			var notReady []string
+			bbe.submittedMu.Lock()
+			defer bbe.submittedMu.Unlock()
+			for _, podKey := range bbe.submitted {
+				if _, exist := bbe.RuntimeStatics[podKey]; !exist {
+					notReady = append(notReady, podKey)
 				}
 			}
+			return fmt.Errorf("%d pods are not ready in %s: %s", len(notReady), bbe.ReadyTimeout, strings.Join(notReady, ","))
 		}
 	}
 	return nil
 }
+
+// podKey is the key of the pod in RuntimeStatics
+func (bbe *BaseBenchExecutor) podKey(namespace, name string) string {
+	if bbe.ShareNamespace {
+		return name
+	}
+	return fmt.Sprintf("%s-%s", namespace, name)
+}
+
+// recordPod records the transition times of the conditions of a Ready pod,
+// it returns false if the pod is not Ready or has been recorded
+func (bbe *BaseBenchExecutor) recordPod(pod *v1.Pod, observedAt int64) bool {
+	podKey := bbe.podKey(pod.GetNamespace(), pod.GetName())
+	if _, exist := bbe.RuntimeStatics[podKey]; exist {
+		return false
+	}
+	bs := &BasePodStatiscs{
+		CreationTimestamp: pod.GetCreationTimestamp().Unix(),
+		TenantID:          pod.GetLabels()[labelBaseTenant],
+	}
+	ready := false
+	for _, c := range pod.Status.Conditions {
+		if c.Status != v1.ConditionTrue {
+			continue
+		}
+		ts := c.LastTransitionTime.Unix()
+		switch c.Type {
+		case v1.PodScheduled:
+			bs.ScheduledTimestamp = ts
+		case v1.PodInitialized:
+			bs.InitializedTimestamp = ts
+		case v1.ContainersReady:
+			bs.ContainersReadyTimestamp = ts
+		case v1.PodReady:
+			bs.ReadyTimestamp = ts
+			ready = true
+		}
+	}
+	if !ready {
+		return false
+	}
+	bs.ObservedReadyTimestamp = observedAt
+	log.Printf("new %s/pod(%s) is ready", pod.GetNamespace(), pod.GetName())
+	bbe.RuntimeStatics[podKey] = bs
+	bbe.Histograms.RecordBase(bs)
+	return true
+}
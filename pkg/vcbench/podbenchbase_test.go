@@ -0,0 +1,149 @@
+package vcbench
+
+import (
+	"context"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCreateExistingNamespace(t *testing.T) {
+	for _, c := range []struct {
+		existingNs  string
+		expectErr   bool
+		expectLabel bool
+	}{
+		{existingNs: ExistingNsFail, expectErr: true},
+		{existingNs: ExistingNsReuse},
+		{existingNs: ExistingNsRecreate, expectLabel: true},
+	} {
+		cli := fake.NewFakeClientWithScheme(scheme.Scheme,
+			&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: DefaultBenchNamespace}},
+			&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod1", Namespace: DefaultBenchNamespace}},
+		)
+		bbe := &BaseBenchExecutor{RunID: "r", ExistingNs: c.existingNs}
+		err := bbe.createNamespace(cli, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: DefaultBenchNamespace}})
+		if (err != nil) != c.expectErr {
+			t.Fatalf("%s: unexpected error %v", c.existingNs, err)
+		}
+		if c.expectErr {
+			continue
+		}
+		pl := &v1.PodList{}
+		if err := cli.List(context.TODO(), pl); err != nil {
+			t.Fatalf("%s: fail to list pods: %s", c.existingNs, err)
+		}
+		if len(pl.Items) != 0 {
+			t.Errorf("%s: expect pods to be removed, got %d", c.existingNs, len(pl.Items))
+		}
+		ns := &v1.Namespace{}
+		if err := cli.Get(context.TODO(), types.NamespacedName{Name: DefaultBenchNamespace}, ns); err != nil {
+			t.Fatalf("%s: fail to get namespace: %s", c.existingNs, err)
+		}
+		if (ns.GetLabels()[LabelRunID] == "r") != c.expectLabel {
+			t.Errorf("%s: unexpected labels %v", c.existingNs, ns.GetLabels())
+		}
+	}
+}
+
+func TestWaitForPodsRecordsStages(t *testing.T) {
+	cs := kubefake.NewSimpleClientset()
+	bbe := &BaseBenchExecutor{
+		RunID:          "r",
+		ShareNamespace: true,
+		RuntimeStatics: make(map[string]*BasePodStatiscs),
+		Histograms:     NewStageHistograms(),
+		backend:        &KubeBackend{clientset: cs},
+	}
+	w, err := bbe.watchPods()
+	if err != nil {
+		t.Fatalf("fail to watch pods: %s", err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- bbe.waitForPods(w, 1, nil)
+	}()
+
+	condition := func(typ v1.PodConditionType, sec int64) v1.PodCondition {
+		return v1.PodCondition{Type: typ, Status: v1.ConditionTrue,
+			LastTransitionTime: metav1.Unix(sec, 0)}
+	}
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "tenant0-pod1", Namespace: DefaultBenchNamespace,
+			CreationTimestamp: metav1.Unix(100, 0),
+			Labels:            map[string]string{LabelRunID: "r", labelBaseTenant: "tenant0"}},
+		Status: v1.PodStatus{Conditions: []v1.PodCondition{condition(v1.PodScheduled, 101)}},
+	}
+	// a pod without the Ready condition is not recorded
+	if _, err := cs.CoreV1().Pods(DefaultBenchNamespace).Create(context.TODO(), pod, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("fail to create pod: %s", err)
+	}
+	pod.Status.Conditions = []v1.PodCondition{
+		condition(v1.PodReady, 105),
+		condition(v1.PodScheduled, 101),
+		condition(v1.PodInitialized, 102),
+		condition(v1.ContainersReady, 104),
+	}
+	if _, err := cs.CoreV1().Pods(DefaultBenchNamespace).Update(context.TODO(), pod, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("fail to update pod: %s", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("fail to wait for pods: %s", err)
+	}
+
+	bs, exist := bbe.RuntimeStatics["tenant0-pod1"]
+	if !exist {
+		t.Fatalf("pod is not recorded")
+	}
+	if bs.ScheduledTimestamp != 101 || bs.InitializedTimestamp != 102 ||
+		bs.ContainersReadyTimestamp != 104 || bs.ReadyTimestamp != 105 ||
+		bs.ObservedReadyTimestamp == 0 || bs.TenantID != "tenant0" {
+		t.Fatalf("unexpected statics %+v", bs)
+	}
+	if h := bbe.Histograms.Scopes[TenantScope("tenant0")]["containersStarting"]; h == nil || h.Max() != 2000 {
+		t.Fatalf("containersStarting is not recorded")
+	}
+}
+
+func TestSetupTenantIdentity(t *testing.T) {
+	adminCfg := &rest.Config{Host: "https://127.0.0.1:6443", BearerToken: "admin"}
+	ns := "podbench-0"
+	cli := fake.NewFakeClientWithScheme(scheme.Scheme,
+		// the token controller has populated the token of the serviceaccount
+		&v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "vcbench-tenant0", Namespace: ns},
+			Secrets: []v1.ObjectReference{{Name: "vcbench-tenant0-token-x"}}},
+		&v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "vcbench-tenant0-token-x", Namespace: ns},
+			Type: v1.SecretTypeServiceAccountToken,
+			Data: map[string][]byte{v1.ServiceAccountTokenKey: []byte("tenant0-token")}},
+	)
+
+	cfg, err := setupTenantIdentity(cli, adminCfg, TenantIdentityToken, ns, "tenant0", "r")
+	if err != nil {
+		t.Fatalf("fail to set up token identity: %s", err)
+	}
+	if cfg.BearerToken != "tenant0-token" || cfg.Host != adminCfg.Host {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	rb := &rbacv1.RoleBinding{}
+	if err := cli.Get(context.TODO(), types.NamespacedName{Namespace: ns, Name: "vcbench-tenant0"}, rb); err != nil {
+		t.Fatalf("rolebinding is not created: %s", err)
+	}
+	if rb.Subjects[0].Name != "vcbench-tenant0" || rb.RoleRef.Name != "vcbench-tenant0" {
+		t.Fatalf("unexpected rolebinding %+v", rb)
+	}
+
+	cfg, err = setupTenantIdentity(cli, adminCfg, TenantIdentityImpersonate, "podbench", "tenant1", "r")
+	if err != nil {
+		t.Fatalf("fail to set up impersonation: %s", err)
+	}
+	if cfg.Impersonate.UserName != "system:serviceaccount:podbench:vcbench-tenant1" || cfg.BearerToken != "admin" {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+}
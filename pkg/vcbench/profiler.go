@@ -0,0 +1,244 @@
+package vcbench
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	// ProfileCPU, ProfileHeap and ProfileGoroutine are the pprof profiles
+	// that can be captured
+	ProfileCPU       = "profile"
+	ProfileHeap      = "heap"
+	ProfileGoroutine = "goroutine"
+
+	// PhasePeriodic is the phase of profiles captured at intervals
+	PhasePeriodic = "periodic"
+
+	profileDir            = "profiles"
+	defaultProfileSeconds = 30
+)
+
+// ProfileTarget is a process exposing /debug/pprof. The address, TLS,
+// credentials and discovery are configured as for a ScrapeTarget, Addr
+// being the base url of the process (e.g. host:6060). Profiles are taken
+// every Interval seconds, if Interval is larger than 0, and at every phase
+// boundary of the benchmark.
+type ProfileTarget struct {
+	ScrapeTarget `yaml:",inline"`
+	// Profiles to capture, default to all of profile, heap and goroutine
+	Profiles []string `yaml:"profiles"`
+	// Seconds is the duration of the CPU profile
+	Seconds int `yaml:"seconds"`
+}
+
+// ProfileConfig lists the targets to be profiled during a run
+type ProfileConfig struct {
+	Targets []ProfileTarget `yaml:"targets"`
+}
+
+// LoadProfileConfig reads the profile targets from a yaml file
+func LoadProfileConfig(cfgPath string) (*ProfileConfig, error) {
+	byts, err := ioutil.ReadFile(cfgPath)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &ProfileConfig{}
+	if err := yaml.UnmarshalStrict(byts, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Profiler captures pprof profiles from targets and stores them in
+// <outDataDir>/profiles/<target>-<profile>-<phase>-<unix time>.pb.gz, so
+// that they can be lined up with the latency timelines
+type Profiler struct {
+	OutDataDir string
+	Targets    []ProfileTarget
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+
+	// cpuLocks serializes the CPU profiles of each target, keyed by the
+	// address, as pprof rejects a CPU profile while another one is running
+	cpuLocksMu sync.Mutex
+	cpuLocks   map[string]*sync.Mutex
+}
+
+// NewProfiler validates the targets and fills in the defaults
+func NewProfiler(outDataDir string, targets []ProfileTarget) (*Profiler, error) {
+	var resolved []ProfileTarget
+	for _, pt := range targets {
+		if len(pt.Profiles) == 0 {
+			pt.Profiles = []string{ProfileCPU, ProfileHeap, ProfileGoroutine}
+		}
+		for _, p := range pt.Profiles {
+			if p != ProfileCPU && p != ProfileHeap && p != ProfileGoroutine {
+				return nil, fmt.Errorf("profile target %s: unknown profile %s", pt.Name, p)
+			}
+		}
+		if pt.Seconds <= 0 {
+			pt.Seconds = defaultProfileSeconds
+		}
+		if pt.Timeout <= 0 {
+			pt.Timeout = pt.Seconds + defaultScrapeTimeout
+		}
+		if pt.Discovery != nil && pt.Discovery.Path == "" {
+			pt.Discovery.Path = "/"
+		}
+		// a target with discovery may be resolved into multiple ones
+		sts, err := resolveTargets([]ScrapeTarget{pt.ScrapeTarget}, "/")
+		if err != nil {
+			return nil, err
+		}
+		for _, st := range sts {
+			rpt := pt
+			rpt.ScrapeTarget = st
+			resolved = append(resolved, rpt)
+		}
+	}
+	if err := os.MkdirAll(path.Join(outDataDir, profileDir), os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &Profiler{
+		OutDataDir: outDataDir,
+		Targets:    resolved,
+	}, nil
+}
+
+// Start starts capturing profiles periodically from targets with an
+// interval
+func (p *Profiler) Start() {
+	p.stop = make(chan struct{})
+	for _, pt := range p.Targets {
+		if pt.Interval <= 0 {
+			continue
+		}
+		p.wg.Add(1)
+		go func(pt ProfileTarget) {
+			defer p.wg.Done()
+			for {
+				select {
+				case <-p.stop:
+					return
+				case <-time.After(time.Duration(pt.Interval) * time.Second):
+					p.capture(pt, PhasePeriodic)
+				}
+			}
+		}(pt)
+	}
+}
+
+// Capture captures all profiles of all targets at a phase boundary (e.g.
+// "submitted") in the background
+func (p *Profiler) Capture(phase string) {
+	log.Printf("capturing profiles at phase %s", phase)
+	for _, pt := range p.Targets {
+		p.wg.Add(1)
+		go func(pt ProfileTarget) {
+			defer p.wg.Done()
+			p.capture(pt, phase)
+		}(pt)
+	}
+}
+
+// Stop stops the periodic captures and waits for ongoing ones
+func (p *Profiler) Stop() {
+	if p.stop != nil {
+		close(p.stop)
+		p.stop = nil
+	}
+	p.wg.Wait()
+}
+
+// capture downloads the profiles of the target, all of them are named after
+// the time capture is called. The instantaneous profiles (i.e., heap and
+// goroutine) are taken before the CPU profile, which lasts pt.Seconds and
+// may wait for an ongoing one, so that they reflect the moment of capture.
+func (p *Profiler) capture(pt ProfileTarget, phase string) {
+	ts := time.Now().Unix()
+	cli, err := pt.httpClient()
+	if err != nil {
+		log.Printf("fail to profile %s: %s", pt.Name, err)
+		return
+	}
+	var profiles []string
+	for _, profile := range pt.Profiles {
+		if profile != ProfileCPU {
+			profiles = append(profiles, profile)
+		}
+	}
+	if len(profiles) != len(pt.Profiles) {
+		profiles = append(profiles, ProfileCPU)
+	}
+	for _, profile := range profiles {
+		url := strings.TrimSuffix(pt.Addr, "/") + "/debug/pprof/" + profile
+		if profile == ProfileCPU {
+			url = fmt.Sprintf("%s?seconds=%d", url, pt.Seconds)
+		}
+		if err := p.download(cli, pt, profile, phase, url, ts); err != nil {
+			log.Printf("fail to profile %s(%s): %s", pt.Name, profile, err)
+			continue
+		}
+	}
+}
+
+// download stores the profile of the target as taken at ts, a CPU profile
+// waits for the ongoing one of the same target to finish
+func (p *Profiler) download(cli *http.Client, pt ProfileTarget, profile, phase, url string, ts int64) error {
+	if profile == ProfileCPU {
+		l := p.cpuLock(pt.Addr)
+		l.Lock()
+		defer l.Unlock()
+	}
+	fileName := fmt.Sprintf("%s-%s-%s-%d.pb.gz", pt.Name, profile, phase, ts)
+	if err := downloadProfile(cli, url, path.Join(p.OutDataDir, profileDir, fileName)); err != nil {
+		return err
+	}
+	log.Printf("%s profile of %s is stored in %s", profile, pt.Name, fileName)
+	return nil
+}
+
+func (p *Profiler) cpuLock(addr string) *sync.Mutex {
+	p.cpuLocksMu.Lock()
+	defer p.cpuLocksMu.Unlock()
+	if p.cpuLocks == nil {
+		p.cpuLocks = make(map[string]*sync.Mutex)
+	}
+	if _, exist := p.cpuLocks[addr]; !exist {
+		p.cpuLocks[addr] = &sync.Mutex{}
+	}
+	return p.cpuLocks[addr]
+}
+
+func downloadProfile(cli *http.Client, url, dst string) error {
+	rep, err := cli.Get(url)
+	if err != nil {
+		return err
+	}
+	defer rep.Body.Close()
+	if rep.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", rep.Status)
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, rep.Body); err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
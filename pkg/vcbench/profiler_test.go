@@ -0,0 +1,102 @@
+package vcbench
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProfilerCapturesPhases(t *testing.T) {
+	// like pprof, reject a CPU profile while another one is running
+	var (
+		cpuProfiling int32
+		firstOnce    sync.Once
+		first        string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		firstOnce.Do(func() { first = r.URL.Path })
+		switch r.URL.Path {
+		case "/debug/pprof/profile":
+			if r.URL.Query().Get("seconds") != "1" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if !atomic.CompareAndSwapInt32(&cpuProfiling, 0, 1) {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			defer atomic.StoreInt32(&cpuProfiling, 0)
+			time.Sleep(50 * time.Millisecond)
+		case "/debug/pprof/heap", "/debug/pprof/goroutine":
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+
+	outDataDir, err := ioutil.TempDir("", "profiler")
+	if err != nil {
+		t.Fatalf("fail to create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(outDataDir)
+
+	if _, err := NewProfiler(outDataDir, []ProfileTarget{{
+		ScrapeTarget: ScrapeTarget{Name: "test", Addr: srv.URL},
+		Profiles:     []string{"cpu"},
+	}}); err == nil {
+		t.Fatalf("expect error for unknown profile")
+	}
+
+	p, err := NewProfiler(outDataDir, []ProfileTarget{{
+		ScrapeTarget: ScrapeTarget{Name: "test", Addr: srv.URL},
+		Seconds:      1,
+	}})
+	if err != nil {
+		t.Fatalf("fail to create profiler: %s", err)
+	}
+	p.Start()
+	p.Capture("start")
+	p.Capture("complete")
+	p.Stop()
+
+	fis, err := ioutil.ReadDir(path.Join(outDataDir, profileDir))
+	if err != nil {
+		t.Fatalf("fail to read profile dir: %s", err)
+	}
+	got := make(map[string]bool)
+	stamps := make(map[string]map[string]bool)
+	for _, fi := range fis {
+		parts := strings.Split(fi.Name(), "-")
+		got[strings.Join(parts[:3], "-")] = true
+		if stamps[parts[2]] == nil {
+			stamps[parts[2]] = make(map[string]bool)
+		}
+		stamps[parts[2]][parts[3]] = true
+	}
+	// the instantaneous profiles are taken before the CPU profile, and all
+	// profiles of a capture are named after the same time
+	if first == "/debug/pprof/profile" {
+		t.Errorf("expect the heap and goroutine profiles to be taken first")
+	}
+	for _, phase := range []string{"start", "complete"} {
+		if len(stamps[phase]) != 1 {
+			t.Errorf("expect the profiles of %s to share a timestamp, got %v", phase, stamps[phase])
+		}
+	}
+	for _, phase := range []string{"start", "complete"} {
+		for _, profile := range []string{ProfileCPU, ProfileHeap, ProfileGoroutine} {
+			if name := "test-" + profile + "-" + phase; !got[name] {
+				t.Errorf("profile %s is not captured, got %v", name, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,302 @@
+package vcbench
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	tenancyv1alpha1 "sigs.k8s.io/multi-tenancy/incubator/virtualcluster/pkg/apis/tenancy/v1alpha1"
+)
+
+const (
+	// DefaultProvisionWorkers and DefaultProvisionTimeout are the defaults
+	// of ProvisionOptions
+	DefaultProvisionWorkers = 5
+	DefaultProvisionTimeout = 10 * time.Minute
+
+	// DefaultVCPrefix and DefaultVCNamespacePrefix name the i-th vc
+	// <prefix><i> in the namespace <namespacePrefix><i>
+	DefaultVCPrefix          = "vc"
+	DefaultVCNamespacePrefix = "t"
+
+	// annotationVCID is the index of the vc
+	annotationVCID = "sync-perf/vcid"
+)
+
+// ProvisionOptions configures ProvisionVCs and DeprovisionVCs, which
+// handle the vc with indexes from Start to Start+Count-1
+type ProvisionOptions struct {
+	Count int
+	// Start is the index of the first vc, default to 1
+	Start           int
+	Prefix          string
+	NamespacePrefix string
+	// ClusterVersion is the ClusterVersion of created vc
+	ClusterVersion string
+	// Workers is the number of vc handled concurrently
+	Workers int
+	// Timeout bounds the time waiting for each vc to be running, or to be
+	// removed
+	Timeout time.Duration
+	// PollInterval is the interval of checking the vc
+	PollInterval time.Duration
+}
+
+// setDefaults validates opts and fills in the defaults
+func (opts *ProvisionOptions) setDefaults() error {
+	if opts.Count < 0 {
+		return fmt.Errorf("invalid count %d", opts.Count)
+	}
+	if opts.Start <= 0 {
+		opts.Start = 1
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultVCPrefix
+	}
+	if opts.NamespacePrefix == "" {
+		opts.NamespacePrefix = DefaultVCNamespacePrefix
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultProvisionWorkers
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultProvisionTimeout
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 5 * time.Second
+	}
+	return nil
+}
+
+// VCProvision is the outcome of provisioning or deprovisioning a vc
+type VCProvision struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	// Existed is true if the vc existed before provisioning or
+	// deprovisioning
+	Existed bool `json:"existed"`
+	// Phase is the last observed phase of the vc
+	Phase tenancyv1alpha1.ClusterPhase `json:"phase,omitempty"`
+	// Created is the time the vc is created, and Running is the time the vc
+	// is first observed in the Running phase
+	Created time.Time `json:"created,omitempty"`
+	Running time.Time `json:"running,omitempty"`
+	// Duration is the time provisioning or deprovisioning the vc took
+	Duration time.Duration `json:"duration"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// ProvisionResult is the outcome of ProvisionVCs or DeprovisionVCs, VCs
+// are sorted by index
+type ProvisionResult struct {
+	Duration time.Duration  `json:"duration"`
+	VCs      []*VCProvision `json:"vcs"`
+}
+
+// Failed returns the vc that are not provisioned or deprovisioned
+func (pr *ProvisionResult) Failed() []*VCProvision {
+	var ret []*VCProvision
+	for _, vp := range pr.VCs {
+		if vp.Error != "" {
+			ret = append(ret, vp)
+		}
+	}
+	return ret
+}
+
+// newVirtualCluster returns the i-th vc of the ClusterVersion, annotated
+// with its index
+func newVirtualCluster(opts ProvisionOptions, idx int) *tenancyv1alpha1.VirtualCluster {
+	vc := &tenancyv1alpha1.VirtualCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("%s%d", opts.Prefix, idx),
+			Namespace:   fmt.Sprintf("%s%d", opts.NamespacePrefix, idx),
+			Annotations: map[string]string{annotationVCID: strconv.Itoa(idx)},
+			Labels: map[string]string{
+				"controller-tools.k8s.io": "1.0",
+				LabelManagedBy:            ManagedByVcbench,
+			},
+		},
+		Spec: tenancyv1alpha1.VirtualClusterSpec{
+			ClusterDomain:           "cluster.local",
+			ClusterVersionName:      opts.ClusterVersion,
+			PKIExpireDays:           365,
+			OpaqueMetaPrefixes:      []string{"tenancy.x-k8s.io"},
+			TransparentMetaPrefixes: []string{"k8s.net.status", "vc.perfbench.syncer"},
+		},
+	}
+	return vc
+}
+
+// forEachVC calls fn on the vc with indexes in the range of opts, with at
+// most opts.Workers vc at a time
+func forEachVC(opts ProvisionOptions, fn func(idx int) *VCProvision) *ProvisionResult {
+	start := time.Now()
+	ret := &ProvisionResult{VCs: make([]*VCProvision, opts.Count)}
+	var wg sync.WaitGroup
+	idxs := make(chan int)
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range idxs {
+				ret.VCs[idx-opts.Start] = fn(idx)
+			}
+		}()
+	}
+	for idx := opts.Start; idx < opts.Start+opts.Count; idx++ {
+		idxs <- idx
+	}
+	close(idxs)
+	wg.Wait()
+	ret.Duration = time.Since(start)
+	return ret
+}
+
+// ProvisionVCs creates opts.Count vc and waits until each of them is
+// running. Existing namespaces and vc are reused, so provisioning can be
+// retried, but an existing vc of another ClusterVersion is an error.
+func ProvisionVCs(cli client.Client, opts ProvisionOptions) (*ProvisionResult, error) {
+	if err := opts.setDefaults(); err != nil {
+		return nil, err
+	}
+	cv := &tenancyv1alpha1.ClusterVersion{}
+	if err := cli.Get(context.TODO(), types.NamespacedName{Name: opts.ClusterVersion}, cv); err != nil {
+		return nil, fmt.Errorf("fail to get clusterversion %q: %s", opts.ClusterVersion, err)
+	}
+	return forEachVC(opts, func(idx int) *VCProvision {
+		vc := newVirtualCluster(opts, idx)
+		vp := provisionVC(cli, vc, opts.Timeout, opts.PollInterval)
+		if vp.Error != "" {
+			log.Printf("fail to provision vc(%s/%s): %s", vp.Namespace, vp.Name, vp.Error)
+		} else {
+			log.Printf("vc(%s/%s) is running in %.1f seconds", vp.Namespace, vp.Name, vp.Duration.Seconds())
+		}
+		return vp
+	}), nil
+}
+
+func provisionVC(cli client.Client, vc *tenancyv1alpha1.VirtualCluster, timeout, pollInterval time.Duration) *VCProvision {
+	start := time.Now()
+	vp := &VCProvision{Name: vc.GetName(), Namespace: vc.GetNamespace()}
+	fail := func(err error) *VCProvision {
+		vp.Error = err.Error()
+		vp.Duration = time.Since(start)
+		return vp
+	}
+
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name:   vc.GetNamespace(),
+		Labels: map[string]string{LabelManagedBy: ManagedByVcbench},
+	}}
+	if err := cli.Create(context.TODO(), ns); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fail(err)
+	}
+	err := cli.Create(context.TODO(), vc)
+	switch {
+	case err == nil:
+		vp.Created = vc.GetCreationTimestamp().Time
+		if vp.Created.IsZero() {
+			vp.Created = time.Now()
+		}
+	case apierrors.IsAlreadyExists(err):
+		vp.Existed = true
+		existing := &tenancyv1alpha1.VirtualCluster{}
+		if err := cli.Get(context.TODO(), types.NamespacedName{Namespace: vc.GetNamespace(), Name: vc.GetName()}, existing); err != nil {
+			return fail(err)
+		}
+		if existing.Spec.ClusterVersionName != vc.Spec.ClusterVersionName {
+			return fail(fmt.Errorf("vc exists with clusterversion %s", existing.Spec.ClusterVersionName))
+		}
+		vp.Created = existing.GetCreationTimestamp().Time
+	default:
+		return fail(err)
+	}
+
+	deadline := start.Add(timeout)
+	for {
+		cur := &tenancyv1alpha1.VirtualCluster{}
+		if err := cli.Get(context.TODO(), types.NamespacedName{Namespace: vc.GetNamespace(), Name: vc.GetName()}, cur); err != nil {
+			log.Printf("fail to get vc(%s/%s): %s", vc.GetNamespace(), vc.GetName(), err)
+		} else {
+			vp.Phase = cur.Status.Phase
+			switch cur.Status.Phase {
+			case tenancyv1alpha1.ClusterRunning:
+				vp.Running = time.Now()
+				vp.Duration = time.Since(start)
+				return vp
+			case tenancyv1alpha1.ClusterError:
+				return fail(fmt.Errorf("vc is in phase Error: %s %s", cur.Status.Reason, cur.Status.Message))
+			}
+		}
+		if time.Now().After(deadline) {
+			return fail(fmt.Errorf("vc is not running in %s, phase %q", timeout, vp.Phase))
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// DeprovisionVCs deletes opts.Count vc and their namespaces, and waits
+// until the namespaces are removed. Missing vc are skipped, and only the
+// namespaces created by ProvisionVCs are deleted.
+func DeprovisionVCs(cli client.Client, opts ProvisionOptions) (*ProvisionResult, error) {
+	if err := opts.setDefaults(); err != nil {
+		return nil, err
+	}
+	return forEachVC(opts, func(idx int) *VCProvision {
+		vc := newVirtualCluster(opts, idx)
+		vp := deprovisionVC(cli, vc, opts.Timeout, opts.PollInterval)
+		if vp.Error != "" {
+			log.Printf("fail to deprovision vc(%s/%s): %s", vp.Namespace, vp.Name, vp.Error)
+		} else {
+			log.Printf("vc(%s/%s) is removed in %.1f seconds", vp.Namespace, vp.Name, vp.Duration.Seconds())
+		}
+		return vp
+	}), nil
+}
+
+func deprovisionVC(cli client.Client, vc *tenancyv1alpha1.VirtualCluster, timeout, pollInterval time.Duration) *VCProvision {
+	start := time.Now()
+	vp := &VCProvision{Name: vc.GetName(), Namespace: vc.GetNamespace()}
+	err := cli.Delete(context.TODO(), vc)
+	vp.Existed = !apierrors.IsNotFound(err)
+	if err != nil {
+		if vp.Existed {
+			vp.Error = err.Error()
+		}
+		vp.Duration = time.Since(start)
+		return vp
+	}
+	// the namespace may hold other objects if it is not created by
+	// ProvisionVCs
+	ns := &v1.Namespace{}
+	if err := cli.Get(context.TODO(), types.NamespacedName{Name: vc.GetNamespace()}, ns); err != nil {
+		if !apierrors.IsNotFound(err) {
+			vp.Error = err.Error()
+		}
+		vp.Duration = time.Since(start)
+		return vp
+	}
+	if ns.GetLabels()[LabelManagedBy] != ManagedByVcbench {
+		log.Printf("namespace %s is not managed by vcbench, will not delete it", ns.GetName())
+		vp.Duration = time.Since(start)
+		return vp
+	}
+	if err := cli.Delete(context.TODO(), ns); err != nil && !apierrors.IsNotFound(err) {
+		vp.Error = err.Error()
+	} else if leftovers := waitNamespacesGone(cli, []string{vc.GetNamespace()},
+		start.Add(timeout), pollInterval); len(leftovers) != 0 {
+		vp.Error = fmt.Sprintf("%s is not removed in %s", leftovers[0], timeout)
+	}
+	vp.Duration = time.Since(start)
+	return vp
+}
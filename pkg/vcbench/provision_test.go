@@ -0,0 +1,89 @@
+package vcbench
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	tenancyv1alpha1 "sigs.k8s.io/multi-tenancy/incubator/virtualcluster/pkg/apis/tenancy/v1alpha1"
+)
+
+func TestProvisionVCs(t *testing.T) {
+	if err := addVCTypesToScheme(); err != nil {
+		t.Fatalf("fail to add scheme: %s", err)
+	}
+	opts := ProvisionOptions{Count: 3, ClusterVersion: "cv", Timeout: 50 * time.Millisecond, PollInterval: 10 * time.Millisecond}
+	running := newVirtualCluster(ProvisionOptions{Prefix: "vc", NamespacePrefix: "t", ClusterVersion: "cv"}, 1)
+	running.Status.Phase = tenancyv1alpha1.ClusterRunning
+	otherCV := newVirtualCluster(ProvisionOptions{Prefix: "vc", NamespacePrefix: "t", ClusterVersion: "other"}, 2)
+	cli := fake.NewFakeClientWithScheme(scheme.Scheme,
+		&tenancyv1alpha1.ClusterVersion{ObjectMeta: metav1.ObjectMeta{Name: "cv"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "t1"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "t4"}},
+		running, otherCV)
+
+	result, err := ProvisionVCs(cli, opts)
+	if err != nil {
+		t.Fatalf("fail to provision: %s", err)
+	}
+	if len(result.VCs) != 3 {
+		t.Fatalf("expect 3 vc, got %d", len(result.VCs))
+	}
+	// the running vc is reused
+	if vp := result.VCs[0]; vp.Name != "vc1" || !vp.Existed || vp.Error != "" || vp.Running.IsZero() {
+		t.Errorf("unexpected result of vc1: %+v", vp)
+	}
+	if vp := result.VCs[1]; vp.Name != "vc2" || vp.Error == "" {
+		t.Errorf("expect vc2 of another clusterversion to fail: %+v", vp)
+	}
+	// the fake client never runs vc3
+	if vp := result.VCs[2]; vp.Name != "vc3" || vp.Existed || vp.Error == "" || vp.Created.IsZero() {
+		t.Errorf("expect vc3 to be created and time out: %+v", vp)
+	}
+	if len(result.Failed()) != 2 {
+		t.Errorf("expect 2 failed vc, got %d", len(result.Failed()))
+	}
+	if err := cli.Get(context.TODO(), types.NamespacedName{Namespace: "t3", Name: "vc3"}, &tenancyv1alpha1.VirtualCluster{}); err != nil {
+		t.Errorf("vc3 is not created: %s", err)
+	}
+
+	if _, err := ProvisionVCs(cli, ProvisionOptions{Count: 1, ClusterVersion: "missing"}); err == nil {
+		t.Errorf("expect a missing clusterversion to fail")
+	}
+	if _, err := DeprovisionVCs(cli, ProvisionOptions{Count: -1}); err == nil {
+		t.Errorf("expect a negative count to fail")
+	}
+
+	// the fake client removes namespaces immediately
+	result, err = DeprovisionVCs(cli, ProvisionOptions{Count: 4, Timeout: time.Second, PollInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("fail to deprovision: %s", err)
+	}
+	if failed := result.Failed(); len(failed) != 0 {
+		t.Errorf("unexpected failures %+v", failed[0])
+	}
+	if vp := result.VCs[3]; vp.Name != "vc4" || vp.Existed {
+		t.Errorf("unexpected result of vc4: %+v", vp)
+	}
+	vcl := &tenancyv1alpha1.VirtualClusterList{}
+	if err := cli.List(context.TODO(), vcl); err != nil {
+		t.Fatalf("fail to list vc: %s", err)
+	}
+	if len(vcl.Items) != 0 {
+		t.Errorf("expect all vc to be deleted, got %d", len(vcl.Items))
+	}
+	// only the namespaces created by ProvisionVCs are deleted, and the one
+	// of the missing vc4 is kept
+	for ns, kept := range map[string]bool{"t1": true, "t2": false, "t3": false, "t4": true} {
+		err := cli.Get(context.TODO(), types.NamespacedName{Name: ns}, &v1.Namespace{})
+		if kept != (err == nil) {
+			t.Errorf("unexpected namespace %s, kept %t: %v", ns, kept, err)
+		}
+	}
+}
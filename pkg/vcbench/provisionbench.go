@@ -0,0 +1,312 @@
+package vcbench
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	tenancyv1alpha1 "sigs.k8s.io/multi-tenancy/incubator/virtualcluster/pkg/apis/tenancy/v1alpha1"
+	"sigs.k8s.io/multi-tenancy/incubator/virtualcluster/pkg/controller/secret"
+	"sigs.k8s.io/multi-tenancy/incubator/virtualcluster/pkg/syncer/conversion"
+)
+
+const (
+	defaultProvisionPollInterval = time.Second
+	vcAPITimeout                 = 5 * time.Second
+)
+
+// VCProvisionStatics holds the times (unix time in milliseconds) a vc of
+// the provisioning benchmark is created and reaches each stage of the
+// onboarding, 0 if the stage is not reached
+type VCProvisionStatics struct {
+	Name      string
+	Namespace string
+	Created   int64
+	Pending   int64
+	Running   int64
+	// SecretReady is the time the admin-kubeconfig secret exists
+	SecretReady int64
+	// APIReady is the time of the first successful call to the apiserver
+	// of the vc with the admin-kubeconfig
+	APIReady int64
+	// SyncerRegistered is the time the syncer has registered the vc, i.e.,
+	// the default namespace of the vc is synced to the super cluster
+	SyncerRegistered int64
+	Error            string
+}
+
+// complete returns true if every stage after creation is reached
+func (vs *VCProvisionStatics) complete() bool {
+	return vs.Running != 0 && vs.SecretReady != 0 && vs.APIReady != 0 && vs.SyncerRegistered != 0
+}
+
+// onboarded returns the time the last stage is reached
+func (vs *VCProvisionStatics) onboarded() int64 {
+	var last int64
+	for _, stg := range provisionStages {
+		if at := stg.at(vs); at > last {
+			last = at
+		}
+	}
+	return last
+}
+
+// provisionStage is a stage of the onboarding of a vc, which is measured
+// from the creation of the vc
+type provisionStage struct {
+	name string
+	at   func(vs *VCProvisionStatics) int64
+}
+
+// provisionStages lists the stages of the onboarding of a vc
+var provisionStages = []provisionStage{
+	{name: "toPending", at: func(vs *VCProvisionStatics) int64 { return vs.Pending }},
+	{name: "toRunning", at: func(vs *VCProvisionStatics) int64 { return vs.Running }},
+	{name: "toSecret", at: func(vs *VCProvisionStatics) int64 { return vs.SecretReady }},
+	{name: "toAPIReady", at: func(vs *VCProvisionStatics) int64 { return vs.APIReady }},
+	{name: "toSyncerRegistered", at: func(vs *VCProvisionStatics) int64 { return vs.SyncerRegistered }},
+}
+
+// RecordProvision records the time from the creation of the vc to each
+// stage, and to the last stage as the total, in the global scope. Stages
+// that are not reached are skipped, and so is the total of an incomplete
+// vc.
+func (sh *StageHistograms) RecordProvision(vs *VCProvisionStatics) {
+	if vs.Created == 0 {
+		return
+	}
+	sh.Lock()
+	defer sh.Unlock()
+	for _, stg := range provisionStages {
+		at := stg.at(vs)
+		if at == 0 || at < vs.Created {
+			continue
+		}
+		sh.recordLocked(ScopeAll, stg.name, at-vs.Created)
+	}
+	if vs.complete() {
+		sh.recordLocked(ScopeAll, StageTotal, vs.onboarded()-vs.Created)
+	}
+}
+
+// ProvisionBenchExecutor creates vc at a fixed rate and records the time
+// each vc takes to be onboarded
+type ProvisionBenchExecutor struct {
+	// Client accesses the tenant masters k8s, SuperClient accesses the super
+	// cluster where the syncer registers the vc
+	Client      client.Client
+	SuperClient client.Reader
+	Options     ProvisionOptions
+	// Rate is the number of vc created per minute, all vc are created at
+	// once if it is not positive
+	Rate float64
+	// RunID labels every vc created by the run
+	RunID          string
+	RuntimeStatics map[string]*VCProvisionStatics
+	Histograms     *StageHistograms
+
+	// apiReady checks the apiserver of the vc with the rest.Config
+	apiReady func(cfg *rest.Config) error
+}
+
+func NewProvisionBenchExecutor(tenantsKbCfg, superKbCfg string, opts ProvisionOptions, rate float64) (*ProvisionBenchExecutor, error) {
+	cli, err := NewClient(tenantsKbCfg)
+	if err != nil {
+		return nil, err
+	}
+	superCli, err := NewClient(superKbCfg)
+	if err != nil {
+		return nil, err
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultProvisionPollInterval
+	}
+	if err := opts.setDefaults(); err != nil {
+		return nil, err
+	}
+	return &ProvisionBenchExecutor{
+		Client:         cli,
+		SuperClient:    superCli,
+		Options:        opts,
+		Rate:           rate,
+		RunID:          NewRunID(),
+		RuntimeStatics: make(map[string]*VCProvisionStatics),
+		Histograms:     NewStageHistograms(),
+		apiReady:       checkAPIReady,
+	}, nil
+}
+
+// checkAPIReady calls the apiserver with the rest.Config
+func checkAPIReady(cfg *rest.Config) error {
+	cfg = rest.CopyConfig(cfg)
+	cfg.Timeout = vcAPITimeout
+	cs, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	_, err = cs.Discovery().ServerVersion()
+	return err
+}
+
+// RunProvisionBench creates the vc at the rate, and waits until every vc is
+// onboarded, fails or times out
+func (pbe *ProvisionBenchExecutor) RunProvisionBench() error {
+	cv := &tenancyv1alpha1.ClusterVersion{}
+	if err := pbe.Client.Get(context.TODO(), types.NamespacedName{Name: pbe.Options.ClusterVersion}, cv); err != nil {
+		return fmt.Errorf("fail to get clusterversion %q: %s", pbe.Options.ClusterVersion, err)
+	}
+	var interval time.Duration
+	if pbe.Rate > 0 {
+		interval = time.Duration(float64(time.Minute) / pbe.Rate)
+	}
+	var wg sync.WaitGroup
+	for idx := pbe.Options.Start; idx < pbe.Options.Start+pbe.Options.Count; idx++ {
+		vc := newVirtualCluster(pbe.Options, idx)
+		setRunLabels(vc, pbe.RunID)
+		vs := &VCProvisionStatics{Name: vc.GetName(), Namespace: vc.GetNamespace()}
+		pbe.RuntimeStatics[vc.GetNamespace()+"/"+vc.GetName()] = vs
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pbe.onboard(vc, vs)
+			if vs.Error != "" {
+				log.Printf("fail to onboard vc(%s/%s): %s", vs.Namespace, vs.Name, vs.Error)
+			} else {
+				log.Printf("vc(%s/%s) is onboarded in %d ms", vs.Namespace, vs.Name, vs.onboarded()-vs.Created)
+			}
+			pbe.Histograms.RecordProvision(vs)
+		}()
+		if idx < pbe.Options.Start+pbe.Options.Count-1 {
+			time.Sleep(interval)
+		}
+	}
+	log.Printf("all %d vc are created", pbe.Options.Count)
+	wg.Wait()
+	return nil
+}
+
+// onboard creates the vc, then polls the vc, its admin-kubeconfig secret,
+// its apiserver and the super cluster until every stage is reached
+func (pbe *ProvisionBenchExecutor) onboard(vc *tenancyv1alpha1.VirtualCluster, vs *VCProvisionStatics) {
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: vc.GetNamespace()}}
+	setRunLabels(ns, pbe.RunID)
+	if err := pbe.Client.Create(context.TODO(), ns); err != nil && !apierrors.IsAlreadyExists(err) {
+		vs.Error = err.Error()
+		return
+	}
+	// an existing vc would make the latency meaningless
+	if err := pbe.Client.Create(context.TODO(), vc); err != nil {
+		vs.Error = err.Error()
+		return
+	}
+	vs.Created = time.Now().UnixNano() / int64(time.Millisecond)
+
+	deadline := time.Now().Add(pbe.Options.Timeout)
+	for !vs.complete() {
+		if time.Now().After(deadline) {
+			vs.Error = fmt.Sprintf("vc is not onboarded in %s", pbe.Options.Timeout)
+			return
+		}
+		time.Sleep(pbe.Options.PollInterval)
+		if err := pbe.pollStages(vc, vs); err != nil {
+			vs.Error = err.Error()
+			return
+		}
+	}
+}
+
+// pollStages records the stages the vc has reached since the last poll, it
+// returns an error if the vc fails
+func (pbe *ProvisionBenchExecutor) pollStages(vc *tenancyv1alpha1.VirtualCluster, vs *VCProvisionStatics) error {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	cur := &tenancyv1alpha1.VirtualCluster{}
+	if err := pbe.Client.Get(context.TODO(), types.NamespacedName{Namespace: vc.GetNamespace(), Name: vc.GetName()}, cur); err != nil {
+		log.Printf("fail to get vc(%s/%s): %s", vc.GetNamespace(), vc.GetName(), err)
+		return nil
+	}
+	switch cur.Status.Phase {
+	case tenancyv1alpha1.ClusterPending:
+		if vs.Pending == 0 {
+			vs.Pending = now
+		}
+	case tenancyv1alpha1.ClusterRunning:
+		if vs.Running == 0 {
+			vs.Running = now
+		}
+	case tenancyv1alpha1.ClusterError:
+		return fmt.Errorf("vc is in phase Error: %s %s", cur.Status.Reason, cur.Status.Message)
+	}
+	if cur.Status.ClusterNamespace == "" {
+		return nil
+	}
+
+	if vs.APIReady == 0 {
+		srt := &v1.Secret{}
+		err := pbe.Client.Get(context.TODO(), types.NamespacedName{
+			Namespace: cur.Status.ClusterNamespace,
+			Name:      secret.AdminSecretName,
+		}, srt)
+		kbCfg, exist := srt.Data[secret.AdminSecretName]
+		if err == nil && exist {
+			if vs.SecretReady == 0 {
+				vs.SecretReady = now
+			}
+			cfg, err := clientcmd.RESTConfigFromKubeConfig(kbCfg)
+			if err != nil {
+				return fmt.Errorf("invalid admin-kubeconfig: %s", err)
+			}
+			if err := pbe.apiReady(cfg); err == nil {
+				vs.APIReady = now
+			}
+		}
+	}
+
+	if vs.SyncerRegistered == 0 {
+		superNs := conversion.ToSuperMasterNamespace(conversion.ToClusterKey(cur), metav1.NamespaceDefault)
+		err := pbe.SuperClient.Get(context.TODO(), types.NamespacedName{Name: superNs}, &v1.Namespace{})
+		if err == nil {
+			vs.SyncerRegistered = now
+		}
+	}
+	return nil
+}
+
+// WriteProvisionStatics writes the stages of every vc to w, rows are sorted
+// by the namespace and name of the vc
+func WriteProvisionStatics(w io.Writer, rsMap map[string]*VCProvisionStatics) error {
+	if _, err := io.WriteString(w, "#vcName,created,pending,running,secretReady,apiReady,syncerRegistered,error\n"); err != nil {
+		return err
+	}
+	var keys []string
+	for key := range rsMap {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		vs := rsMap[key]
+		if _, err := fmt.Fprintf(w, "%s,%d,%d,%d,%d,%d,%d,%q\n", key,
+			vs.Created,
+			vs.Pending,
+			vs.Running,
+			vs.SecretReady,
+			vs.APIReady,
+			vs.SyncerRegistered,
+			vs.Error); err != nil {
+			return err
+		}
+	}
+	return nil
+}
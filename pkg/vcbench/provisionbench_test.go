@@ -0,0 +1,134 @@
+package vcbench
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	tenancyv1alpha1 "sigs.k8s.io/multi-tenancy/incubator/virtualcluster/pkg/apis/tenancy/v1alpha1"
+	"sigs.k8s.io/multi-tenancy/incubator/virtualcluster/pkg/controller/secret"
+	"sigs.k8s.io/multi-tenancy/incubator/virtualcluster/pkg/syncer/conversion"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    server: https://vc1-apiserver:6443
+  name: vc1
+contexts:
+- context:
+    cluster: vc1
+    user: admin
+  name: vc1
+current-context: vc1
+users:
+- name: admin
+  user:
+    token: abc
+`
+
+func TestPollStages(t *testing.T) {
+	if err := addVCTypesToScheme(); err != nil {
+		t.Fatalf("fail to add scheme: %s", err)
+	}
+	vc := newVirtualCluster(ProvisionOptions{Prefix: "vc", NamespacePrefix: "t", ClusterVersion: "cv"}, 1)
+	vc.Status.Phase = tenancyv1alpha1.ClusterPending
+	tenantCli := fake.NewFakeClientWithScheme(scheme.Scheme, vc)
+	superCli := fake.NewFakeClientWithScheme(scheme.Scheme)
+	apiUp := false
+	pbe := &ProvisionBenchExecutor{
+		Client:      tenantCli,
+		SuperClient: superCli,
+		apiReady: func(cfg *rest.Config) error {
+			if cfg.Host != "https://vc1-apiserver:6443" {
+				t.Errorf("unexpected host %s", cfg.Host)
+			}
+			if !apiUp {
+				return fmt.Errorf("connection refused")
+			}
+			return nil
+		},
+	}
+	vs := &VCProvisionStatics{Name: "vc1", Namespace: "t1", Created: 1}
+
+	if err := pbe.pollStages(vc, vs); err != nil {
+		t.Fatalf("fail to poll: %s", err)
+	}
+	if vs.Pending == 0 || vs.Running != 0 || vs.SecretReady != 0 {
+		t.Fatalf("expect only Pending to be reached, got %+v", vs)
+	}
+
+	// the vc is running and its secret exists, but the apiserver is down
+	// and the syncer has not registered it
+	vc.Status.Phase = tenancyv1alpha1.ClusterRunning
+	vc.Status.ClusterNamespace = "t1-vc1"
+	if err := tenantCli.Update(nil, vc); err != nil {
+		t.Fatalf("fail to update vc: %s", err)
+	}
+	if err := tenantCli.Create(nil, &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: secret.AdminSecretName, Namespace: "t1-vc1"},
+		Data:       map[string][]byte{secret.AdminSecretName: []byte(testKubeconfig)},
+	}); err != nil {
+		t.Fatalf("fail to create secret: %s", err)
+	}
+	if err := pbe.pollStages(vc, vs); err != nil {
+		t.Fatalf("fail to poll: %s", err)
+	}
+	if vs.Running == 0 || vs.SecretReady == 0 || vs.APIReady != 0 || vs.SyncerRegistered != 0 {
+		t.Fatalf("unexpected stages %+v", vs)
+	}
+
+	apiUp = true
+	superNs := conversion.ToSuperMasterNamespace(conversion.ToClusterKey(vc), metav1.NamespaceDefault)
+	if err := superCli.Create(nil, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: superNs}}); err != nil {
+		t.Fatalf("fail to create super namespace: %s", err)
+	}
+	if err := pbe.pollStages(vc, vs); err != nil {
+		t.Fatalf("fail to poll: %s", err)
+	}
+	if !vs.complete() {
+		t.Fatalf("expect vc to be onboarded, got %+v", vs)
+	}
+
+	vc.Status.Phase = tenancyv1alpha1.ClusterError
+	if err := tenantCli.Update(nil, vc); err != nil {
+		t.Fatalf("fail to update vc: %s", err)
+	}
+	if err := pbe.pollStages(vc, &VCProvisionStatics{}); err == nil {
+		t.Errorf("expect a vc in phase Error to fail")
+	}
+}
+
+func TestRecordProvision(t *testing.T) {
+	sh := NewStageHistograms()
+	complete := &VCProvisionStatics{Name: "vc1", Namespace: "t1", Created: 1000,
+		Pending: 1100, Running: 5000, SecretReady: 3000, APIReady: 6000, SyncerRegistered: 7000}
+	incomplete := &VCProvisionStatics{Name: "vc2", Namespace: "t2", Created: 1000,
+		Pending: 1200, Error: "vc is not onboarded in 10m0s"}
+	sh.RecordProvision(complete)
+	sh.RecordProvision(incomplete)
+
+	if h := sh.Scopes[ScopeAll]["toPending"]; h.TotalCount() != 2 {
+		t.Errorf("expect 2 samples of toPending, got %d", h.TotalCount())
+	}
+	if h := sh.Scopes[ScopeAll][StageTotal]; h.TotalCount() != 1 || h.Max() < 5990 || h.Max() > 6010 {
+		t.Errorf("expect the total of vc1 only, got %d samples max %d", h.TotalCount(), h.Max())
+	}
+
+	buf := &bytes.Buffer{}
+	if err := WriteProvisionStatics(buf, map[string]*VCProvisionStatics{"t1/vc1": complete, "t2/vc2": incomplete}); err != nil {
+		t.Fatalf("fail to write provision statics: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || lines[1] != `t1/vc1,1000,1100,5000,3000,6000,7000,""` {
+		t.Errorf("unexpected provision data:\n%s", buf.String())
+	}
+}
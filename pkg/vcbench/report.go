@@ -0,0 +1,85 @@
+package vcbench
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/charleszheng44/vc-bench/pkg/util/hdrhistogram"
+)
+
+// reportQuantiles are the percentiles shown in the report
+var reportQuantiles = []float64{50, 90, 99, 99.9}
+
+// WriteReport writes the percentiles of every stage in the scopes that
+// start with scopePrefix (e.g. "all", "vc/" or "tenant/") to w
+func WriteReport(w io.Writer, sh *StageHistograms, scopePrefix string) error {
+	sh.Lock()
+	defer sh.Unlock()
+
+	var scopes []string
+	for scope := range sh.Scopes {
+		if strings.HasPrefix(scope, scopePrefix) {
+			scopes = append(scopes, scope)
+		}
+	}
+	sort.Strings(scopes)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	header := []string{"SCOPE", "STAGE", "COUNT", "MEAN"}
+	for _, q := range reportQuantiles {
+		header = append(header, fmt.Sprintf("P%v", q))
+	}
+	header = append(header, "MAX")
+	fmt.Fprintf(tw, "%s(%s)\n", strings.Join(header, "\t"), sh.Unit)
+	for _, scope := range scopes {
+		for _, stage := range sortStages(sh.Scopes[scope]) {
+			h := sh.Scopes[scope][stage]
+			row := []string{scope, stage,
+				fmt.Sprintf("%d", h.TotalCount()),
+				fmt.Sprintf("%.1f", h.Mean())}
+			for _, q := range reportQuantiles {
+				row = append(row, fmt.Sprintf("%d", h.ValueAtQuantile(q)))
+			}
+			row = append(row, fmt.Sprintf("%d", h.Max()))
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+	}
+	return tw.Flush()
+}
+
+// sortStages orders the lifecycle stages, the startup stages of the
+// baseline benchmark, or the onboarding stages of vc, as they happen,
+// followed by the total and any other stages in alphabetical order
+func sortStages(stages map[string]*hdrhistogram.Histogram) []string {
+	order := make(map[string]int)
+	for i, stg := range lifecycleStages {
+		order[stg.name] = i
+	}
+	for i, stg := range baseStages {
+		order[stg.name] = len(lifecycleStages) + i
+	}
+	for i, stg := range provisionStages {
+		order[stg.name] = len(lifecycleStages) + len(baseStages) + i
+	}
+	order[StageTotal] = len(lifecycleStages) + len(baseStages) + len(provisionStages)
+
+	var names []string
+	for name := range stages {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		oi, iKnown := order[names[i]]
+		oj, jKnown := order[names[j]]
+		switch {
+		case iKnown && jKnown:
+			return oi < oj
+		case iKnown != jKnown:
+			return iKnown
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
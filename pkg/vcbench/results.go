@@ -0,0 +1,304 @@
+package vcbench
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/charleszheng44/vc-bench/pkg/tenant"
+	"github.com/charleszheng44/vc-bench/pkg/util/perftimestamp"
+)
+
+// column names used by the header of the runtime data log
+// (i.e. <outDataDir>.log), the timestamp columns are named after the stages
+const (
+	colPodName       = "podName"
+	colSuperCreation = string(perftimestamp.StageSuperCreation)
+)
+
+var builtinColumns = func() []string {
+	cols := []string{colPodName}
+	for _, stage := range perftimestamp.Stages() {
+		cols = append(cols, string(stage))
+	}
+	return cols
+}()
+
+func isBuiltinColumn(col string) bool {
+	for _, c := range builtinColumns {
+		if c == col {
+			return true
+		}
+	}
+	return false
+}
+
+// lifecycleStage is a stage of the lifecycle of a pod, which starts at the
+// timestamp returned by `from` and ends at the timestamp returned by `to`
+type lifecycleStage struct {
+	name      string
+	fromStage perftimestamp.Stage
+	toStage   perftimestamp.Stage
+	from      func(rs *RuntimeStatics) int
+	to        func(rs *RuntimeStatics) int
+}
+
+// stageSpan is the lifecycle stage between two timestamps of the timeline
+func stageSpan(name string, from, to perftimestamp.Stage) lifecycleStage {
+	return lifecycleStage{
+		name:      name,
+		fromStage: from,
+		toStage:   to,
+		from:      func(rs *RuntimeStatics) int { return *rs.stageFields()[from] },
+		to:        func(rs *RuntimeStatics) int { return *rs.stageFields()[to] },
+	}
+}
+
+// duration returns the seconds the stage takes in the timeline, false if
+// either end of the stage is not recorded
+func (stg lifecycleStage) duration(tl perftimestamp.StageTimeline) (int64, bool) {
+	return tl.Duration(stg.fromStage, stg.toStage)
+}
+
+// lifecycleStages lists the stages of the lifecycle of a pod in order,
+// i.e., tenant create -> DWS dequeue -> super create -> super ready ->
+// UWS dequeue -> tenant update
+var lifecycleStages = []lifecycleStage{
+	stageSpan("dwsQueue", perftimestamp.StageTenantCreation, perftimestamp.StageDWSReconcile),
+	stageSpan("dwsProcess", perftimestamp.StageDWSReconcile, perftimestamp.StageSuperCreation),
+	stageSpan("superCreation", perftimestamp.StageSuperCreation, perftimestamp.StageSuperReady),
+	stageSpan("uwsQueue", perftimestamp.StageSuperReady, perftimestamp.StageUWSReconcile),
+	stageSpan("tenantUpdate", perftimestamp.StageUWSReconcile, perftimestamp.StageFirstUpdate),
+}
+
+// diffStages lists the columns of the runtime duration log (i.e.
+// <outDataDir>.diff), which are the lifecycle stages followed by the total
+var diffStages = append(append([]lifecycleStage(nil), lifecycleStages...),
+	stageSpan(StageTotal, perftimestamp.StageTenantCreation, perftimestamp.StageFirstUpdate))
+
+// LoadRuntimeStatics reads the runtime data log written by `vcbench run`.
+// Columns are located by the header line, so logs with extra or reordered
+// columns can still be loaded. Unknown columns are loaded into Extra.
+func LoadRuntimeStatics(logPath string) ([]*RuntimeStatics, error) {
+	f, err := os.Open(logPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		header  map[string]int
+		rsLst   []*RuntimeStatics
+		lineNum int
+	)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#") {
+			header = make(map[string]int)
+			for i, col := range strings.Split(strings.TrimPrefix(line, "#"), ",") {
+				header[strings.TrimSpace(col)] = i
+			}
+			continue
+		}
+		if header == nil {
+			return nil, fmt.Errorf("%s: line %d appears before the header", logPath, lineNum)
+		}
+		fields := strings.Split(line, ",")
+		rs := &RuntimeStatics{}
+		for stage, dst := range rs.stageFields() {
+			col := string(stage)
+			idx, exist := header[col]
+			if !exist || idx >= len(fields) {
+				continue
+			}
+			v, err := strconv.Atoi(strings.TrimSpace(fields[idx]))
+			if err != nil {
+				return nil, fmt.Errorf("%s: line %d: invalid %s: %s", logPath, lineNum, col, err)
+			}
+			*dst = v
+		}
+		for col, idx := range header {
+			if isBuiltinColumn(col) || idx >= len(fields) {
+				continue
+			}
+			v, err := strconv.Atoi(strings.TrimSpace(fields[idx]))
+			if err != nil {
+				return nil, fmt.Errorf("%s: line %d: invalid %s: %s", logPath, lineNum, col, err)
+			}
+			if rs.Extra == nil {
+				rs.Extra = make(map[string]int)
+			}
+			rs.Extra[col] = v
+		}
+		if idx, exist := header[colPodName]; exist && idx < len(fields) {
+			rs.PodName = strings.TrimSpace(fields[idx])
+		}
+		rs.ClusterName, rs.TenantID, _ = splitPodName(rs.PodName)
+		rsLst = append(rsLst, rs)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rsLst, nil
+}
+
+// WriteRuntimeStatics writes the timestamps of every pod to logW and the
+// durations between them to diffW, rows are sorted by pod name. Extra
+// timestamps are appended to the log as columns sorted by name, 0 if the
+// pod doesn't have it. Durations with a missing end are written as "-".
+func WriteRuntimeStatics(logW, diffW io.Writer, rsMap map[string]*RuntimeStatics) error {
+	extraCols := sortedExtraColumns(rsMap)
+	// copy the builtin columns, so that appending never writes to their
+	// shared backing array
+	cols := append([]string(nil), builtinColumns...)
+	if _, err := fmt.Fprintf(logW, "#%s\n", strings.Join(append(cols, extraCols...), ",")); err != nil {
+		return err
+	}
+	diffCols := []string{colPodName}
+	for _, stg := range diffStages {
+		diffCols = append(diffCols, stg.name)
+	}
+	if _, err := fmt.Fprintf(diffW, "#%s\n", strings.Join(diffCols, ",")); err != nil {
+		return err
+	}
+	for _, pn := range sortedPodNames(rsMap) {
+		rs := rsMap[pn]
+		tl := rs.Timeline()
+		logRow := []string{pn}
+		for _, stage := range perftimestamp.Stages() {
+			logRow = append(logRow, strconv.FormatInt(tl[stage], 10))
+		}
+		for _, col := range extraCols {
+			logRow = append(logRow, strconv.Itoa(rs.Extra[col]))
+		}
+		if _, err := fmt.Fprintf(logW, "%s\n", strings.Join(logRow, ",")); err != nil {
+			return err
+		}
+		diffRow := []string{pn}
+		for _, stg := range diffStages {
+			if d, ok := stg.duration(tl); ok {
+				diffRow = append(diffRow, strconv.FormatInt(d, 10))
+			} else {
+				diffRow = append(diffRow, "-")
+			}
+		}
+		if _, err := fmt.Fprintf(diffW, "%s\n", strings.Join(diffRow, ",")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedPodNames(rsMap map[string]*RuntimeStatics) []string {
+	var podNames []string
+	for pn := range rsMap {
+		podNames = append(podNames, pn)
+	}
+	sort.Strings(podNames)
+	return podNames
+}
+
+func sortedExtraColumns(rsMap map[string]*RuntimeStatics) []string {
+	cols := make(map[string]struct{})
+	for _, rs := range rsMap {
+		for col := range rs.Extra {
+			cols[col] = struct{}{}
+		}
+	}
+	var ret []string
+	for col := range cols {
+		ret = append(ret, col)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// MissingStages returns the names of the columns whose timestamp is not
+// recorded for the pod
+func MissingStages(rs *RuntimeStatics) []string {
+	var missing []string
+	for _, stage := range rs.Timeline().Missing() {
+		missing = append(missing, string(stage))
+	}
+	return missing
+}
+
+// splitPodName splits the name of a benchmark pod, which is of the form
+// <vc>-<tenant>-pod<index>, into its vc name, tenant id and index. The vc
+// name is taken up to the first '-', use AssignTenants if the tenants of the
+// run are known.
+func splitPodName(podName string) (vc, tenantID string, index int) {
+	index = -1
+	firstDash := strings.Index(podName, "-")
+	lastDash := strings.LastIndex(podName, "-"+defaultPodBaseName)
+	if firstDash < 0 || lastDash <= firstDash {
+		return
+	}
+	vc = podName[:firstDash]
+	tenantID = podName[firstDash+1 : lastDash]
+	if i, err := strconv.Atoi(podName[lastDash+len(defaultPodBaseName)+1:]); err == nil {
+		index = i
+	}
+	return
+}
+
+// splitVCPodName splits the name of a benchmark pod, which is of the form
+// <vc>-<tenant>-pod<index>, by the known tenants. As both the vc and the
+// tenant may contain '-', the longest tenant id that matches is used.
+func splitVCPodName(podName string, tenants []tenant.Tenant) (vc, tenantID string, index int, found bool) {
+	for _, t := range tenants {
+		sep := "-" + t.ID + "-" + defaultPodBaseName
+		i := strings.LastIndex(podName, sep)
+		if i <= 0 || (found && len(t.ID) <= len(tenantID)) {
+			continue
+		}
+		podNum, err := strconv.Atoi(podName[i+len(sep):])
+		if err != nil {
+			continue
+		}
+		vc, tenantID, index, found = podName[:i], t.ID, podNum, true
+	}
+	return
+}
+
+// AssignTenants sets the vc and tenant of the pods loaded from a runtime
+// data log by the tenants of the run, as the names guessed by
+// LoadRuntimeStatics are wrong if the vc name contains '-'. Pods that match
+// none of the tenants are not changed.
+func AssignTenants(rsLst []*RuntimeStatics, tenants []tenant.Tenant) {
+	for _, rs := range rsLst {
+		if vc, tenantID, _, ok := splitVCPodName(rs.PodName, tenants); ok {
+			rs.ClusterName, rs.TenantID = vc, tenantID
+		}
+	}
+}
+
+// FindOutDataFile locates the file with the given suffix (e.g. ".log") in
+// the output data directory. `vcbench run` names the file after the
+// directory, while older runs may use a different base name, in which case
+// the only file with the suffix is used.
+func FindOutDataFile(outDataDir, suffix string) (string, error) {
+	p := path.Join(outDataDir, path.Base(outDataDir)+suffix)
+	if _, err := os.Stat(p); err == nil {
+		return p, nil
+	}
+	matches, err := filepath.Glob(path.Join(outDataDir, "*"+suffix))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) != 1 {
+		return "", fmt.Errorf("expect exactly one *%s file in %s, found %d", suffix, outDataDir, len(matches))
+	}
+	return matches[0], nil
+}
@@ -0,0 +1,543 @@
+package vcbench
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/charleszheng44/vc-bench/pkg/util/hdrhistogram"
+)
+
+const (
+	defaultScrapeInterval       = 20
+	defaultScrapeTimeout        = 10
+	scrapeInitialBackoff        = time.Second
+	defaultApiserverMetricsPath = "/metrics"
+)
+
+// ScrapeTarget is a metrics endpoint to be scraped periodically
+type ScrapeTarget struct {
+	// Name identifies the target, e.g. "syncer" or "kubelet-0"
+	Name string `yaml:"name"`
+	// Addr is the url (or host:port) of the metrics endpoint
+	Addr string `yaml:"addr"`
+	// Interval is the scraping interval in seconds
+	Interval int `yaml:"interval"`
+	// Timeout is the timeout of one scrape in seconds
+	Timeout int `yaml:"timeout"`
+	// OutFile is the file, relative to the output data directory, that
+	// stores the scraped metrics, default to <outDataDir>.<Name>.metrics
+	OutFile string `yaml:"outFile"`
+
+	// CAFile is the CA bundle used to verify the server certificate
+	CAFile string `yaml:"caFile"`
+	// CertFile and KeyFile are the client certificate and key
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+	// InsecureSkipVerify disables the verification of the server
+	// certificate
+	InsecureSkipVerify bool `yaml:"insecureSkipVerify"`
+	// BearerToken or the token stored in BearerTokenFile is sent in the
+	// Authorization header
+	BearerToken     string `yaml:"bearerToken"`
+	BearerTokenFile string `yaml:"bearerTokenFile"`
+	// Kubeconfig is the path to a kubeconfig whose server and credentials
+	// are used, e.g. to scrape an apiserver. In this case, Addr can be a
+	// path (default to /metrics) relative to the server of the kubeconfig.
+	Kubeconfig string `yaml:"kubeconfig"`
+	// RestConfig has the same effect as Kubeconfig, it is used by targets
+	// that are generated at runtime, e.g. apiservers of tenant masters
+	RestConfig *rest.Config `yaml:"-"`
+	// Discovery, if set, replaces the target by one target for every
+	// discovered pod or node, which are scraped through the apiserver
+	// specified by Kubeconfig
+	Discovery *TargetDiscovery `yaml:"discovery"`
+
+	// MetricAllow and MetricDeny are regular expressions on metric names,
+	// if MetricAllow is set, only metrics matching one of them are kept,
+	// metrics matching any of MetricDeny are dropped
+	MetricAllow []string `yaml:"metricAllow"`
+	MetricDeny  []string `yaml:"metricDeny"`
+	// LabelAllow and LabelDeny are regular expressions on the labels of
+	// samples, e.g. `cluster="vc1"`, and work like MetricAllow/MetricDeny
+	LabelAllow []string `yaml:"labelAllow"`
+	LabelDeny  []string `yaml:"labelDeny"`
+	// Compress stores the metrics gzip-compressed
+	Compress bool `yaml:"compress"`
+
+	filter *metricFilter
+}
+
+// metricFilter decides which lines of the exposition are stored
+type metricFilter struct {
+	allow, deny           []*regexp.Regexp
+	labelAllow, labelDeny []*regexp.Regexp
+}
+
+func newMetricFilter(t *ScrapeTarget) (*metricFilter, error) {
+	mf := &metricFilter{}
+	for _, c := range []struct {
+		exprs []string
+		dst   *[]*regexp.Regexp
+	}{
+		{t.MetricAllow, &mf.allow},
+		{t.MetricDeny, &mf.deny},
+		{t.LabelAllow, &mf.labelAllow},
+		{t.LabelDeny, &mf.labelDeny},
+	} {
+		for _, expr := range c.exprs {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("scrape target %s: %s", t.Name, err)
+			}
+			*c.dst = append(*c.dst, re)
+		}
+	}
+	if len(mf.allow)+len(mf.deny)+len(mf.labelAllow)+len(mf.labelDeny) == 0 {
+		return nil, nil
+	}
+	return mf, nil
+}
+
+func matchAny(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// keep returns true if the line of the exposition should be stored. HELP
+// and TYPE comments are filtered by the name of the metric family.
+func (mf *metricFilter) keep(line string) bool {
+	if mf == nil {
+		return true
+	}
+	var name, labels string
+	if strings.HasPrefix(line, "#") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || (fields[1] != "HELP" && fields[1] != "TYPE") {
+			return true
+		}
+		name = fields[2]
+	} else {
+		var rest string
+		name, rest = splitMetricName(line)
+		if strings.HasPrefix(rest, "{") {
+			if end := strings.LastIndex(rest, "}"); end > 0 {
+				labels = rest[1:end]
+			}
+		}
+		if len(mf.labelAllow) != 0 && !matchAny(mf.labelAllow, labels) {
+			return false
+		}
+		if matchAny(mf.labelDeny, labels) {
+			return false
+		}
+	}
+	if len(mf.allow) != 0 && !matchAny(mf.allow, name) {
+		return false
+	}
+	return !matchAny(mf.deny, name)
+}
+
+func (t *ScrapeTarget) usesTLS() bool {
+	return t.CAFile != "" || t.CertFile != "" || t.InsecureSkipVerify
+}
+
+// httpClient builds the client used to scrape the target, which carries
+// the TLS settings and credentials of the target
+func (t *ScrapeTarget) httpClient() (*http.Client, error) {
+	timeout := time.Duration(t.Timeout) * time.Second
+	if t.RestConfig != nil {
+		rt, err := rest.TransportFor(t.RestConfig)
+		if err != nil {
+			return nil, err
+		}
+		return &http.Client{Transport: rt, Timeout: timeout}, nil
+	}
+
+	var rt http.RoundTripper = http.DefaultTransport
+	if t.usesTLS() {
+		tlsCfg := &tls.Config{InsecureSkipVerify: t.InsecureSkipVerify}
+		if t.CAFile != "" {
+			caByts, err := ioutil.ReadFile(t.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(caByts) {
+				return nil, fmt.Errorf("no certificate found in %s", t.CAFile)
+			}
+			tlsCfg.RootCAs = pool
+		}
+		if t.CertFile != "" || t.KeyFile != "" {
+			cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+			if err != nil {
+				return nil, err
+			}
+			tlsCfg.Certificates = []tls.Certificate{cert}
+		}
+		tr := http.DefaultTransport.(*http.Transport).Clone()
+		tr.TLSClientConfig = tlsCfg
+		rt = tr
+	}
+	if t.BearerToken != "" || t.BearerTokenFile != "" {
+		rt = &bearerTokenRoundTripper{
+			token:     t.BearerToken,
+			tokenFile: t.BearerTokenFile,
+			rt:        rt,
+		}
+	}
+	return &http.Client{Transport: rt, Timeout: timeout}, nil
+}
+
+// bearerTokenRoundTripper sets the Authorization header of requests. The
+// token file is read on every request, as tokens may be rotated.
+type bearerTokenRoundTripper struct {
+	token     string
+	tokenFile string
+	rt        http.RoundTripper
+}
+
+func (b *bearerTokenRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	token := b.token
+	if b.tokenFile != "" {
+		byts, err := ioutil.ReadFile(b.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(byts))
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return b.rt.RoundTrip(req)
+}
+
+// ScrapeConfig lists the targets to be scraped during a run
+type ScrapeConfig struct {
+	Targets []ScrapeTarget `yaml:"targets"`
+}
+
+// LoadScrapeConfig reads the scrape targets from a yaml file
+func LoadScrapeConfig(cfgPath string) (*ScrapeConfig, error) {
+	byts, err := ioutil.ReadFile(cfgPath)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &ScrapeConfig{}
+	if err := yaml.UnmarshalStrict(byts, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Scraper scrapes metrics from a list of targets, each in its own
+// goroutine with its own interval, timeout and output file. All targets
+// are started and stopped together.
+type Scraper struct {
+	OutDataDir string
+	Targets    []ScrapeTarget
+
+	stop       chan struct{}
+	wg         sync.WaitGroup
+	healthLock sync.Mutex
+	health     map[string]*ScrapeHealth
+}
+
+// NewScraper validates the targets, resolves targets with discovery and
+// fills in the defaults
+func NewScraper(outDataDir string, targets []ScrapeTarget) (*Scraper, error) {
+	targets, err := resolveTargets(targets, defaultApiserverMetricsPath)
+	if err != nil {
+		return nil, err
+	}
+	for i := range targets {
+		t := &targets[i]
+		if t.Interval <= 0 {
+			t.Interval = defaultScrapeInterval
+		}
+		if t.Timeout <= 0 {
+			t.Timeout = defaultScrapeTimeout
+		}
+		if t.OutFile == "" {
+			t.OutFile = fmt.Sprintf("%s.%s.metrics", path.Base(outDataDir), t.Name)
+			if t.Compress {
+				t.OutFile += ".gz"
+			}
+		}
+		if t.filter, err = newMetricFilter(t); err != nil {
+			return nil, err
+		}
+	}
+	return &Scraper{
+		OutDataDir: outDataDir,
+		Targets:    targets,
+	}, nil
+}
+
+// resolveTargets loads the kubeconfig of targets, replaces targets with
+// discovery by the discovered ones and normalizes the address of every
+// target. Targets that access the apiserver default to defaultPath.
+func resolveTargets(targets []ScrapeTarget, defaultPath string) ([]ScrapeTarget, error) {
+	for i := range targets {
+		t := &targets[i]
+		if t.Kubeconfig != "" && t.RestConfig == nil {
+			cfg, err := clientcmd.BuildConfigFromFlags("", t.Kubeconfig)
+			if err != nil {
+				return nil, fmt.Errorf("scrape target %s: %s", t.Name, err)
+			}
+			t.RestConfig = cfg
+		}
+	}
+	targets, err := discoverTargets(targets)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]struct{})
+	for i := range targets {
+		t := &targets[i]
+		if t.Name == "" {
+			return nil, fmt.Errorf("scrape target %d: name is required", i)
+		}
+		if _, exist := names[t.Name]; exist {
+			return nil, fmt.Errorf("duplicate scrape target %s", t.Name)
+		}
+		names[t.Name] = struct{}{}
+		if t.RestConfig != nil {
+			if t.Addr == "" {
+				t.Addr = defaultPath
+			}
+			if strings.HasPrefix(t.Addr, "/") {
+				t.Addr = strings.TrimSuffix(t.RestConfig.Host, "/") + t.Addr
+			}
+		}
+		if t.Addr == "" {
+			return nil, fmt.Errorf("scrape target %s: addr is required", t.Name)
+		}
+		if !strings.HasPrefix(t.Addr, "http://") &&
+			!strings.HasPrefix(t.Addr, "https://") {
+			if t.usesTLS() {
+				t.Addr = "https://" + t.Addr
+			} else {
+				t.Addr = "http://" + t.Addr
+			}
+		}
+	}
+	return targets, nil
+}
+
+// ScrapeHealth summarizes the scrapes of one target
+type ScrapeHealth struct {
+	Target    string `json:"target"`
+	Successes int    `json:"successes"`
+	Failures  int    `json:"failures"`
+	LastError string `json:"lastError,omitempty"`
+	// latencies of successful scrapes in milliseconds
+	MeanLatency float64 `json:"meanLatency"`
+	P50Latency  int64   `json:"p50Latency"`
+	P99Latency  int64   `json:"p99Latency"`
+	MaxLatency  int64   `json:"maxLatency"`
+
+	latencies *hdrhistogram.Histogram
+}
+
+// Start starts scraping all targets
+func (s *Scraper) Start() {
+	s.stop = make(chan struct{})
+	s.health = make(map[string]*ScrapeHealth)
+	for _, t := range s.Targets {
+		// the configuration is constant, New never fails here
+		latencies, _ := hdrhistogram.New(histHighestTrackableValue, histSignificantFigures)
+		s.health[t.Name] = &ScrapeHealth{Target: t.Name, latencies: latencies}
+		s.wg.Add(1)
+		go s.run(t)
+	}
+}
+
+// Stop stops scraping, waits until all output files are closed and writes
+// the scrape-health summary to <outDataDir>.scrape-health.json
+func (s *Scraper) Stop() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	s.wg.Wait()
+	s.stop = nil
+
+	health := s.Health()
+	for _, h := range health {
+		log.Printf("scrape health of %s: %d succeeded, %d failed, latency(ms) mean %.1f p99 %d max %d",
+			h.Target, h.Successes, h.Failures, h.MeanLatency, h.P99Latency, h.MaxLatency)
+	}
+	if len(health) == 0 {
+		return
+	}
+	byts, err := json.MarshalIndent(health, "", "  ")
+	if err != nil {
+		log.Printf("fail to marshal scrape health: %s", err)
+		return
+	}
+	healthPath := path.Join(s.OutDataDir, fmt.Sprintf("%s.scrape-health.json", path.Base(s.OutDataDir)))
+	if err := ioutil.WriteFile(healthPath, byts, 0644); err != nil {
+		log.Printf("fail to write scrape health: %s", err)
+	}
+}
+
+// Health returns the scrape-health summary of every target
+func (s *Scraper) Health() []ScrapeHealth {
+	s.healthLock.Lock()
+	defer s.healthLock.Unlock()
+	var ret []ScrapeHealth
+	for _, t := range s.Targets {
+		h, exist := s.health[t.Name]
+		if !exist {
+			continue
+		}
+		summary := *h
+		summary.MeanLatency = h.latencies.Mean()
+		summary.P50Latency = h.latencies.ValueAtQuantile(50)
+		summary.P99Latency = h.latencies.ValueAtQuantile(99)
+		summary.MaxLatency = h.latencies.Max()
+		ret = append(ret, summary)
+	}
+	return ret
+}
+
+func (s *Scraper) recordHealth(target string, latency time.Duration, err error) {
+	s.healthLock.Lock()
+	defer s.healthLock.Unlock()
+	h := s.health[target]
+	if err != nil {
+		h.Failures++
+		h.LastError = err.Error()
+		return
+	}
+	h.Successes++
+	h.latencies.RecordValue(int64(latency / time.Millisecond))
+}
+
+func (s *Scraper) run(t ScrapeTarget) {
+	defer s.wg.Done()
+	outPath := path.Join(s.OutDataDir, t.OutFile)
+	f, err := os.OpenFile(outPath, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("error scrape %s: %s", t.Name, err)
+		return
+	}
+	defer f.Close()
+	log.Printf("start scraping %s(%s) every %d seconds to %s", t.Name, t.Addr, t.Interval, outPath)
+
+	var w io.Writer = f
+	flush := func() error { return nil }
+	if t.Compress {
+		gz := gzip.NewWriter(f)
+		defer gz.Close()
+		w, flush = gz, gz.Flush
+	}
+	if _, err := fmt.Fprintf(w, "---------- start at %d ----------\n", time.Now().Unix()); err != nil {
+		log.Printf("error scrape %s: %s", t.Name, err)
+		return
+	}
+	cli, err := t.httpClient()
+	if err != nil {
+		log.Printf("error scrape %s: %s", t.Name, err)
+		return
+	}
+	interval := time.Duration(t.Interval) * time.Second
+	backoff := scrapeInitialBackoff
+	for {
+		select {
+		case <-s.stop:
+			fmt.Fprintf(w, "---------- end at %d ----------\n", time.Now().Unix())
+			return
+		default:
+		}
+
+		start := time.Now()
+		byts, err := scrapeOnce(cli, t.Addr, t.filter.keep)
+		s.recordHealth(t.Name, time.Since(start), err)
+		wait := interval
+		if err != nil {
+			// record the gap and retry with exponential backoff, which is
+			// capped by the scraping interval
+			log.Printf("error scrape %s, will retry in %s: %s", t.Name, backoff, err)
+			if _, wrtErr := fmt.Fprintf(w, "---------- gap at %d: %s ----------\n",
+				time.Now().Unix(), strings.ReplaceAll(err.Error(), "\n", " ")); wrtErr != nil {
+				log.Printf("error scrape %s: %s", t.Name, wrtErr)
+				return
+			}
+			wait = backoff
+			backoff *= 2
+			if backoff > interval {
+				backoff = interval
+			}
+		} else {
+			backoff = scrapeInitialBackoff
+			if _, wrtErr := w.Write(byts); wrtErr != nil {
+				log.Printf("error scrape %s: %s", t.Name, wrtErr)
+				return
+			}
+			if _, wrtErr := fmt.Fprintf(w, "---------- record at %d ----------\n", time.Now().Unix()); wrtErr != nil {
+				log.Printf("error scrape %s: %s", t.Name, wrtErr)
+				return
+			}
+		}
+		if err := flush(); err != nil {
+			log.Printf("error scrape %s: %s", t.Name, err)
+			return
+		}
+		select {
+		case <-s.stop:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// scrapeOnce reads the metrics exposed at addr and keeps the lines
+// accepted by keep. The whole response is read before returning, so a
+// failed scrape never leaves a partial record.
+func scrapeOnce(cli *http.Client, addr string, keep func(line string) bool) ([]byte, error) {
+	rep, err := cli.Get(addr)
+	if err != nil {
+		return nil, err
+	}
+	defer rep.Body.Close()
+	if rep.StatusCode != http.StatusOK {
+		// drain the body so that the connection can be reused
+		io.Copy(ioutil.Discard, rep.Body)
+		return nil, fmt.Errorf("unexpected status %s", rep.Status)
+	}
+	buf := &bytes.Buffer{}
+	scanner := bufio.NewScanner(rep.Body)
+	for scanner.Scan() {
+		if keep(scanner.Text()) {
+			buf.WriteString(scanner.Text() + "\n")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.New("fail to read response: " + err.Error())
+	}
+	return buf.Bytes(), nil
+}
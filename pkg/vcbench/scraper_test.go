@@ -0,0 +1,110 @@
+package vcbench
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestScraperRecordsGapsAndRecovers(t *testing.T) {
+	var (
+		lock     sync.Mutex
+		requests int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		requests++
+		// the first scrape fails as if the syncer is restarting
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("# TYPE test_metric counter\ntest_metric 1\n"))
+	}))
+	defer srv.Close()
+
+	outDataDir, err := ioutil.TempDir("", "scraper")
+	if err != nil {
+		t.Fatalf("fail to create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(outDataDir)
+
+	s, err := NewScraper(outDataDir, []ScrapeTarget{{Name: "test", Addr: srv.URL, Interval: 1}})
+	if err != nil {
+		t.Fatalf("fail to create scraper: %s", err)
+	}
+	s.Start()
+	<-time.After(1500 * time.Millisecond)
+	s.Stop()
+
+	byts, err := ioutil.ReadFile(path.Join(outDataDir, s.Targets[0].OutFile))
+	if err != nil {
+		t.Fatalf("fail to read metrics file: %s", err)
+	}
+	content := string(byts)
+	if !strings.Contains(content, "gap at") || !strings.Contains(content, "503") {
+		t.Fatalf("gap marker not found in:\n%s", content)
+	}
+	if !strings.Contains(content, "test_metric 1") || !strings.Contains(content, "end at") {
+		t.Fatalf("scraper didn't recover from the failure:\n%s", content)
+	}
+
+	health := s.Health()
+	if len(health) != 1 || health[0].Failures != 1 || health[0].Successes == 0 {
+		t.Fatalf("unexpected scrape health: %+v", health)
+	}
+	if _, err := os.Stat(path.Join(outDataDir, path.Base(outDataDir)+".scrape-health.json")); err != nil {
+		t.Fatalf("scrape health summary not written: %s", err)
+	}
+}
+
+func TestScrapeTLSWithBearerToken(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("test_metric 1\n"))
+	}))
+	defer srv.Close()
+
+	tmpDir, err := ioutil.TempDir("", "scraper")
+	if err != nil {
+		t.Fatalf("fail to create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	caFile := path.Join(tmpDir, "ca.crt")
+	caPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, caPem, 0644); err != nil {
+		t.Fatalf("fail to write ca file: %s", err)
+	}
+
+	s, err := NewScraper(tmpDir, []ScrapeTarget{
+		{Name: "authorized", Addr: srv.URL, CAFile: caFile, BearerToken: "secret"},
+		{Name: "unauthorized", Addr: srv.URL, CAFile: caFile},
+	})
+	if err != nil {
+		t.Fatalf("fail to create scraper: %s", err)
+	}
+	for _, target := range s.Targets {
+		cli, err := target.httpClient()
+		if err != nil {
+			t.Fatalf("fail to build http client for %s: %s", target.Name, err)
+		}
+		_, err = scrapeOnce(cli, target.Addr, target.filter.keep)
+		if target.Name == "authorized" && err != nil {
+			t.Fatalf("fail to scrape with bearer token: %s", err)
+		}
+		if target.Name == "unauthorized" && err == nil {
+			t.Fatalf("scrape without bearer token should fail")
+		}
+	}
+}
@@ -0,0 +1,469 @@
+package vcbench
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	tenancyv1alpha1 "sigs.k8s.io/multi-tenancy/incubator/virtualcluster/pkg/apis/tenancy/v1alpha1"
+	syncerconst "sigs.k8s.io/multi-tenancy/incubator/virtualcluster/pkg/syncer/constants"
+	"sigs.k8s.io/multi-tenancy/incubator/virtualcluster/pkg/syncer/conversion"
+
+	"github.com/charleszheng44/vc-bench/pkg/util/perftimestamp"
+)
+
+const (
+	// QueueFIFO serves the pods of all vc in the order they are queued,
+	// QueueFair serves the vc with queued pods in turn
+	QueueFIFO = "fifo"
+	QueueFair = "fair"
+
+	DefaultSimSyncerWorkers = 20
+	defaultSimPollInterval  = 10 * time.Millisecond
+)
+
+// SimSyncerOptions configures the queueing model of a SimSyncer
+type SimSyncerOptions struct {
+	// Queue is one of "fifo" or "fair", for both the downward and upward
+	// queues
+	Queue string `json:"queue"`
+	// Workers is the number of workers of each queue
+	Workers int `json:"workers"`
+	// ServiceTime is the time a worker takes to reconcile a pod
+	ServiceTime LatencyDistribution `json:"serviceTime"`
+	// ReadyLatency is the time a pod takes from being created on the super
+	// cluster to being Ready
+	ReadyLatency LatencyDistribution `json:"readyLatency"`
+	// TimeScale stretches the time stamped on pods, e.g. with a TimeScale of
+	// 1000, a service time of 1ms is stamped as 1s. As the timestamps are in
+	// seconds, it lets a simulation run faster than the real syncer.
+	TimeScale float64 `json:"timeScale"`
+	// PollInterval is the interval of listing the pods, as the in-memory
+	// clusters can not be watched
+	PollInterval time.Duration `json:"-"`
+}
+
+func (opts *SimSyncerOptions) setDefaults() {
+	if opts.Queue == "" {
+		opts.Queue = QueueFair
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultSimSyncerWorkers
+	}
+	if opts.TimeScale <= 0 {
+		opts.TimeScale = 1
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = defaultSimPollInterval
+	}
+}
+
+// SimSyncer simulates the syncer between in-memory clusters: the downward
+// workers copy the pods of every vc to the super cluster, the pods get
+// Ready after the ReadyLatency, and the upward workers copy their status
+// back. The vc.perfbench.syncer/* annotations are stamped on the way as the
+// real syncer does, so the benchmark runs end to end offline.
+type SimSyncer struct {
+	Super   client.Client
+	Options SimSyncerOptions
+
+	sync.Mutex
+	// clusters are the clients of vc keyed by their cluster keys
+	clusters map[string]client.Client
+	dws, uws *simQueue
+	start    time.Time
+}
+
+func NewSimSyncer(super client.Client, opts SimSyncerOptions) (*SimSyncer, error) {
+	opts.setDefaults()
+	if opts.Queue != QueueFIFO && opts.Queue != QueueFair {
+		return nil, fmt.Errorf("unknown queue %q", opts.Queue)
+	}
+	return &SimSyncer{
+		Super:    super,
+		Options:  opts,
+		clusters: make(map[string]client.Client),
+		dws:      newSimQueue(opts.Queue == QueueFair),
+		uws:      newSimQueue(opts.Queue == QueueFair),
+		start:    time.Now(),
+	}, nil
+}
+
+// AddCluster syncs the pods of the vc accessed by cli
+func (ss *SimSyncer) AddCluster(vc *tenancyv1alpha1.VirtualCluster, cli client.Client) {
+	ss.Lock()
+	defer ss.Unlock()
+	ss.clusters[conversion.ToClusterKey(vc)] = cli
+}
+
+// AddFakeBackend syncs every vc of the backend
+func (ss *SimSyncer) AddFakeBackend(fb *FakeBackend) error {
+	vcLst := &tenancyv1alpha1.VirtualClusterList{}
+	if err := fb.Tenant.List(context.TODO(), vcLst); err != nil {
+		return err
+	}
+	for i := range vcLst.Items {
+		ss.AddCluster(&vcLst.Items[i], fb.VCCluster(vcLst.Items[i].GetName()))
+	}
+	return nil
+}
+
+// now returns the scaled unix time in seconds
+func (ss *SimSyncer) now() int64 {
+	return ss.start.Unix() + int64(time.Since(ss.start).Seconds()*ss.Options.TimeScale)
+}
+
+// Run starts the workers and polls the pods until the stop channel is
+// closed
+func (ss *SimSyncer) Run(stopCh <-chan struct{}) {
+	var wg sync.WaitGroup
+	for i := 0; i < ss.Options.Workers; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for ss.processDownward() {
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for ss.processUpward() {
+			}
+		}()
+	}
+	ticker := time.NewTicker(ss.Options.PollInterval)
+	defer ticker.Stop()
+	for {
+		ss.poll()
+		select {
+		case <-ticker.C:
+		case <-stopCh:
+			ss.dws.shutDown()
+			ss.uws.shutDown()
+			wg.Wait()
+			return
+		}
+	}
+}
+
+// poll queues the pods of every vc that are not synced yet, and the pods
+// on the super cluster whose pods on the vc are deleted. The namespaces on
+// the super cluster are deleted with their namespaces on the vc.
+func (ss *SimSyncer) poll() {
+	ss.Lock()
+	clusters := make(map[string]client.Client, len(ss.clusters))
+	for key, cli := range ss.clusters {
+		clusters[key] = cli
+	}
+	ss.Unlock()
+
+	for key, cli := range clusters {
+		pl := &v1.PodList{}
+		if err := cli.List(context.TODO(), pl); err != nil {
+			log.Printf("fail to list pods of cluster %s: %s", key, err)
+			continue
+		}
+		for i := range pl.Items {
+			pod := &pl.Items[i]
+			if _, synced := pod.GetAnnotations()[perftimestamp.AnnotationKey(perftimestamp.StageSuperCreation)]; synced {
+				continue
+			}
+			// the in-memory cluster does not stamp the creation
+			if pod.CreationTimestamp.IsZero() {
+				pod.SetCreationTimestamp(metav1.Unix(ss.now(), 0))
+				if err := cli.Update(context.TODO(), pod); err != nil {
+					log.Printf("fail to update %s/pod(%s): %s", pod.GetNamespace(), pod.GetName(), err)
+					continue
+				}
+			}
+			ss.dws.add(simItem{cluster: key, namespace: pod.GetNamespace(), name: pod.GetName()})
+		}
+	}
+
+	pl := &v1.PodList{}
+	if err := ss.Super.List(context.TODO(), pl, client.HasLabels{syncerconst.LabelCluster}); err != nil {
+		log.Printf("fail to list pods of the super cluster: %s", err)
+		return
+	}
+	for _, pod := range pl.Items {
+		key := pod.GetLabels()[syncerconst.LabelCluster]
+		cli, exist := clusters[key]
+		if !exist {
+			continue
+		}
+		item := simItem{cluster: key, namespace: pod.GetLabels()[syncerconst.LabelNamespace], name: pod.GetName()}
+		err := cli.Get(context.TODO(), types.NamespacedName{Namespace: item.namespace, Name: item.name}, &v1.Pod{})
+		if apierrors.IsNotFound(err) {
+			ss.dws.add(item)
+		}
+	}
+
+	nsl := &v1.NamespaceList{}
+	if err := ss.Super.List(context.TODO(), nsl, client.HasLabels{syncerconst.LabelCluster}); err != nil {
+		log.Printf("fail to list namespaces of the super cluster: %s", err)
+		return
+	}
+	for i := range nsl.Items {
+		ns := &nsl.Items[i]
+		cli, exist := clusters[ns.GetLabels()[syncerconst.LabelCluster]]
+		if !exist {
+			continue
+		}
+		err := cli.Get(context.TODO(), types.NamespacedName{Name: ns.GetLabels()[syncerconst.LabelNamespace]}, &v1.Namespace{})
+		if !apierrors.IsNotFound(err) {
+			continue
+		}
+		if err := ss.Super.Delete(context.TODO(), ns); err != nil && !apierrors.IsNotFound(err) {
+			log.Printf("fail to delete namespace %s of the super cluster: %s", ns.GetName(), err)
+		}
+	}
+}
+
+func (ss *SimSyncer) clusterClient(key string) client.Client {
+	ss.Lock()
+	defer ss.Unlock()
+	return ss.clusters[key]
+}
+
+// processDownward creates the pod of the vc on the super cluster, or
+// deletes the pod on the super cluster if the pod of the vc is deleted
+func (ss *SimSyncer) processDownward() bool {
+	item, ok := ss.dws.get()
+	if !ok {
+		return false
+	}
+	defer ss.dws.done(item)
+	dequeued := ss.now()
+	time.Sleep(ss.Options.ServiceTime.Sample())
+
+	cli := ss.clusterClient(item.cluster)
+	superNs := conversion.ToSuperMasterNamespace(item.cluster, item.namespace)
+	vPod := &v1.Pod{}
+	err := cli.Get(context.TODO(), types.NamespacedName{Namespace: item.namespace, Name: item.name}, vPod)
+	if apierrors.IsNotFound(err) {
+		err := ss.Super.Delete(context.TODO(), &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: superNs, Name: item.name}})
+		if err != nil && !apierrors.IsNotFound(err) {
+			log.Printf("fail to delete %s/pod(%s) of the super cluster: %s", superNs, item.name, err)
+		}
+		return true
+	}
+	if err != nil {
+		log.Printf("fail to get %s/pod(%s) of cluster %s: %s", item.namespace, item.name, item.cluster, err)
+		return true
+	}
+
+	ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+		Name: superNs,
+		Labels: map[string]string{
+			syncerconst.LabelCluster:   item.cluster,
+			syncerconst.LabelNamespace: item.namespace,
+		},
+	}}
+	if err := ss.Super.Create(context.TODO(), ns); err != nil && !apierrors.IsAlreadyExists(err) {
+		log.Printf("fail to create namespace %s of the super cluster: %s", superNs, err)
+		return true
+	}
+	pPod := vPod.DeepCopy()
+	pPod.ObjectMeta = metav1.ObjectMeta{
+		Name:        vPod.GetName(),
+		Namespace:   superNs,
+		Annotations: make(map[string]string),
+		Labels: map[string]string{
+			syncerconst.LabelCluster:   item.cluster,
+			syncerconst.LabelNamespace: item.namespace,
+		},
+	}
+	for k, v := range vPod.GetAnnotations() {
+		pPod.Annotations[k] = v
+	}
+	if err := ss.Super.Create(context.TODO(), pPod); err != nil && !apierrors.IsAlreadyExists(err) {
+		log.Printf("fail to create %s/pod(%s) of the super cluster: %s", superNs, item.name, err)
+		return true
+	}
+	perftimestamp.StageTimeline{
+		perftimestamp.StageDWSReconcile:  dequeued,
+		perftimestamp.StageSuperCreation: ss.now(),
+	}.Encode(vPod)
+	if err := cli.Update(context.TODO(), vPod); err != nil {
+		log.Printf("fail to update %s/pod(%s) of cluster %s: %s", item.namespace, item.name, item.cluster, err)
+		return true
+	}
+	time.AfterFunc(ss.Options.ReadyLatency.Sample(), func() { ss.readyPod(item) })
+	return true
+}
+
+// readyPod gets the pod on the super cluster Ready, as the kubelet would,
+// and queues it to be synced upward
+func (ss *SimSyncer) readyPod(item simItem) {
+	superNs := conversion.ToSuperMasterNamespace(item.cluster, item.namespace)
+	pPod := &v1.Pod{}
+	if err := ss.Super.Get(context.TODO(), types.NamespacedName{Namespace: superNs, Name: item.name}, pPod); err != nil {
+		if !apierrors.IsNotFound(err) {
+			log.Printf("fail to get %s/pod(%s) of the super cluster: %s", superNs, item.name, err)
+		}
+		return
+	}
+	ready := ss.now()
+	now := metav1.Unix(ready, 0)
+	pPod.Status.Phase = v1.PodRunning
+	pPod.Status.StartTime = &now
+	pPod.Status.Conditions = nil
+	for _, ct := range []v1.PodConditionType{v1.PodScheduled, v1.PodInitialized, v1.ContainersReady, v1.PodReady} {
+		pPod.Status.Conditions = append(pPod.Status.Conditions, v1.PodCondition{
+			Type:               ct,
+			Status:             v1.ConditionTrue,
+			LastTransitionTime: now,
+		})
+	}
+	perftimestamp.StageTimeline{perftimestamp.StageSuperReady: ready}.Encode(pPod)
+	if err := ss.Super.Update(context.TODO(), pPod); err != nil {
+		log.Printf("fail to update %s/pod(%s) of the super cluster: %s", superNs, item.name, err)
+		return
+	}
+	ss.uws.add(item)
+}
+
+// processUpward copies the status of the pod on the super cluster to the
+// pod of the vc
+func (ss *SimSyncer) processUpward() bool {
+	item, ok := ss.uws.get()
+	if !ok {
+		return false
+	}
+	defer ss.uws.done(item)
+	dequeued := ss.now()
+	time.Sleep(ss.Options.ServiceTime.Sample())
+
+	superNs := conversion.ToSuperMasterNamespace(item.cluster, item.namespace)
+	pPod := &v1.Pod{}
+	if err := ss.Super.Get(context.TODO(), types.NamespacedName{Namespace: superNs, Name: item.name}, pPod); err != nil {
+		if !apierrors.IsNotFound(err) {
+			log.Printf("fail to get %s/pod(%s) of the super cluster: %s", superNs, item.name, err)
+		}
+		return true
+	}
+	cli := ss.clusterClient(item.cluster)
+	vPod := &v1.Pod{}
+	if err := cli.Get(context.TODO(), types.NamespacedName{Namespace: item.namespace, Name: item.name}, vPod); err != nil {
+		if !apierrors.IsNotFound(err) {
+			log.Printf("fail to get %s/pod(%s) of cluster %s: %s", item.namespace, item.name, item.cluster, err)
+		}
+		return true
+	}
+	vPod.Status = pPod.Status
+	tl := perftimestamp.StageTimeline{
+		perftimestamp.StageUWSReconcile: dequeued,
+		perftimestamp.StageFirstUpdate:  ss.now(),
+	}
+	if superTl, _ := perftimestamp.ParseTimeline(pPod); superTl.Has(perftimestamp.StageSuperReady) {
+		tl[perftimestamp.StageSuperReady] = superTl[perftimestamp.StageSuperReady]
+	}
+	tl.Encode(vPod)
+	if err := cli.Update(context.TODO(), vPod); err != nil {
+		log.Printf("fail to update %s/pod(%s) of cluster %s: %s", item.namespace, item.name, item.cluster, err)
+	}
+	return true
+}
+
+// simItem is a pod queued by the SimSyncer
+type simItem struct {
+	cluster   string
+	namespace string
+	name      string
+}
+
+// simQueue is a queue of pods without duplicates, a pod being processed is
+// not queued again until it is done. If fair, the clusters with queued pods
+// are served in turn, otherwise pods are served in the order they are
+// queued.
+type simQueue struct {
+	sync.Mutex
+	cond       *sync.Cond
+	fair       bool
+	queued     map[simItem]bool
+	processing map[simItem]bool
+	fifo       []simItem
+	clusters   map[string][]simItem
+	// turns lists the clusters with queued pods in the order they are
+	// served
+	turns    []string
+	shutdown bool
+}
+
+func newSimQueue(fair bool) *simQueue {
+	q := &simQueue{
+		fair:       fair,
+		queued:     make(map[simItem]bool),
+		processing: make(map[simItem]bool),
+		clusters:   make(map[string][]simItem),
+	}
+	q.cond = sync.NewCond(&q.Mutex)
+	return q
+}
+
+func (q *simQueue) add(item simItem) {
+	q.Lock()
+	defer q.Unlock()
+	if q.shutdown || q.queued[item] || q.processing[item] {
+		return
+	}
+	q.queued[item] = true
+	if q.fair {
+		if len(q.clusters[item.cluster]) == 0 {
+			q.turns = append(q.turns, item.cluster)
+		}
+		q.clusters[item.cluster] = append(q.clusters[item.cluster], item)
+	} else {
+		q.fifo = append(q.fifo, item)
+	}
+	q.cond.Signal()
+}
+
+// get blocks until a pod is queued, it returns false if the queue is shut
+// down
+func (q *simQueue) get() (simItem, bool) {
+	q.Lock()
+	defer q.Unlock()
+	for len(q.queued) == 0 && !q.shutdown {
+		q.cond.Wait()
+	}
+	if q.shutdown {
+		return simItem{}, false
+	}
+	var item simItem
+	if q.fair {
+		cluster := q.turns[0]
+		item = q.clusters[cluster][0]
+		q.clusters[cluster] = q.clusters[cluster][1:]
+		q.turns = q.turns[1:]
+		if len(q.clusters[cluster]) != 0 {
+			q.turns = append(q.turns, cluster)
+		}
+	} else {
+		item, q.fifo = q.fifo[0], q.fifo[1:]
+	}
+	delete(q.queued, item)
+	q.processing[item] = true
+	return item, true
+}
+
+// done marks the pod is processed
+func (q *simQueue) done(item simItem) {
+	q.Lock()
+	defer q.Unlock()
+	delete(q.processing, item)
+}
+
+func (q *simQueue) shutDown() {
+	q.Lock()
+	defer q.Unlock()
+	q.shutdown = true
+	q.cond.Broadcast()
+}
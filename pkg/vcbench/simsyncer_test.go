@@ -0,0 +1,113 @@
+package vcbench
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/api/core/v1"
+
+	"github.com/charleszheng44/vc-bench/pkg/tenant"
+)
+
+func TestSimQueue(t *testing.T) {
+	items := []simItem{
+		{cluster: "a", name: "1"}, {cluster: "a", name: "2"}, {cluster: "a", name: "3"},
+		{cluster: "b", name: "1"},
+		// duplicated
+		{cluster: "a", name: "1"},
+	}
+	for _, c := range []struct {
+		fair   bool
+		expect []string
+	}{
+		{fair: false, expect: []string{"a/1", "a/2", "a/3", "b/1"}},
+		{fair: true, expect: []string{"a/1", "b/1", "a/2", "a/3"}},
+	} {
+		q := newSimQueue(c.fair)
+		for _, item := range items {
+			q.add(item)
+		}
+		for i, expect := range c.expect {
+			item, ok := q.get()
+			if !ok || item.cluster+"/"+item.name != expect {
+				t.Errorf("fair(%v): expect %s at %d, got %+v", c.fair, expect, i, item)
+			}
+			// a pod being processed is not queued again
+			q.add(item)
+			q.done(item)
+		}
+		q.shutDown()
+		if _, ok := q.get(); ok {
+			t.Errorf("fair(%v): expect the queue to be empty and shut down", c.fair)
+		}
+	}
+}
+
+func TestSimSyncerEndToEnd(t *testing.T) {
+	fb := NewFakeBackend()
+	for _, name := range []string{"vc1", "vc2"} {
+		if _, err := fb.AddVirtualCluster("default", name); err != nil {
+			t.Fatalf("fail to add vc: %s", err)
+		}
+	}
+	superCli := fb.Super
+	ss, err := NewSimSyncer(superCli, SimSyncerOptions{
+		Queue:        QueueFIFO,
+		Workers:      1,
+		ServiceTime:  LatencyDistribution{Kind: "fixed", Min: 2 * time.Millisecond},
+		ReadyLatency: LatencyDistribution{Kind: "fixed", Min: 5 * time.Millisecond},
+		TimeScale:    1000,
+	})
+	if err != nil {
+		t.Fatalf("fail to build simulated syncer: %s", err)
+	}
+	if err := ss.AddFakeBackend(fb); err != nil {
+		t.Fatalf("fail to add clusters: %s", err)
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go ss.Run(stopCh)
+
+	be, err := NewBenchExecutorWithBackend(fb, []tenant.Tenant{{ID: "t1", NumPods: 3}, {ID: "t2", NumPods: 3}}, 0, 0, 2)
+	if err != nil {
+		t.Fatalf("fail to build executor: %s", err)
+	}
+	be.NamespaceWait = 0
+	be.PollInterval = 10 * time.Millisecond
+	done := make(chan error)
+	go func() { done <- be.RunBench() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("fail to run benchmark: %s", err)
+		}
+	case <-time.After(20 * time.Second):
+		t.Fatalf("benchmark is not complete")
+	}
+
+	for pn, rs := range be.RuntimeStatics {
+		if !rs.PodCreated || rs.TenantCreation > rs.DwsDequeue || rs.DwsDequeue > rs.SuperCreation ||
+			rs.SuperCreation > rs.SuperReady || rs.SuperReady > rs.UwsDequeue || rs.UwsDequeue > rs.SuperUpdate {
+			t.Errorf("unexpected lifecycle of %s: %+v", pn, rs)
+		}
+	}
+	// 6 pods are served by a single worker, the last one is synced after at
+	// least 6 service times, i.e. 12 scaled seconds
+	if h := be.Histograms.Scopes[ScopeAll][StageTotal]; h == nil || h.TotalCount() != 6 || h.Max() < 12000 {
+		t.Errorf("unexpected histogram of the total latency")
+	}
+
+	result := be.CleanUp(CleanUpOptions{
+		Namespace:    DefaultBenchNamespace,
+		Timeout:      5 * time.Second,
+		PollInterval: 10 * time.Millisecond,
+	})
+	if len(result.Errors) != 0 || len(result.Leftovers) != 0 {
+		t.Errorf("unexpected clean up result %+v", result)
+	}
+	pl := &v1.PodList{}
+	if err := superCli.List(context.TODO(), pl); err != nil || len(pl.Items) != 0 {
+		t.Errorf("expect pods on the super cluster to be deleted, got %d %v", len(pl.Items), err)
+	}
+}
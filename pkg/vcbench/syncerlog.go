@@ -0,0 +1,246 @@
+package vcbench
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const (
+	// DefaultSyncerPod and DefaultSyncerNamespace locate the syncer whose
+	// logs are analyzed
+	DefaultSyncerPod       = "vc-syncer-0"
+	DefaultSyncerNamespace = "vc-manager"
+
+	markerGroupPod = "pod"
+	markerGroupTs  = "ts"
+)
+
+// LogMarker is a line of the syncer log that marks a point of the lifecycle
+// of a pod. Pattern must contain the named groups "pod", which matches the
+// name (or namespace/name) of the pod, and "ts", which matches the
+// timestamp, either as unix time in seconds, milliseconds, microseconds or
+// nanoseconds, or in RFC3339 format.
+type LogMarker struct {
+	// Name is the column name of the marker in the runtime data log
+	Name    string `yaml:"name"`
+	Pattern string `yaml:"pattern"`
+}
+
+// SyncerLogConfig lists the markers to be parsed from the syncer log
+type SyncerLogConfig struct {
+	Markers []LogMarker `yaml:"markers"`
+}
+
+// DefaultLogMarkers are the markers logged by the instrumented syncer,
+// e.g. `SUPER_BIND [<pod>] <unix time>`
+var DefaultLogMarkers = []LogMarker{
+	{Name: "superBind", Pattern: `SUPER_BIND.*\[(?P<pod>[^\]]+)\]\D*(?P<ts>\d+)`},
+	{Name: "createVNode", Pattern: `CREATE_VNODE.*\[(?P<pod>[^\]]+)\]\D*(?P<ts>\d+)`},
+	{Name: "justBindVPod", Pattern: `JUST_BIND_VPOD.*\[(?P<pod>[^\]]+)\]\D*(?P<ts>\d+)`},
+	{Name: "getNewVPod", Pattern: `GET_NEW_VPOD.*\[(?P<pod>[^\]]+)\]\D*(?P<ts>\d+)`},
+}
+
+// LoadSyncerLogConfig reads the log markers from a yaml file
+func LoadSyncerLogConfig(cfgPath string) (*SyncerLogConfig, error) {
+	byts, err := ioutil.ReadFile(cfgPath)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &SyncerLogConfig{}
+	if err := yaml.UnmarshalStrict(byts, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+type markerMatcher struct {
+	name   string
+	re     *regexp.Regexp
+	podIdx int
+	tsIdx  int
+}
+
+func compileMarkers(markers []LogMarker) ([]markerMatcher, error) {
+	var ret []markerMatcher
+	for _, m := range markers {
+		if m.Name == "" {
+			return nil, fmt.Errorf("marker(%s) has no name", m.Pattern)
+		}
+		if isBuiltinColumn(m.Name) {
+			return nil, fmt.Errorf("marker %s conflicts with a column of the runtime data log", m.Name)
+		}
+		re, err := regexp.Compile(m.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("marker %s: %s", m.Name, err)
+		}
+		mm := markerMatcher{name: m.Name, re: re, podIdx: -1, tsIdx: -1}
+		for i, g := range re.SubexpNames() {
+			switch g {
+			case markerGroupPod:
+				mm.podIdx = i
+			case markerGroupTs:
+				mm.tsIdx = i
+			}
+		}
+		if mm.podIdx < 0 || mm.tsIdx < 0 {
+			return nil, fmt.Errorf("marker %s: pattern must contain the named groups %q and %q",
+				m.Name, markerGroupPod, markerGroupTs)
+		}
+		ret = append(ret, mm)
+	}
+	return ret, nil
+}
+
+// ParseSyncerLog parses the markers from the syncer log and returns the
+// timestamps (unix time in seconds) keyed by pod name and marker name. Only
+// the first occurrence of a marker of a pod is kept.
+func ParseSyncerLog(r io.Reader, markers []LogMarker) (map[string]map[string]int, error) {
+	matchers, err := compileMarkers(markers)
+	if err != nil {
+		return nil, err
+	}
+	ret := make(map[string]map[string]int)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		for _, mm := range matchers {
+			subs := mm.re.FindStringSubmatch(line)
+			if subs == nil {
+				continue
+			}
+			ts, err := parseLogTimestamp(subs[mm.tsIdx])
+			if err != nil {
+				continue
+			}
+			podName := subs[mm.podIdx]
+			// the pod may be logged as <namespace>/<name>
+			if i := strings.LastIndex(podName, "/"); i >= 0 {
+				podName = podName[i+1:]
+			}
+			if _, exist := ret[podName]; !exist {
+				ret[podName] = make(map[string]int)
+			}
+			if _, exist := ret[podName][mm.name]; !exist {
+				ret[podName][mm.name] = ts
+			}
+		}
+	}
+	return ret, scanner.Err()
+}
+
+// parseLogTimestamp converts the timestamp of a marker to unix time in
+// seconds, the unit of a numeric timestamp is inferred by its length
+func parseLogTimestamp(s string) (int, error) {
+	if v, err := strconv.ParseInt(s, 10, 64); err == nil {
+		switch {
+		case len(s) >= 19:
+			v /= int64(time.Second)
+		case len(s) >= 16:
+			v /= int64(time.Second / time.Microsecond)
+		case len(s) >= 13:
+			v /= int64(time.Second / time.Millisecond)
+		}
+		return int(v), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return 0, err
+	}
+	return int(t.Unix()), nil
+}
+
+// JoinSyncerLog adds the timestamps of markers to the runtime statics of
+// the pods with the same name, it returns the number of pods that are
+// matched and the names of the logged pods that are not part of the run
+func JoinSyncerLog(rsMap map[string]*RuntimeStatics, stamps map[string]map[string]int) (matched int, unknown []string) {
+	for podName, markers := range stamps {
+		rs, exist := rsMap[podName]
+		if !exist {
+			unknown = append(unknown, podName)
+			continue
+		}
+		matched++
+		if rs.Extra == nil {
+			rs.Extra = make(map[string]int)
+		}
+		for name, ts := range markers {
+			rs.Extra[name] = ts
+		}
+	}
+	sort.Strings(unknown)
+	return
+}
+
+// StreamPodLogs streams the logs of a pod through the apiserver of the
+// cluster in the kubeconfig
+func StreamPodLogs(kbCfgPath, namespace, podName, container string) (io.ReadCloser, error) {
+	restConfig, err := clientcmd.BuildConfigFromFlags("", kbCfgPath)
+	if err != nil {
+		return nil, err
+	}
+	cs, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	return cs.CoreV1().Pods(namespace).GetLogs(podName, &v1.PodLogOptions{Container: container}).Stream(context.TODO())
+}
+
+// AddSyncerLogStages joins the markers of the syncer log to the runtime
+// data log of the run in outDataDir, and rewrites the log with a column
+// per marker
+func AddSyncerLogStages(outDataDir string, syncerLog io.Reader, markers []LogMarker) (matched int, unknown []string, err error) {
+	logPath, err := FindOutDataFile(outDataDir, ".log")
+	if err != nil {
+		return 0, nil, err
+	}
+	rsLst, err := LoadRuntimeStatics(logPath)
+	if err != nil {
+		return 0, nil, err
+	}
+	stamps, err := ParseSyncerLog(syncerLog, markers)
+	if err != nil {
+		return 0, nil, err
+	}
+	rsMap := make(map[string]*RuntimeStatics)
+	for _, rs := range rsLst {
+		rsMap[rs.PodName] = rs
+	}
+	matched, unknown = JoinSyncerLog(rsMap, stamps)
+
+	// the merged log is written to a temporary file, which replaces the log
+	// only on success, so that the original results are never lost
+	tmpFd, err := ioutil.TempFile(path.Dir(logPath), path.Base(logPath)+".tmp")
+	if err != nil {
+		return matched, unknown, err
+	}
+	defer os.Remove(tmpFd.Name())
+	if err := tmpFd.Chmod(0644); err != nil {
+		tmpFd.Close()
+		return matched, unknown, err
+	}
+	// the durations between the stages of the syncer are not changed
+	if err := WriteRuntimeStatics(tmpFd, ioutil.Discard, rsMap); err != nil {
+		tmpFd.Close()
+		return matched, unknown, err
+	}
+	if err := tmpFd.Close(); err != nil {
+		return matched, unknown, err
+	}
+	return matched, unknown, os.Rename(tmpFd.Name(), logPath)
+}
@@ -0,0 +1,131 @@
+package vcbench
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+const testSyncerLog = `I1012 10:00:01.000000       1 dws.go:100] SUPER_BIND [default/vc1-t1-pod0] 1602496801
+I1012 10:00:02.000000       1 dws.go:100] SUPER_BIND [default/vc1-t1-pod0] 1602496802
+I1012 10:00:02.000000       1 uws.go:200] CREATE_VNODE [vc1-t1-pod0] 1602496802000
+I1012 10:00:03.000000       1 uws.go:210] JUST_BIND_VPOD [vc1-t1-pod0] 1602496803000000000
+I1012 10:00:03.000000       1 dws.go:100] SUPER_BIND [vc9-t9-pod0] 1602496803
+I1012 10:00:04.000000       1 dws.go:100] unrelated line
+`
+
+func TestParseSyncerLog(t *testing.T) {
+	stamps, err := ParseSyncerLog(strings.NewReader(testSyncerLog), DefaultLogMarkers)
+	if err != nil {
+		t.Fatalf("fail to parse syncer log: %s", err)
+	}
+	pod := stamps["vc1-t1-pod0"]
+	// the first occurrence is kept and all units are converted to seconds
+	if pod["superBind"] != 1602496801 || pod["createVNode"] != 1602496802 || pod["justBindVPod"] != 1602496803 {
+		t.Fatalf("unexpected timestamps %v", pod)
+	}
+	if _, exist := pod["getNewVPod"]; exist {
+		t.Fatalf("unexpected getNewVPod timestamp")
+	}
+
+	rsMap := map[string]*RuntimeStatics{"vc1-t1-pod0": {PodName: "vc1-t1-pod0"}}
+	matched, unknown := JoinSyncerLog(rsMap, stamps)
+	if matched != 1 || len(unknown) != 1 || unknown[0] != "vc9-t9-pod0" {
+		t.Fatalf("unexpected join result: %d matched, unknown %v", matched, unknown)
+	}
+
+	if _, err := ParseSyncerLog(strings.NewReader(""), []LogMarker{{Name: "x", Pattern: `X (?P<pod>\S+)`}}); err == nil {
+		t.Fatalf("expect error for pattern without ts group")
+	}
+	if _, err := ParseSyncerLog(strings.NewReader(""), []LogMarker{{Name: colSuperCreation, Pattern: `(?P<pod>\S+) (?P<ts>\d+)`}}); err == nil {
+		t.Fatalf("expect error for marker named after a builtin column")
+	}
+}
+
+func TestExtraColumnsRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syncerlog")
+	if err != nil {
+		t.Fatalf("fail to create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	rsMap := map[string]*RuntimeStatics{
+		"vc1-t1-pod0": {PodName: "vc1-t1-pod0", TenantCreation: 1, Extra: map[string]int{"superBind": 3}},
+		"vc1-t1-pod1": {PodName: "vc1-t1-pod1", TenantCreation: 2},
+	}
+	logBuf := &bytes.Buffer{}
+	if err := WriteRuntimeStatics(logBuf, ioutil.Discard, rsMap); err != nil {
+		t.Fatalf("fail to write runtime statics: %s", err)
+	}
+	if !strings.HasPrefix(logBuf.String(), "#podName,tenantCreation,dwsDequeue,superCreation,superReady,uwsDequeue,tenantUpdate,superBind\n") {
+		t.Fatalf("unexpected header:\n%s", logBuf.String())
+	}
+	// the extra columns are not appended to the shared builtin columns
+	for _, col := range builtinColumns[len(builtinColumns):cap(builtinColumns)] {
+		if col != "" {
+			t.Fatalf("extra column %s is written to the builtin columns", col)
+		}
+	}
+	logPath := path.Join(dir, "run.log")
+	if err := ioutil.WriteFile(logPath, logBuf.Bytes(), 0644); err != nil {
+		t.Fatalf("fail to write log: %s", err)
+	}
+	rsLst, err := LoadRuntimeStatics(logPath)
+	if err != nil {
+		t.Fatalf("fail to load log: %s", err)
+	}
+	if len(rsLst) != 2 || rsLst[0].Extra["superBind"] != 3 || rsLst[1].Extra["superBind"] != 0 {
+		t.Fatalf("extra columns are not loaded: %+v %+v", rsLst[0], rsLst[1])
+	}
+}
+
+func TestWriteRuntimeStaticsPartialTimeline(t *testing.T) {
+	rsMap := map[string]*RuntimeStatics{
+		"vc1-t1-pod0": {PodName: "vc1-t1-pod0", TenantCreation: 100, DwsDequeue: 101, SuperCreation: 103},
+	}
+	logBuf, diffBuf := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := WriteRuntimeStatics(logBuf, diffBuf, rsMap); err != nil {
+		t.Fatalf("fail to write runtime statics: %s", err)
+	}
+	expectLog := "#podName,tenantCreation,dwsDequeue,superCreation,superReady,uwsDequeue,tenantUpdate\n" +
+		"vc1-t1-pod0,100,101,103,0,0,0\n"
+	if logBuf.String() != expectLog {
+		t.Errorf("expect log:\n%s\ngot:\n%s", expectLog, logBuf.String())
+	}
+	expectDiff := "#podName,dwsQueue,dwsProcess,superCreation,uwsQueue,tenantUpdate,total\n" +
+		"vc1-t1-pod0,1,2,-,-,-,-\n"
+	if diffBuf.String() != expectDiff {
+		t.Errorf("expect diff:\n%s\ngot:\n%s", expectDiff, diffBuf.String())
+	}
+}
+
+func TestAddSyncerLogStages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syncerlog")
+	if err != nil {
+		t.Fatalf("fail to create tmp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	logPath := path.Join(dir, path.Base(dir)+".log")
+	if err := ioutil.WriteFile(logPath, []byte("#podName,tenantCreation\nvc1-t1-pod0,1602496800\n"), 0644); err != nil {
+		t.Fatalf("fail to write log: %s", err)
+	}
+
+	matched, _, err := AddSyncerLogStages(dir, strings.NewReader(testSyncerLog), DefaultLogMarkers)
+	if err != nil || matched != 1 {
+		t.Fatalf("expect 1 pod matched, got %d: %v", matched, err)
+	}
+	rsLst, err := LoadRuntimeStatics(logPath)
+	if err != nil {
+		t.Fatalf("fail to load log: %s", err)
+	}
+	if len(rsLst) != 1 || rsLst[0].TenantCreation != 1602496800 || rsLst[0].Extra["superBind"] != 1602496801 {
+		t.Fatalf("unexpected merged log: %+v", rsLst)
+	}
+	// the temporary file is replaced over the log
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("expect only the log in %s, got %d files", dir, len(files))
+	}
+}
@@ -0,0 +1,100 @@
+package vcbench
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// traceEvent is an event of the Trace Event Format, which can be loaded by
+// chrome://tracing and Perfetto
+type traceEvent struct {
+	Name     string            `json:"name"`
+	Category string            `json:"cat,omitempty"`
+	Phase    string            `json:"ph"`
+	Ts       int64             `json:"ts"`
+	Dur      int64             `json:"dur"`
+	Pid      int               `json:"pid"`
+	Tid      int               `json:"tid"`
+	Args     map[string]string `json:"args,omitempty"`
+}
+
+type traceFile struct {
+	TraceEvents     []traceEvent `json:"traceEvents"`
+	DisplayTimeUnit string       `json:"displayTimeUnit"`
+}
+
+// ExportTrace writes the lifecycles of pods as a Trace Event Format JSON
+// to w. Each pod is a track (thread) and pods are grouped into processes
+// by vc and tenant. Stages with a missing timestamp are skipped.
+func ExportTrace(rsLst []*RuntimeStatics, w io.Writer) error {
+	sorted := make([]*RuntimeStatics, len(rsLst))
+	copy(sorted, rsLst)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].ClusterName != sorted[j].ClusterName {
+			return sorted[i].ClusterName < sorted[j].ClusterName
+		}
+		if sorted[i].TenantID != sorted[j].TenantID {
+			return sorted[i].TenantID < sorted[j].TenantID
+		}
+		if sorted[i].TenantCreation != sorted[j].TenantCreation {
+			return sorted[i].TenantCreation < sorted[j].TenantCreation
+		}
+		return sorted[i].PodName < sorted[j].PodName
+	})
+
+	tf := traceFile{
+		TraceEvents:     []traceEvent{},
+		DisplayTimeUnit: "ms",
+	}
+	pids := make(map[string]int)
+	for tid, rs := range sorted {
+		group := rs.ClusterName + "/" + rs.TenantID
+		pid, exist := pids[group]
+		if !exist {
+			pid = len(pids) + 1
+			pids[group] = pid
+			tf.TraceEvents = append(tf.TraceEvents, traceEvent{
+				Name:  "process_name",
+				Phase: "M",
+				Pid:   pid,
+				Args:  map[string]string{"name": "vc(" + rs.ClusterName + ") tenant(" + rs.TenantID + ")"},
+			})
+		}
+		tf.TraceEvents = append(tf.TraceEvents, traceEvent{
+			Name:  "thread_name",
+			Phase: "M",
+			Pid:   pid,
+			Tid:   tid + 1,
+			Args:  map[string]string{"name": rs.PodName},
+		})
+		for _, stg := range lifecycleStages {
+			from, to := stg.from(rs), stg.to(rs)
+			if from == 0 || to == 0 || to < from {
+				continue
+			}
+			tf.TraceEvents = append(tf.TraceEvents, traceEvent{
+				Name:     stg.name,
+				Category: "stage",
+				Phase:    "X",
+				Ts:       secondsToMicro(from),
+				Dur:      secondsToMicro(to - from),
+				Pid:      pid,
+				Tid:      tid + 1,
+				Args: map[string]string{
+					"pod":    rs.PodName,
+					"vc":     rs.ClusterName,
+					"tenant": rs.TenantID,
+				},
+			})
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", " ")
+	return enc.Encode(&tf)
+}
+
+func secondsToMicro(sec int) int64 {
+	return int64(sec) * 1000 * 1000
+}
@@ -0,0 +1,92 @@
+package vcbench
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/charleszheng44/vc-bench/pkg/tenant"
+)
+
+func TestSplitPodName(t *testing.T) {
+	for podName, want := range map[string]struct {
+		vc, tenantID string
+		index        int
+	}{
+		"vc60-t35-pod258":      {"vc60", "t35", 258},
+		"vc1-tenant-0-pod3":    {"vc1", "tenant-0", 3},
+		"podbench-1-pod1":      {"podbench", "1", 1},
+		"not-a-benchmark-name": {"", "", -1},
+	} {
+		vc, tenantID, index := splitPodName(podName)
+		if vc != want.vc || tenantID != want.tenantID || index != want.index {
+			t.Fatalf("splitPodName(%s): want (%s, %s, %d), get (%s, %s, %d)",
+				podName, want.vc, want.tenantID, want.index, vc, tenantID, index)
+		}
+	}
+}
+
+func TestAssignTenants(t *testing.T) {
+	rsLst := []*RuntimeStatics{{PodName: "vc-1-tenant-0-pod3"}, {PodName: "vc-2-t1-pod0"}, {PodName: "vc3-t9-pod0"}}
+	for _, rs := range rsLst {
+		rs.ClusterName, rs.TenantID, _ = splitPodName(rs.PodName)
+	}
+	AssignTenants(rsLst, []tenant.Tenant{{ID: "0"}, {ID: "tenant-0"}, {ID: "t1"}})
+	for i, want := range [][2]string{{"vc-1", "tenant-0"}, {"vc-2", "t1"}, {"vc3", "t9"}} {
+		if rsLst[i].ClusterName != want[0] || rsLst[i].TenantID != want[1] {
+			t.Errorf("%s: want (%s, %s), get (%s, %s)", rsLst[i].PodName,
+				want[0], want[1], rsLst[i].ClusterName, rsLst[i].TenantID)
+		}
+	}
+}
+
+func TestExportTrace(t *testing.T) {
+	rsLst := []*RuntimeStatics{
+		{
+			PodName:        "vc2-t2-pod0",
+			ClusterName:    "vc2",
+			TenantID:       "t2",
+			TenantCreation: 10,
+			DwsDequeue:     11,
+			SuperCreation:  12,
+			SuperReady:     15,
+			UwsDequeue:     16,
+			SuperUpdate:    17,
+		},
+		{
+			// incomplete lifecycle, only the first stage is exported
+			PodName:        "vc1-t1-pod0",
+			ClusterName:    "vc1",
+			TenantID:       "t1",
+			TenantCreation: 10,
+			DwsDequeue:     12,
+		},
+	}
+	buf := &bytes.Buffer{}
+	if err := ExportTrace(rsLst, buf); err != nil {
+		t.Fatalf("ExportTrace failed: %s", err)
+	}
+	tf := traceFile{}
+	if err := json.Unmarshal(buf.Bytes(), &tf); err != nil {
+		t.Fatalf("fail to unmarshal trace: %s", err)
+	}
+
+	var spans, processes int
+	for _, ev := range tf.TraceEvents {
+		switch {
+		case ev.Phase == "X":
+			spans++
+			if ev.Args["pod"] == "vc1-t1-pod0" && (ev.Name != "dwsQueue" || ev.Dur != 2000000) {
+				t.Fatalf("unexpected span for vc1-t1-pod0: %+v", ev)
+			}
+		case ev.Name == "process_name":
+			processes++
+		}
+	}
+	if spans != len(lifecycleStages)+1 {
+		t.Fatalf("want %d spans, get %d", len(lifecycleStages)+1, spans)
+	}
+	if processes != 2 {
+		t.Fatalf("want 2 processes, get %d", processes)
+	}
+}